@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// corsAllowedHeaders are the headers that the cross-origin requests can send.
+const corsAllowedHeaders = "Accept, Authorization, Content-Type, X-Requested-With"
+
+// corsPolicy is a middleware that allows the cross-origin requests from a set of origins, which can be changed while
+// the server is running.
+type corsPolicy struct {
+	origins atomic.Pointer[map[string]bool] // The origin "*" allows any origin, without credentials
+}
+
+// newCORSPolicy returns a new corsPolicy that allows the origins given on `origins` (see setOrigins).
+func newCORSPolicy(origins string) *corsPolicy {
+	c := &corsPolicy{}
+	c.setOrigins(origins)
+
+	return c
+}
+
+// setOrigins changes the allowed origins to the comma separated list `origins`. An empty list rejects the
+// cross-origin requests, and "*" allows any origin (without credentials).
+func (c *corsPolicy) setOrigins(origins string) {
+	allowed := make(map[string]bool)
+
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			allowed[o] = true
+		}
+	}
+
+	c.origins.Store(&allowed)
+}
+
+// allowOrigin returns the value of the header Access-Control-Allow-Origin for the requests from `origin` (empty if
+// they are not allowed), and whether they can send credentials. Only the origins given explicitly can send them.
+func (c *corsPolicy) allowOrigin(origin string) (string, bool) {
+	allowed := *c.origins.Load()
+
+	switch {
+	case allowed[origin]:
+		return origin, true
+	case allowed["*"]:
+		return "*", false
+	default:
+		return "", false
+	}
+}
+
+func (c *corsPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		allowOrigin, credentials := c.allowOrigin(origin)
+		if allowOrigin == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Answer the preflight requests, without passing them to the router.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
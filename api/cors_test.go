@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	assert := assert2.New(t)
+
+	c := newCORSPolicy("https://app.example.com, https://other.example.com/")
+	h := c.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v0/user/subscriptions", nil)
+		req.Header.Set("Origin", origin)
+
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		return res
+	}
+
+	res := request(http.MethodGet, "https://app.example.com")
+	assert.Equal("https://app.example.com", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", res.Header().Get("Access-Control-Allow-Credentials"))
+
+	res = request(http.MethodOptions, "https://other.example.com")
+	assert.Equal(http.StatusNoContent, res.Code, "the preflight requests should be answered")
+	assert.NotEmpty(res.Header().Get("Access-Control-Allow-Methods"))
+
+	res = request(http.MethodGet, "https://evil.example.com")
+	assert.Empty(res.Header().Get("Access-Control-Allow-Origin"), "the origins not allowed should be rejected")
+
+	c.setOrigins("*")
+
+	res = request(http.MethodGet, "https://evil.example.com")
+	assert.Equal("*", res.Header().Get("Access-Control-Allow-Origin"), "the new origins should be applied")
+	assert.Empty(res.Header().Get("Access-Control-Allow-Credentials"),
+		"only the origins given explicitly should be able to send credentials")
+}
@@ -1 +1,15 @@
 package handlers
+
+import "net/http"
+
+// RequireAuth is a middleware that rejects the requests that can't be authenticated using HTTP basic auth with the
+// credentials of one of the users.
+func (m *Manager) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := m.basicAuthUser(w, r); !ok {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"lincast/backup"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAccountSize is the maximum size (in bytes) of the account archives that can be restored.
+const maxAccountSize = 50 << 20 // 50MB
+
+// restoreTimeout is the maximum time to wait for the missing subscriptions of a restored account before restoring it
+// again over them.
+const restoreTimeout = time.Minute * 30
+
+// ExportAccountHandler returns an archive with the data of the user (subscriptions, queue, playback info, listening
+// history and settings). The query parameter 'format' can be "json" (default) or "zip".
+func (m *Manager) ExportAccountHandler(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = backup.FormatJSON
+	}
+
+	if format != backup.FormatJSON && format != backup.FormatZIP {
+		err := errorx.IllegalArgument.New("the query parameter 'format' should be '%s' or '%s'", backup.FormatJSON, backup.FormatZIP)
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Request rejected due to an unknown archive format")
+
+		return
+	}
+
+	acc, err := backup.ExportAccount(m.db.WithContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to export the account")
+
+		return
+	}
+
+	// Write the archive to a buffer first, so an error can still be reported with the right status code.
+	var buf bytes.Buffer
+
+	if err := backup.WriteAccount(&buf, acc, format); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to write the account archive")
+
+		return
+	}
+
+	contentType := "application/json"
+	if format == backup.FormatZIP {
+		contentType = "application/zip"
+	}
+
+	filename := fmt.Sprintf("lincast-account-%s.%s", acc.CreatedAt.Format("20060102-150405"), format)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := buf.WriteTo(w); err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to write the response to the request")
+	}
+}
+
+// RestoreAccountHandler restores an account archive (JSON or ZIP), sent as the body of the request or as the field
+// 'file' of a multipart form. The subscriptions whose podcast is not on the instance are sent to the update queue,
+// and the archive is restored again once they finish, so their history and queue entries are restored too. In that
+// case, the response is 202 Accepted and the batch of subscription jobs can be followed on the Location header.
+func (m *Manager) RestoreAccountHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAccountSize)
+
+	var archive io.Reader = r.Body
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The field 'file' of the form can't be read")
+
+			return
+		}
+		defer file.Close()
+
+		archive = file
+	}
+
+	acc, err := backup.ReadAccount(archive)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("The account archive can't be read")
+
+		return
+	}
+
+	missing, err := backup.MissingFeeds(m.db.WithContext(r.Context()), acc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to check the subscriptions of the account")
+
+		return
+	}
+
+	report, err := backup.RestoreAccount(m.db.WithContext(r.Context()), acc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to restore the account")
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr":    r.RemoteAddr,
+		"subscriptions": report.Subscriptions,
+		"missing":       len(missing),
+		"history":       report.History,
+		"queue":         report.Queue,
+	}).Info("Account restored")
+
+	if len(missing) == 0 {
+		writeJSON(w, r, http.StatusOK, report)
+
+		return
+	}
+
+	feeds := make([]feedRequest, 0, len(missing))
+	for _, s := range missing {
+		feeds = append(feeds, feedRequest{URL: s.FeedURL, Title: s.Title, Group: s.Group})
+	}
+
+	batch, _ := m.subscribeAll(r.Context(), feeds)
+
+	// The restore continues after answering, so it can't be cancelled along with the request.
+	restoreCtx := context.WithoutCancel(r.Context())
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+		defer cancel()
+
+		batch.Wait(ctx)
+
+		// Restoring is idempotent, so the archive is restored whole again now that the podcasts are stored.
+		report, err := backup.RestoreAccount(m.db.WithContext(restoreCtx), acc)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"batchID": batch.ID,
+				"error":   errorx.EnsureStackTrace(err),
+			}).Error("Error when trying to restore the account after subscribing to the missing podcasts")
+
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"batchID":       batch.ID,
+			"subscriptions": report.Subscriptions,
+			"history":       report.History,
+			"queue":         report.Queue,
+			"unmatched":     len(report.Unmatched),
+		}).Info("Account restored after subscribing to the missing podcasts")
+	}()
+
+	w.Header().Set("Location", "/api/v0/jobs/"+batch.ID.String())
+	writeJSON(w, r, http.StatusAccepted, report)
+}
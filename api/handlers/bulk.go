@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"lincast/podcasts"
+	"lincast/update"
+	"lincast/utils/safe"
+
+	"github.com/google/uuid"
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxBulkSubscriptions is the maximum number of feeds that can be sent on a single bulk subscription.
+const maxBulkSubscriptions = 500
+
+// feedRequest is a feed to subscribe to, as part of a bulk subscription.
+type feedRequest struct {
+	URL   string
+	Title string
+	Group string
+}
+
+// subscriptionResult is the outcome of the subscription to one of the feeds of a bulk subscription.
+type subscriptionResult struct {
+	URL       string                     `json:"url"`
+	Title     string                     `json:"title,omitempty"`
+	Group     string                     `json:"group,omitempty"`
+	JobID     *uuid.UUID                 `json:"jobID,omitempty"`
+	Status    update.JobStatus           `json:"status,omitempty"`
+	Outcome   update.SubscriptionOutcome `json:"outcome,omitempty"`
+	PodcastID uint                       `json:"podcastID,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+}
+
+// BulkSubscribeHandler subscribes to several feeds at once. Each feed is processed by the update queue as a
+// subscription job, and the outcome of each one is returned.
+func (m *Manager) BulkSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	wait, err := parseWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'wait' can't be parsed")
+
+		return
+	}
+
+	reqBody := struct {
+		URLs []string `json:"urls"`
+	}{}
+
+	err = json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	if len(reqBody.URLs) == 0 || len(reqBody.URLs) > maxBulkSubscriptions {
+		err := errorx.IllegalArgument.New("the number of URLs should be between 1 and %d", maxBulkSubscriptions)
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Request rejected due to the number of URLs")
+
+		return
+	}
+
+	feeds := make([]feedRequest, 0, len(reqBody.URLs))
+	for _, u := range reqBody.URLs {
+		feeds = append(feeds, feedRequest{URL: u})
+	}
+
+	batch, invalid := m.subscribeAll(r.Context(), feeds)
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"batchID":    batch.ID,
+		"feeds":      len(batch.Jobs),
+		"invalid":    len(invalid),
+	}).Info("Sending feeds to the update queue (bulk subscription)")
+
+	if wait {
+		waitJob(r, batch)
+	}
+
+	results := subscriptionResults(feeds, batch, invalid)
+
+	respondSubscriptions(w, r, batch, results)
+}
+
+// subscribeAll sends a subscription job to the update queue for each feed with a valid URL, returning a (tracked)
+// batch with all of them. Repeated URLs share the same job. The URLs that are not valid are returned separately.
+func (m *Manager) subscribeAll(ctx context.Context, feeds []feedRequest) (*update.Batch, map[string]bool) {
+	invalid := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	var jobs []*update.Job
+	var newJobs []*update.Job
+
+	for _, f := range feeds {
+		u := safe.Sanitize(f.URL)
+
+		if !podcasts.IsValidURL(u) {
+			invalid[u] = true
+
+			continue
+		}
+
+		if seen[u] {
+			continue
+		}
+
+		seen[u] = true
+
+		j := update.NewSubscriptionJob(u)
+		j.Group = f.Group
+
+		job, added := m.jobs.AddSubscriptionJob(j)
+		if added {
+			newJobs = append(newJobs, job)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	batch := update.NewBatch(jobs)
+	m.jobs.AddBatch(batch)
+
+	// The jobs that don't fit on the queue are reported with the outcome OutcomeError.
+	if err := m.enqueue(ctx, newJobs...); err != nil {
+		log.WithFields(log.Fields{
+			"batchID": batch.ID,
+			"error":   err.Error(),
+		}).Warning("Some of the feeds can't be subscribed, the update queue is full")
+	}
+
+	return batch, invalid
+}
+
+// subscriptionResults returns the result of the subscription to each one of the given feeds, in the same order.
+func subscriptionResults(feeds []feedRequest, batch *update.Batch, invalid map[string]bool) []subscriptionResult {
+	byURL := make(map[string]*update.Job, len(batch.Jobs))
+	for _, j := range batch.Jobs {
+		byURL[j.RequestedURL()] = j
+	}
+
+	results := make([]subscriptionResult, 0, len(feeds))
+
+	for _, f := range feeds {
+		u := safe.Sanitize(f.URL)
+		res := subscriptionResult{
+			URL:   u,
+			Title: f.Title,
+			Group: f.Group,
+		}
+
+		if invalid[u] {
+			res.Outcome = update.OutcomeInvalidURL
+			res.Error = "the given URL is not valid"
+		} else if j, ok := byURL[u]; ok {
+			id := j.ID
+			res.JobID = &id
+			res.Status = j.Status()
+			res.Outcome = j.Outcome()
+
+			if p := j.StoredPodcast(); p != nil {
+				res.PodcastID = p.ID
+			}
+
+			if err := j.Err(); err != nil {
+				res.Error = err.Error()
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// respondSubscriptions writes the results of a bulk subscription. The status code of the response is 200 OK if all
+// the jobs have finished and 202 Accepted if not.
+func respondSubscriptions(w http.ResponseWriter, r *http.Request, batch *update.Batch, results []subscriptionResult) {
+	response := struct {
+		ID       uuid.UUID            `json:"id"`
+		Finished bool                 `json:"finished"`
+		Results  []subscriptionResult `json:"results"`
+	}{
+		ID:       batch.ID,
+		Finished: batch.Finished(),
+		Results:  results,
+	}
+
+	status := http.StatusAccepted
+	if response.Finished {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v0/jobs/"+batch.ID.String())
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to encode the response to the request")
+
+		return
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// cooldown is a simple rate limiter that allows an action identified by a key to be performed only once during a
+// given period of time.
+type cooldown struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newCooldown() *cooldown {
+	return &cooldown{
+		until: make(map[string]time.Time),
+	}
+}
+
+// allow reports whether the action identified by `key` can be performed now, registering it if so (the action will
+// not be allowed again until `period` has passed). If the action is not allowed, the remaining time until it will be
+// is returned.
+func (c *cooldown) allow(key string, period time.Duration) (bool, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if t, ok := c.until[key]; ok && now.Before(t) {
+		return false, t.Sub(now)
+	}
+
+	// Clean the keys that are not useful anymore, so the map doesn't grow indefinitely.
+	for k, t := range c.until {
+		if !now.Before(t) {
+			delete(c.until, k)
+		}
+	}
+
+	c.until[key] = now.Add(period)
+
+	return true, 0
+}
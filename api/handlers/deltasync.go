@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lincast/models"
+	"lincast/repositories"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// maxSyncActions is the maximum number of actions that can be uploaded on a single request.
+const maxSyncActions = 1000
+
+// Types of the actions that can be uploaded to the sync endpoint.
+const (
+	syncActionProgress    = "progress"
+	syncActionPlayed      = "played"
+	syncActionQueueAdd    = "queue_add"
+	syncActionQueueRemove = "queue_remove"
+)
+
+// Results of the actions uploaded to the sync endpoint.
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict" // The server has a more recent change, the action has been discarded
+	syncInvalid  = "invalid"
+	syncFailed   = "failed" // The action couldn't be applied due to an error of the server, it should be sent again
+)
+
+// syncChanges are the changes made since a cursor, returned by SyncHandler.
+type syncChanges struct {
+	// Cursor must be sent as the query parameter 'since' on the next request to get only the newer changes.
+	Cursor          string                `json:"cursor"`
+	Podcasts        []models.Podcast      `json:"podcasts"`        // New or updated subscriptions
+	RemovedPodcasts []uint                `json:"removedPodcasts"` // Unsubscribed or deleted podcasts
+	Episodes        []models.Episode      `json:"episodes"`        // New or updated episodes, including progress and played state
+	RemovedEpisodes []uint                `json:"removedEpisodes"`
+	Queue           []models.QueueEpisode `json:"queue"` // The queue is always sent whole, since it's replaced as a unit
+}
+
+// syncAction is an action recorded by a client (maybe while offline) to be applied on the server.
+type syncAction struct {
+	Type      string         `json:"type"` // One of syncActionProgress, syncActionPlayed, syncActionQueueAdd or syncActionQueueRemove
+	EpisodeID uint           `json:"episodeID"`
+	Progress  *time.Duration `json:"progress,omitempty"` // Only for syncActionProgress
+	Played    *bool          `json:"played,omitempty"`   // Only for syncActionPlayed
+	Timestamp time.Time      `json:"timestamp"`          // When the action was done on the client
+}
+
+// syncActionResult is the outcome of one of the uploaded actions.
+type syncActionResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SyncHandler returns all the changes on subscriptions, episodes (including progress and played state) and queue
+// made since the cursor of the query parameter 'since'. Without cursor, the whole state is returned.
+func (m *Manager) SyncHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := parseCursor(r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'since' can't be parsed")
+
+		return
+	}
+
+	changes, err := m.changesSince(r.Context(), since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the changes since the cursor")
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, changes)
+}
+
+// UploadSyncActionsHandler applies a batch of actions recorded by a client. When a change over the same state of the
+// episode (progress, played state or queue) more recent than the action has been applied, the action is discarded and
+// reported as a conflict. The changes synced are compared by the time recorded by their clients, and the ones done
+// through the rest of the API by the time of the server, so the clocks of the clients are assumed to agree with it.
+func (m *Manager) UploadSyncActionsHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Actions []syncAction `json:"actions"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	if len(reqBody.Actions) > maxSyncActions {
+		err := errorx.IllegalArgument.New("the number of actions can't be greater than %d", maxSyncActions)
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Request rejected due to the number of actions")
+
+		return
+	}
+
+	results := make([]syncActionResult, 0, len(reqBody.Actions))
+	applied := 0
+
+	for i, a := range reqBody.Actions {
+		status, err := m.applySyncAction(r.Context(), a)
+
+		result := syncActionResult{Index: i, Status: status}
+		if err != nil {
+			result.Error = err.Error()
+
+			if status != syncInvalid {
+				log.WithFields(log.Fields{
+					"remoteAddr": r.RemoteAddr,
+					"episodeID":  a.EpisodeID,
+					"error":      errorx.EnsureStackTrace(err),
+				}).Error("Error when trying to apply a sync action")
+			}
+		}
+
+		if status == syncApplied {
+			applied++
+		}
+
+		results = append(results, result)
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"actions":    len(results),
+		"applied":    applied,
+	}).Info("Sync actions uploaded")
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
+}
+
+// parseCursor parses a cursor returned by SyncHandler. An empty cursor is the zero time.
+// Possible errors:
+//   - errorx.IllegalArgument: if the cursor is not valid.
+func parseCursor(cursor string) (time.Time, error) {
+	if cursor == "" {
+		return time.Time{}, nil
+	}
+
+	ms, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || ms < 0 {
+		return time.Time{}, errorx.IllegalArgument.New("the cursor '%s' is not valid", cursor)
+	}
+
+	return time.UnixMilli(ms), nil
+}
+
+// changesSince returns the changes made since the given time. The rows updated at the same time as the cursor are
+// returned again on the next call, so clients must apply the changes idempotently.
+func (m *Manager) changesSince(ctx context.Context, since time.Time) (*syncChanges, error) {
+	now := time.Now()
+
+	changes := &syncChanges{
+		Cursor:          strconv.FormatInt(now.UnixMilli(), 10),
+		Podcasts:        []models.Podcast{},
+		RemovedPodcasts: []uint{},
+		Episodes:        []models.Episode{},
+		RemovedEpisodes: []uint{},
+		Queue:           []models.QueueEpisode{},
+	}
+
+	var podcasts []struct {
+		ID         uint
+		Subscribed bool
+		DeletedAt  gorm.DeletedAt
+	}
+
+	err := m.db.WithContext(ctx).Unscoped().Model(&models.Podcast{}).
+		Select("id, subscribed, deleted_at").
+		Where("updated_at >= ? OR deleted_at >= ?", since, since).
+		Find(&podcasts).Error
+	if err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the changed podcasts can't be obtained")
+	}
+
+	var subscribed []uint
+	for _, p := range podcasts {
+		if p.Subscribed && !p.DeletedAt.Valid {
+			subscribed = append(subscribed, p.ID)
+		} else if !since.IsZero() {
+			changes.RemovedPodcasts = append(changes.RemovedPodcasts, p.ID)
+		}
+	}
+
+	if len(subscribed) > 0 {
+		if err := m.db.WithContext(ctx).Where("id IN ?", subscribed).Find(&changes.Podcasts).Error; err != nil {
+			return nil, errorx.InternalError.Wrap(err, "the changed podcasts can't be obtained")
+		}
+	}
+
+	var episodes []models.Episode
+
+	err = m.db.WithContext(ctx).Unscoped().
+		Where("updated_at >= ? OR deleted_at >= ?", since, since).
+		Order("id").
+		Find(&episodes).Error
+	if err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the changed episodes can't be obtained")
+	}
+
+	for _, ep := range episodes {
+		if ep.DeletedAt.Valid {
+			if !since.IsZero() {
+				changes.RemovedEpisodes = append(changes.RemovedEpisodes, ep.ID)
+			}
+
+			continue
+		}
+
+		changes.Episodes = append(changes.Episodes, ep)
+	}
+
+	if err := m.db.WithContext(ctx).Order("position").Find(&changes.Queue).Error; err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the queue can't be obtained")
+	}
+
+	return changes, nil
+}
+
+// applySyncAction applies the action if there isn't a more recent change over the same episode. Returns the status
+// of the action (syncApplied, syncConflict, syncInvalid or syncFailed).
+func (m *Manager) applySyncAction(ctx context.Context, a syncAction) (string, error) {
+	if a.Timestamp.IsZero() {
+		return syncInvalid, errorx.IllegalArgument.New("the field 'timestamp' is required")
+	}
+
+	var ep models.Episode
+
+	if err := m.db.WithContext(ctx).First(&ep, a.EpisodeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return syncInvalid, errorx.IllegalArgument.New("the episode %d does not exist", a.EpisodeID)
+		}
+
+		return syncFailed, err
+	}
+
+	episodes := repositories.NewEpisodeRepository(m.db.WithContext(ctx))
+
+	switch a.Type {
+	case syncActionProgress:
+		if a.Progress == nil {
+			return syncInvalid, errorx.IllegalArgument.New("the field 'progress' is required")
+		}
+
+		return syncStatus(episodes.UpdateProgress(ep.ID, *a.Progress, a.Timestamp))
+
+	case syncActionPlayed:
+		if a.Played == nil {
+			return syncInvalid, errorx.IllegalArgument.New("the field 'played' is required")
+		}
+
+		return syncStatus(episodes.UpdatePlayed(ep.ID, *a.Played, a.Timestamp))
+
+	case syncActionQueueAdd:
+		return m.syncQueueAdd(ctx, ep.ID, a.Timestamp)
+
+	case syncActionQueueRemove:
+		return m.syncQueueRemove(ctx, ep.ID, a.Timestamp)
+
+	default:
+		return syncInvalid, errorx.IllegalArgument.New("unknown action type '%s'", a.Type)
+	}
+}
+
+// syncStatus returns the status of an action over the progress or played state of an episode, given the result of
+// the update.
+func syncStatus(updated bool, err error) (string, error) {
+	if err != nil {
+		return syncFailed, err
+	}
+
+	if !updated {
+		return syncConflict, nil
+	}
+
+	return syncApplied, nil
+}
+
+// syncQueueAdd appends the episode to the queue, unless it's already there or it has been removed from the queue
+// after the action was done. An episode that was removed before is restored to its former position.
+func (m *Manager) syncQueueAdd(ctx context.Context, episodeID uint, timestamp time.Time) (string, error) {
+	var entry models.QueueEpisode
+
+	res := m.db.WithContext(ctx).Unscoped().Where("episode_id = ?", episodeID).Order("id desc").Limit(1).Find(&entry)
+	if res.Error != nil {
+		return syncFailed, res.Error
+	}
+
+	if res.RowsAffected != 0 {
+		if !entry.DeletedAt.Valid {
+			return syncApplied, nil
+		}
+
+		if timestamp.Before(entry.ChangedAt) {
+			return syncConflict, nil
+		}
+
+		err := m.db.WithContext(ctx).Unscoped().Model(&entry).Updates(map[string]interface{}{
+			"deleted_at": nil,
+			"changed_at": timestamp,
+		}).Error
+		if err != nil {
+			return syncFailed, err
+		}
+
+		return syncApplied, nil
+	}
+
+	var last uint
+
+	err := m.db.WithContext(ctx).Model(&models.QueueEpisode{}).Select("COALESCE(MAX(position), 0)").Scan(&last).Error
+	if err != nil {
+		return syncFailed, err
+	}
+
+	err = m.db.WithContext(ctx).Create(&models.QueueEpisode{EpisodeID: episodeID, Position: last + 1, ChangedAt: timestamp}).Error
+	if err != nil {
+		return syncFailed, err
+	}
+
+	return syncApplied, nil
+}
+
+// syncQueueRemove removes the episode from the queue, unless it has been added again after the action was done.
+func (m *Manager) syncQueueRemove(ctx context.Context, episodeID uint, timestamp time.Time) (string, error) {
+	var entry models.QueueEpisode
+
+	res := m.db.WithContext(ctx).Where("episode_id = ?", episodeID).Limit(1).Find(&entry)
+	if res.Error != nil {
+		return syncFailed, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return syncApplied, nil
+	}
+
+	if timestamp.Before(entry.ChangedAt) {
+		return syncConflict, nil
+	}
+
+	if _, err := repositories.NewQueueRepository(m.db.WithContext(ctx)).RemoveWhere(timestamp, "id = ?", entry.ID); err != nil {
+		return syncFailed, err
+	}
+
+	return syncApplied, nil
+}
@@ -0,0 +1,566 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lincast/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// gpodderTimeLayout is the format of the timestamps of the episode actions on the gpodder.net API (always UTC).
+const gpodderTimeLayout = "2006-01-02T15:04:05"
+
+// gpodderDevice is the representation of a device on the gpodder.net API.
+type gpodderDevice struct {
+	ID            string `json:"id"`
+	Caption       string `json:"caption"`
+	Type          string `json:"type"`
+	Subscriptions int64  `json:"subscriptions"`
+}
+
+// gpodderAction is the representation of an episode action on the gpodder.net API.
+type gpodderAction struct {
+	Podcast   string `json:"podcast"`
+	Episode   string `json:"episode"`
+	GUID      string `json:"guid,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Action    string `json:"action"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Started   *int   `json:"started,omitempty"`
+	Position  *int   `json:"position,omitempty"`
+	Total     *int   `json:"total,omitempty"`
+}
+
+// toModel validates the action and converts it to a models.EpisodeAction.
+// Possible errors:
+//   - errorx.IllegalArgument: if a required field is missing or the action is unknown.
+//   - errorx.IllegalFormat: if the timestamp can't be parsed.
+func (a gpodderAction) toModel() (models.EpisodeAction, error) {
+	action := strings.ToLower(a.Action)
+
+	switch action {
+	case episodeActionDownload, episodeActionPlay, episodeActionDelete, episodeActionNew:
+	default:
+		return models.EpisodeAction{}, errorx.IllegalArgument.New("unknown episode action '%s'", a.Action)
+	}
+
+	if a.Podcast == "" || a.Episode == "" {
+		return models.EpisodeAction{}, errorx.IllegalArgument.New("the fields 'podcast' and 'episode' are required")
+	}
+
+	ts := time.Now().UTC()
+	if a.Timestamp != "" {
+		var err error
+
+		ts, err = parseActionTime(a.Timestamp)
+		if err != nil {
+			return models.EpisodeAction{}, err
+		}
+	}
+
+	ea := models.EpisodeAction{
+		DeviceID:   a.Device,
+		PodcastURL: a.Podcast,
+		EpisodeURL: a.Episode,
+		GUID:       a.GUID,
+		Action:     action,
+		Timestamp:  ts,
+	}
+
+	if a.Started != nil {
+		ea.Started = *a.Started
+	}
+
+	if a.Position != nil {
+		ea.Position = *a.Position
+	}
+
+	if a.Total != nil {
+		ea.Total = *a.Total
+	}
+
+	return ea, nil
+}
+
+// fromModel converts a models.EpisodeAction to its representation on the gpodder.net API.
+func fromModel(ea models.EpisodeAction) gpodderAction {
+	a := gpodderAction{
+		Podcast:   ea.PodcastURL,
+		Episode:   ea.EpisodeURL,
+		GUID:      ea.GUID,
+		Device:    ea.DeviceID,
+		Action:    ea.Action,
+		Timestamp: ea.Timestamp.UTC().Format(gpodderTimeLayout),
+	}
+
+	if ea.Action == episodeActionPlay {
+		started, position, total := ea.Started, ea.Position, ea.Total
+		a.Started, a.Position, a.Total = &started, &position, &total
+	}
+
+	return a
+}
+
+// parseActionTime parses the timestamp of an episode action. The clients send it in the format of
+// gpodderTimeLayout, but RFC 3339 timestamps are accepted too.
+// Possible errors:
+//   - errorx.IllegalFormat: if the timestamp doesn't have any of the accepted formats.
+func parseActionTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(gpodderTimeLayout, s, time.UTC); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errorx.IllegalFormat.New("the timestamp '%s' can't be parsed", s)
+	}
+
+	return t.UTC(), nil
+}
+
+// gpodderUser authenticates the request using HTTP basic auth and returns the user, that should match the one in
+// the path of the request. If the user can't be authenticated, an error is written on `w` and false is returned.
+func (m *Manager) gpodderUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	if username, _, _ := r.BasicAuth(); username != jsonParam(r, "username") {
+		m.unauthorized(w, r, username)
+
+		return nil, false
+	}
+
+	return m.basicAuthUser(w, r)
+}
+
+// basicAuthUser authenticates the request using HTTP basic auth and returns the user. If the user can't be
+// authenticated, an error is written on `w` and false is returned.
+func (m *Manager) basicAuthUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		m.unauthorized(w, r, username)
+
+		return nil, false
+	}
+
+	var user models.User
+
+	err := m.db.WithContext(r.Context()).Where("username = ?", username).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the user from db")
+
+		return nil, false
+	}
+
+	if err != nil || !user.CheckPassword(password) {
+		m.unauthorized(w, r, username)
+
+		return nil, false
+	}
+
+	return &user, true
+}
+
+func (m *Manager) unauthorized(w http.ResponseWriter, r *http.Request, username string) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+m.realm+`"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"username":   username,
+	}).Warn("Request rejected due to invalid credentials")
+}
+
+// jsonParam returns the URL parameter with the given key, without the ".json" suffix used by the gpodder.net API.
+func jsonParam(r *http.Request, key string) string {
+	return strings.TrimSuffix(chi.URLParam(r, key), ".json")
+}
+
+// sinceParam returns the value of the query parameter 'since' (0 if it's not present).
+func sinceParam(r *http.Request) (int64, error) {
+	s := r.URL.Query().Get("since")
+	if s == "" {
+		return 0, nil
+	}
+
+	since, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || since < 0 {
+		return 0, errorx.IllegalArgument.New("the query parameter 'since' should be a non-negative unix timestamp")
+	}
+
+	return since, nil
+}
+
+// GpodderLoginHandler checks the credentials of the user. The rest of endpoints authenticate every request, so no
+// session is created.
+func (m *Manager) GpodderLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.gpodderUser(w, r); !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GpodderLogoutHandler exists for compatibility with the clients, there is no session to close.
+func (m *Manager) GpodderLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// GpodderDevicesHandler returns the devices registered by the user.
+func (m *Manager) GpodderDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.gpodderUser(w, r)
+	if !ok {
+		return
+	}
+
+	var devices []models.Device
+
+	if res := m.db.WithContext(r.Context()).Where("user_id = ?", user.ID).Order("device_id").Find(&devices); res.Error != nil {
+		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(res.Error),
+		}).Error("Error when trying to get the devices from db")
+
+		return
+	}
+
+	var subscriptions int64
+
+	err := m.db.WithContext(r.Context()).Table("subscriptions").Where("user_id = ?", user.ID).Count(&subscriptions).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to count the subscriptions of the user")
+
+		return
+	}
+
+	res := make([]gpodderDevice, 0, len(devices))
+	for _, d := range devices {
+		res = append(res, gpodderDevice{ID: d.DeviceID, Caption: d.Caption, Type: d.Type, Subscriptions: subscriptions})
+	}
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// GpodderUpdateDeviceHandler registers a device of the user or updates its caption and type.
+func (m *Manager) GpodderUpdateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.gpodderUser(w, r)
+	if !ok {
+		return
+	}
+
+	reqBody := struct {
+		Caption *string `json:"caption"`
+		Type    *string `json:"type"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	device, err := m.device(r.Context(), user, jsonParam(r, "deviceid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the device from db")
+
+		return
+	}
+
+	if reqBody.Caption != nil {
+		device.Caption = *reqBody.Caption
+	}
+
+	if reqBody.Type != nil {
+		device.Type = *reqBody.Type
+	}
+
+	if res := m.db.WithContext(r.Context()).Save(device); res.Error != nil {
+		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(res.Error),
+		}).Error("Error when trying to store the device")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// device returns the device of the user with the given identifier, registering it if it doesn't exist.
+func (m *Manager) device(ctx context.Context, user *models.User, deviceID string) (*models.Device, error) {
+	device := models.Device{UserID: user.ID, DeviceID: deviceID, Type: "other"}
+
+	err := m.db.WithContext(ctx).Where("user_id = ? AND device_id = ?", user.ID, deviceID).FirstOrCreate(&device).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GpodderSubscriptionsHandler returns the changes on the subscriptions of the user since the timestamp of the query
+// parameter 'since' (GET) or uploads the changes made on a device (POST).
+func (m *Manager) GpodderSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.gpodderUser(w, r)
+	if !ok {
+		return
+	}
+
+	deviceID := jsonParam(r, "deviceid")
+
+	if _, err := m.device(r.Context(), user, deviceID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the device from db")
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		m.getSubscriptionChanges(w, r, user)
+
+	case http.MethodPost:
+		m.uploadSubscriptionChanges(w, r, user, deviceID)
+	}
+}
+
+func (m *Manager) getSubscriptionChanges(w http.ResponseWriter, r *http.Request, user *models.User) {
+	since, err := sinceParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'since' can't be parsed")
+
+		return
+	}
+
+	timestamp := time.Now().Unix()
+
+	add, remove, err := m.subscriptionChangesSince(r.Context(), user.ID, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the subscription changes")
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"add":       add,
+		"remove":    remove,
+		"timestamp": timestamp,
+	})
+}
+
+func (m *Manager) uploadSubscriptionChanges(w http.ResponseWriter, r *http.Request, user *models.User, deviceID string) {
+	reqBody := struct {
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	removed := make(map[string]bool, len(reqBody.Remove))
+	for _, u := range reqBody.Remove {
+		removed[u] = true
+	}
+
+	for _, u := range reqBody.Add {
+		if removed[u] {
+			err := errorx.IllegalArgument.New("the URL '%s' can't be both added and removed", u)
+
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("Request rejected due to conflicting subscription changes")
+
+			return
+		}
+	}
+
+	timestamp, err := m.applySubscriptionChanges(r.Context(), user.ID, deviceID, reqBody.Add, reqBody.Remove)
+	if err != nil {
+		if errorx.IsOfType(err, errorx.IllegalState) {
+			queueFull(w, err)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to apply the subscription changes")
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"device":     deviceID,
+		"added":      len(reqBody.Add),
+		"removed":    len(reqBody.Remove),
+	}).Info("Subscription changes uploaded")
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"timestamp":   timestamp,
+		"update_urls": [][]string{},
+	})
+}
+
+// GpodderEpisodeActionsHandler returns the episode actions of the user (GET) or uploads new ones (POST).
+func (m *Manager) GpodderEpisodeActionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.gpodderUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		m.getEpisodeActions(w, r, user)
+
+	case http.MethodPost:
+		m.uploadEpisodeActions(w, r, user)
+	}
+}
+
+func (m *Manager) getEpisodeActions(w http.ResponseWriter, r *http.Request, user *models.User) {
+	since, err := sinceParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'since' can't be parsed")
+
+		return
+	}
+
+	q := r.URL.Query()
+	aggregated, _ := strconv.ParseBool(q.Get("aggregated"))
+	timestamp := time.Now().Unix()
+
+	actions, err := m.episodeActionsSince(r.Context(), user.ID, since, q.Get("podcast"), q.Get("device"), aggregated)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the episode actions")
+
+		return
+	}
+
+	res := make([]gpodderAction, 0, len(actions))
+	for _, a := range actions {
+		res = append(res, fromModel(a))
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"actions":   res,
+		"timestamp": timestamp,
+	})
+}
+
+func (m *Manager) uploadEpisodeActions(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var reqBody []gpodderAction
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	actions := make([]models.EpisodeAction, 0, len(reqBody))
+
+	for _, a := range reqBody {
+		ea, err := a.toModel()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("Request rejected due to an invalid episode action")
+
+			return
+		}
+
+		actions = append(actions, ea)
+	}
+
+	timestamp, err := m.storeEpisodeActions(r.Context(), user.ID, actions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to store the episode actions")
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"timestamp":   timestamp,
+		"update_urls": [][]string{},
+	})
+}
@@ -1 +1,170 @@
 package handlers
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"lincast/history"
+	"lincast/opml"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxHistorySize is the maximum size (in bytes) of the listening history exports that can be imported.
+const maxHistorySize = 100 << 20 // 100MB
+
+// Formats of the listening history exports that can be imported.
+const (
+	historyFormatCSV        = "csv"
+	historyFormatAntennaPod = "antennapod"
+)
+
+// ImportHistoryHandler imports the played state and the positions of the episodes from the export of another podcast
+// app. The format is given by the query parameter 'format' ("csv" or "antennapod") and the export is sent as the body
+// of the request or as the field 'file' of a multipart form (that can also contain, on the field 'opml', the OPML
+// export of the same app to resolve podcast titles on CSV exports). If the query parameter 'dryRun' is true, the
+// episodes are only matched, and the report of the import is returned.
+func (m *Manager) ImportHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format != historyFormatCSV && format != historyFormatAntennaPod {
+		err := errorx.IllegalArgument.New("the query parameter 'format' should be '%s' or '%s'", historyFormatCSV, historyFormatAntennaPod)
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Request rejected due to an unknown history format")
+
+		return
+	}
+
+	dryRun := false
+
+	if keys, ok := r.URL.Query()["dryRun"]; ok && len(keys[0]) > 0 {
+		d, err := strconv.ParseBool(keys[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The query parameter 'dryRun' can't be parsed")
+
+			return
+		}
+
+		dryRun = d
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxHistorySize)
+
+	var export io.Reader = r.Body
+	var feeds []opml.Feed
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The field 'file' of the form can't be read")
+
+			return
+		}
+		defer file.Close()
+
+		export = file
+
+		if doc, _, err := r.FormFile("opml"); err == nil {
+			feeds, err = opml.Parse(doc)
+			doc.Close()
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+
+				log.WithFields(log.Fields{
+					"remoteAddr": r.RemoteAddr,
+					"error":      errorx.EnsureStackTrace(err),
+				}).Error("The OPML document can't be parsed")
+
+				return
+			}
+		}
+	}
+
+	var records []history.Record
+	var err error
+
+	if format == historyFormatCSV {
+		records, err = history.ParseCSV(export, feeds)
+	} else {
+		records, err = parseAntennaPodExport(export)
+	}
+
+	if err != nil {
+		status := http.StatusBadRequest
+		if !errorx.IsOfType(err, errorx.IllegalFormat) {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"format":     format,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("The listening history can't be parsed")
+
+		return
+	}
+
+	report, err := history.Import(m.db.WithContext(r.Context()), records, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to import the listening history")
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"format":     format,
+		"dryRun":     dryRun,
+		"total":      report.Total,
+		"matched":    report.Matched,
+	}).Info("Listening history imported")
+
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// parseAntennaPodExport stores the AntennaPod database export on a temporary file, since it can only be read from
+// disk, and parses it.
+func parseAntennaPodExport(export io.Reader) ([]history.Record, error) {
+	f, err := os.CreateTemp("", "lincast-antennapod-*.db")
+	if err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the temporary file can't be created")
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, export)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the AntennaPod database can't be read")
+	}
+
+	return history.ParseAntennaPod(f.Name())
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lincast/update"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// waiter is implemented by update.Job and update.Batch.
+type waiter interface {
+	Wait(ctx context.Context) bool
+	Finished() bool
+}
+
+func (m *Manager) JobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.requestedJob(w, r)
+	if !ok {
+		return
+	}
+
+	m.respondJob(w, r, job, r.URL.Path)
+}
+
+// JobEventsHandler streams the state of a job (or batch of jobs) using server-sent events. A new event is sent each
+// time the state changes, and the stream is closed once the job has finished.
+func (m *Manager) JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.requestedJob(w, r)
+	if !ok {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// The stream can last longer than the write timeout of the server.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Debug("The write deadline of the stream can't be removed")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(jobEventsInterval)
+	defer ticker.Stop()
+
+	var last []byte
+
+	for {
+		// Check it before encoding the job, so the last event always contains its final state.
+		finished := job.Finished()
+
+		data, err := json.Marshal(job)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      errorx.EnsureStackTrace(err),
+			}).Error("Error when trying to encode the state of the job")
+
+			return
+		}
+
+		if !bytes.Equal(data, last) {
+			if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+				return
+			}
+
+			if err := rc.Flush(); err != nil {
+				log.WithFields(log.Fields{
+					"remoteAddr": r.RemoteAddr,
+					"error":      err.Error(),
+				}).Error("The events of the job can't be streamed")
+
+				return
+			}
+
+			last = data
+		}
+
+		if finished {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requestedJob returns the job (or batch of jobs) referenced by the URL param 'id'. If it can't be found, the error
+// is written to `w` and false is returned.
+func (m *Manager) requestedJob(w http.ResponseWriter, r *http.Request) (waiter, bool) {
+	idStr := chi.URLParam(r, "id")
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The given job ID cannot be parsed")
+
+		return nil, false
+	}
+
+	if j, ok := m.jobs.Job(id); ok {
+		return j, true
+	}
+
+	if b, ok := m.jobs.Batch(id); ok {
+		return b, true
+	}
+
+	http.Error(w, "the job with the given ID does not exist", http.StatusNotFound)
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"givenID":    id,
+	}).Error("Error when trying to get the requested job")
+
+	return nil, false
+}
+
+// enqueue sends the given jobs to the update queue without blocking the caller. The jobs stay pending until a worker
+// takes them. The jobs are part of the trace carried by `ctx` (usually the one of the request that created them).
+// If the queue is full, the jobs that don't fit are rejected (so they are finished with an error).
+// Possible errors:
+//   - errorx.IllegalState: if any of the jobs has been rejected because the queue is full.
+func (m *Manager) enqueue(ctx context.Context, jobs ...*update.Job) error {
+	var err error
+
+	for _, j := range jobs {
+		j.SetParent(ctx)
+
+		if err != nil {
+			j.Reject(err)
+
+			continue
+		}
+
+		select {
+		case m.updateChannel <- j:
+		default:
+			err = errorx.IllegalState.New("the update queue is full")
+			j.Reject(err)
+		}
+	}
+
+	return err
+}
+
+// queueFull answers the request telling the client that the update queue is full and it should retry later.
+func queueFull(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(queueFullRetryAfter.Seconds())))
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// parseWait returns the value of the optional query parameter 'wait'.
+func parseWait(r *http.Request) (bool, error) {
+	keys, ok := r.URL.Query()["wait"]
+	if !ok || len(keys[0]) < 1 {
+		return false, nil
+	}
+
+	wait, err := strconv.ParseBool(keys[0])
+	if err != nil {
+		return false, errorx.IllegalArgument.Wrap(err, "the value of the param 'wait' can't be parsed")
+	}
+
+	return wait, nil
+}
+
+// waitJob blocks until the given job finishes, the request is cancelled or maxWaitTime is reached, whichever happens
+// first.
+func waitJob(r *http.Request, job waiter) {
+	ctx, cancel := context.WithTimeout(r.Context(), maxWaitTime)
+	defer cancel()
+
+	job.Wait(ctx)
+}
+
+// respondJob writes the representation of the given job (or batch of jobs). The status code of the response is
+// 200 OK if the job has finished and 202 Accepted if not.
+func (m *Manager) respondJob(w http.ResponseWriter, r *http.Request, job waiter, location string) {
+	status := http.StatusAccepted
+	if job.Finished() {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to encode the response to the request")
+
+		return
+	}
+}
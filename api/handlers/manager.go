@@ -1,24 +1,67 @@
 package handlers
 
 import (
-	"lincast/models"
+	"sync/atomic"
+	"time"
+
+	"lincast/update"
 
 	"gorm.io/gorm"
 )
 
+const (
+	// DefaultRefreshCooldown is the default minimum time between two manual refreshes of the same podcast.
+	DefaultRefreshCooldown = time.Minute
+	// DefaultRefreshAllCooldown is the default minimum time between two manual refreshes of the entire library.
+	DefaultRefreshAllCooldown = time.Minute * 5
+	// maxWaitTime is the maximum time that a request will block waiting for a job to finish. It should be lower than the
+	// write timeout of the server.
+	maxWaitTime = time.Second * 10
+	// jobEventsInterval is the frequency with which the state of a job is checked when it's being streamed.
+	jobEventsInterval = time.Millisecond * 500
+	// queueFullRetryAfter is the time after which the clients are told to retry the requests rejected because the
+	// update queue is full.
+	queueFullRetryAfter = time.Minute
+)
+
 type Manager struct {
-	updateChannel chan *models.Podcast
+	updateChannel chan *update.Job
+	queue         *update.UpdateQueue // Its workers are managed by the admin handlers (see SetUpdateQueue)
 	db            *gorm.DB
+	jobs          *update.Tracker
+	refreshLimit  *cooldown
+	// Minimum times between two manual refreshes of the same podcast and of the entire library (see SetRefreshCooldowns).
+	refreshCooldown    atomic.Int64
+	refreshAllCooldown atomic.Int64
+	realm              string // Sent to the clients of the APIs with basic authentication when asking for credentials
 }
 
 // NewManager returns a new Manager. The `Manager` is who provides the access to the handlers. The unique function of
 // this is to provide the access to the database in an ordered way to all the handlers, without the usage of global
 // variables.
-func NewManager(db *gorm.DB, manualUpdate chan *models.Podcast) *Manager {
+func NewManager(db *gorm.DB, manualUpdate chan *update.Job, realm string) *Manager {
 	m := Manager{
 		updateChannel: manualUpdate,
 		db:            db,
+		jobs:          update.NewTracker(update.DefaultRetention),
+		refreshLimit:  newCooldown(),
+		realm:         realm,
 	}
 
+	m.SetRefreshCooldowns(DefaultRefreshCooldown, DefaultRefreshAllCooldown)
+
 	return &m
 }
+
+// SetUpdateQueue sets the queue that processes the jobs sent through the update channel, whose workers are managed by
+// the admin handlers.
+func (m *Manager) SetUpdateQueue(queue *update.UpdateQueue) {
+	m.queue = queue
+}
+
+// SetRefreshCooldowns changes the minimum time between two manual refreshes of the same podcast (`podcast`) and of the
+// entire library (`all`). It can be called while the handlers are serving requests.
+func (m *Manager) SetRefreshCooldowns(podcast, all time.Duration) {
+	m.refreshCooldown.Store(int64(podcast))
+	m.refreshAllCooldown.Store(int64(all))
+}
@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"path/filepath"
 	"testing"
 
 	"lincast/database"
-	"lincast/models"
+	"lincast/update"
 
 	assert2 "github.com/stretchr/testify/assert"
 )
@@ -12,12 +13,12 @@ import (
 func TestNewManager(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
 
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 	assert.NotNil(mng, "A valid instance of Manager should be returned")
 }
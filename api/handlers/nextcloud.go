@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"lincast/models"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// nextcloudDevice is the device to which the changes made through the Nextcloud gPodder Sync API are attributed,
+// since that API doesn't identify the clients.
+const nextcloudDevice = "gpoddersync"
+
+// NextcloudSubscriptionsHandler returns the changes on the subscriptions of the user since the timestamp of the
+// query parameter 'since', following the API of the Nextcloud gPodder Sync app.
+func (m *Manager) NextcloudSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.basicAuthUser(w, r)
+	if !ok {
+		return
+	}
+
+	m.getSubscriptionChanges(w, r, user)
+}
+
+// NextcloudSubscriptionChangeHandler uploads changes on the subscriptions of the user, following the API of the
+// Nextcloud gPodder Sync app.
+func (m *Manager) NextcloudSubscriptionChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.basicAuthUser(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := m.device(r.Context(), user, nextcloudDevice); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the device from db")
+
+		return
+	}
+
+	m.uploadSubscriptionChanges(w, r, user, nextcloudDevice)
+}
+
+// NextcloudEpisodeActionsHandler returns the episode actions of the user received since the timestamp of the query
+// parameter 'since', following the API of the Nextcloud gPodder Sync app.
+func (m *Manager) NextcloudEpisodeActionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.basicAuthUser(w, r)
+	if !ok {
+		return
+	}
+
+	since, err := sinceParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'since' can't be parsed")
+
+		return
+	}
+
+	timestamp := time.Now().Unix()
+
+	actions, err := m.episodeActionsSince(r.Context(), user.ID, since, "", "", false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to get the episode actions")
+
+		return
+	}
+
+	res := make([]gpodderAction, 0, len(actions))
+	for _, a := range actions {
+		res = append(res, fromModel(a))
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"actions":   res,
+		"timestamp": timestamp,
+	})
+}
+
+// NextcloudCreateEpisodeActionsHandler uploads episode actions of the user, following the API of the Nextcloud
+// gPodder Sync app. The actions are applied over the progress and played status of the episodes.
+func (m *Manager) NextcloudCreateEpisodeActionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.basicAuthUser(w, r)
+	if !ok {
+		return
+	}
+
+	var reqBody []gpodderAction
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	actions := make([]models.EpisodeAction, 0, len(reqBody))
+
+	for _, a := range reqBody {
+		ea, err := a.toModel()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("Request rejected due to an invalid episode action")
+
+			return
+		}
+
+		if ea.DeviceID == "" {
+			ea.DeviceID = nextcloudDevice
+		}
+
+		actions = append(actions, ea)
+	}
+
+	timestamp, err := m.storeEpisodeActions(r.Context(), user.ID, actions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to store the episode actions")
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"timestamp": timestamp,
+	})
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lincast/models"
+	"lincast/opml"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxOPMLSize is the maximum size (in bytes) of the OPML documents that can be imported.
+const maxOPMLSize = 5 << 20 // 5MB
+
+// ImportOPMLHandler subscribes to all the feeds referenced by an OPML document, sent as the body of the request or as
+// the field 'file' of a multipart form. The folders of the document are preserved as the groups of the podcasts. As
+// with the bulk subscriptions, documents with more than maxBulkSubscriptions feeds are rejected.
+func (m *Manager) ImportOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	wait, err := parseWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'wait' can't be parsed")
+
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOPMLSize)
+
+	var doc io.Reader = r.Body
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The field 'file' of the form can't be read")
+
+			return
+		}
+		defer file.Close()
+
+		doc = file
+	}
+
+	feeds, err := opml.Parse(doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("The OPML document can't be parsed")
+
+		return
+	}
+
+	if len(feeds) == 0 {
+		err := errorx.IllegalArgument.New("the OPML document doesn't contain feeds")
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Request rejected due to an OPML document without feeds")
+
+		return
+	}
+
+	if len(feeds) > maxBulkSubscriptions {
+		err := errorx.IllegalArgument.New("the OPML document can't contain more than %d feeds", maxBulkSubscriptions)
+
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"feeds":      len(feeds),
+			"error":      err.Error(),
+		}).Error("Request rejected due to the number of feeds of the OPML document")
+
+		return
+	}
+
+	requests := make([]feedRequest, 0, len(feeds))
+	for _, f := range feeds {
+		requests = append(requests, feedRequest{URL: f.URL, Title: f.Title, Group: f.Group})
+	}
+
+	batch, invalid := m.subscribeAll(r.Context(), requests)
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"batchID":    batch.ID,
+		"feeds":      len(batch.Jobs),
+		"invalid":    len(invalid),
+	}).Info("Sending feeds to the update queue (OPML import)")
+
+	if wait {
+		waitJob(r, batch)
+	}
+
+	respondSubscriptions(w, r, batch, subscriptionResults(requests, batch, invalid))
+}
+
+// ExportOPMLHandler returns an OPML 2.0 document with the subscriptions of the user. The groups of the podcasts are
+// exported as folders unless the query parameter 'groups' is false.
+func (m *Manager) ExportOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	groups := true
+
+	if keys, ok := r.URL.Query()["groups"]; ok && len(keys[0]) > 0 {
+		g, err := strconv.ParseBool(keys[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The query parameter 'groups' can't be parsed")
+
+			return
+		}
+
+		groups = g
+	}
+
+	var p []models.Podcast
+
+	if res := m.db.WithContext(r.Context()).Where("subscribed = ?", true).Order("title").Find(&p); res.Error != nil {
+		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(res.Error),
+		}).Error("Error when trying to get subscriptions from db")
+
+		return
+	}
+
+	feeds := make([]opml.Feed, 0, len(p))
+	for _, podcast := range p {
+		f := opml.Feed{
+			Title:   podcast.Title,
+			URL:     podcast.FeedLink,
+			SiteURL: podcast.Link,
+		}
+
+		if groups {
+			f.Group = podcast.Group
+		}
+
+		feeds = append(feeds, f)
+	}
+
+	// Write the document to a buffer first, so an error can still be reported with the right status code.
+	var buf bytes.Buffer
+
+	if err := opml.Write(&buf, "LinCast subscriptions", feeds); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to generate the OPML document")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="lincast-subscriptions.opml"`)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := buf.WriteTo(w); err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to write the response to the request")
+	}
+}
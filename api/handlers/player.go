@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"lincast/models"
+	"lincast/repositories"
 	"lincast/utils/safe"
 
 	"github.com/go-chi/chi/v5"
@@ -23,7 +25,7 @@ func (m *Manager) PlayerPlaybackInfoHandler(w http.ResponseWriter, r *http.Reque
 		{
 			w.Header().Set("Content-Type", "application/json")
 
-			if res := m.db.First(&p); res.Error != nil {
+			if res := m.db.WithContext(r.Context()).First(&p); res.Error != nil {
 				// If the error is of type gorm.ErrRecordNotFound, it means that there is no episode
 				// being played, so we should let it know it to the user that there is no content
 				if errors.Is(res.Error, gorm.ErrRecordNotFound) {
@@ -76,13 +78,13 @@ func (m *Manager) PlayerPlaybackInfoHandler(w http.ResponseWriter, r *http.Reque
 
 			// Try to update the first (and only) row of the table that stores the playback info
 			// of the player.
-			res := m.db.Model(&models.PlaybackInfo{}).Where("id = ?", 1).Updates(&p)
+			res := m.db.WithContext(r.Context()).Model(&models.PlaybackInfo{}).Where("id = ?", 1).Updates(&p)
 			if res.Error != nil {
 				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 				log.WithFields(log.Fields{
 					"remoteAddr": r.RemoteAddr,
-					"error":      errorx.EnsureStackTrace(err),
+					"error":      errorx.EnsureStackTrace(res.Error),
 				}).Error("Error when trying to update the player's playback info")
 
 				return
@@ -91,13 +93,13 @@ func (m *Manager) PlayerPlaybackInfoHandler(w http.ResponseWriter, r *http.Reque
 			// If no rows have been afected (which means that there are no records in the table), we should
 			// create the first record.
 			if res.RowsAffected == 0 {
-				res = m.db.Create(&p)
+				res = m.db.WithContext(r.Context()).Create(&p)
 				if res.Error != nil {
 					http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 					log.WithFields(log.Fields{
 						"remoteAddr": r.RemoteAddr,
-						"error":      errorx.EnsureStackTrace(err),
+						"error":      errorx.EnsureStackTrace(res.Error),
 					}).Error("Error when trying to add the first entry of the table that stores the player's playback info")
 
 					return
@@ -157,7 +159,9 @@ func (m *Manager) SetEpisodeStatusHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	res := m.db.Model(&models.Episode{}).Where("id = ? AND parent_podcast_id = ?", episodeID, podcastID).UpdateColumn("played", reqBody.Played)
+	var ep models.Episode
+
+	res := m.db.WithContext(r.Context()).Select("id").Where("id = ? AND podcast_id = ?", episodeID, podcastID).Limit(1).Find(&ep)
 	if res.Error != nil {
 		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -186,6 +190,35 @@ func (m *Manager) SetEpisodeStatusHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The change is recorded along with its time, so the older actions synced later don't overwrite it.
+	updated, err := repositories.NewEpisodeRepository(m.db.WithContext(r.Context())).UpdatePlayed(ep.ID, reqBody.Played, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+			"podcastID":  podcastID,
+			"episodeID":  episodeID,
+		}).Error("Error when trying to update the played status of an episode")
+
+		return
+	}
+
+	if !updated {
+		e := "the played status of the episode has a more recent change"
+
+		http.Error(w, e, http.StatusConflict)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"podcastID":  podcastID,
+			"episodeID":  episodeID,
+		}).Warn(e)
+
+		return
+	}
+
 	w.Header().Set("Location", fmt.Sprintf("/api/v0/podcasts/%d/episodes/%d", podcastID, episodeID))
 	w.WriteHeader(http.StatusCreated)
 }
@@ -1,14 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"lincast/database"
 	"lincast/models"
+	"lincast/repositories"
+	"lincast/update"
 	testUtils "lincast/utils/testing"
 
+	"github.com/go-chi/chi/v5"
 	assert2 "github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 )
@@ -16,11 +24,11 @@ import (
 func TestPlayerPlaybackInfoHandler_GET(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
 	method := "GET"
 
 	// If nothing is being played, an error should be returned
@@ -31,8 +39,7 @@ func TestPlayerPlaybackInfoHandler_GET(t *testing.T) {
 
 	// Check if the playback info is correctly returned.
 	expectedProgress := models.PlaybackInfo{
-		PodcastID: 11,
-		EpisodeID: 123,
+		EpisodeID: addPlayedEpisode(db, t),
 	}
 
 	res := db.Save(&expectedProgress)
@@ -48,27 +55,24 @@ func TestPlayerPlaybackInfoHandler_GET(t *testing.T) {
 		assert.FailNow(err.Error())
 	}
 
-	// Remove the fields that are not included on the response
-	expectedProgress.Model = gorm.Model{}
-
 	assert.Equal(http.StatusOK, r.StatusCode, "Since the playback info should be returned without problems, the status code must be 200 OK")
 	assert.Equal("application/json", r.Header.Get("Content-Type"), "Since the response should contain the playback info, the 'Content-Type' haders should have the value of 'application/json'")
-	assert.Equal(expectedProgress, receivedProgress, "The returned progress should be the same as the stored one")
+	assert.Equal(expectedProgress.ID, receivedProgress.ID, "The returned progress should be the same as the stored one")
+	assert.Equal(expectedProgress.EpisodeID, receivedProgress.EpisodeID, "The returned progress should be the same as the stored one")
 }
 
 func TestPlayerPlaybackInfoHandler_PUT(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
 	method := "PUT"
 
 	expectedProgress := models.PlaybackInfo{
-		PodcastID: 10,
-		EpisodeID: 123,
+		EpisodeID: addPlayedEpisode(db, t),
 	}
 
 	// Send the request to update the progress of the player
@@ -81,12 +85,9 @@ func TestPlayerPlaybackInfoHandler_PUT(t *testing.T) {
 		assert.FailNow(res.Error.Error())
 	}
 
-	// Remove the fields that are not used
-	progressInDB.Model = gorm.Model{}
-
 	assert.Equal(http.StatusCreated, r.StatusCode, "Since the progress should be updated without problems, the expected status code in the response is 201 Created")
 	assert.Equal("", r.Header.Get("Content-Type"), "Since the response should not have a body, the 'Content-Type' headers should be empty")
-	assert.Equal(expectedProgress, progressInDB, "The progress of the player should be updated correctly")
+	assert.Equal(expectedProgress.EpisodeID, progressInDB.EpisodeID, "The progress of the player should be updated correctly")
 
 	wrongBody := "{'something': 'else', '1': '2', 'foo': 'bar'}"
 	r = testUtils.NewRequest(mng.PlayerPlaybackInfoHandler, method, "", testUtils.NewBody(t, &wrongBody))
@@ -95,21 +96,32 @@ func TestPlayerPlaybackInfoHandler_PUT(t *testing.T) {
 	assert.Equal("text/plain; charset=utf-8", r.Header.Get("Content-Type"), "Since the response should contain the description of the error, the expected 'Content-Type' headers are 'text/plain; charset=utf-8'")
 }
 
+// addPlayedEpisode stores an episode (and its podcast) that can be referenced by the playback info, returning its ID.
+func addPlayedEpisode(db *gorm.DB, t *testing.T) uint {
+	p := &models.Podcast{FeedLink: "https://example.com/feed.xml"}
+	addOfflinePodcastToDB(p, db, t)
+
+	ep := &models.Episode{PodcastID: p.ID, GUID: "episode-1"}
+	addOfflineEpisodeToDB(ep, db, t)
+
+	return ep.ID
+}
+
 // func TestSetEpisodeStatusHandler(t *testing.T) {
 // 	assert := assert2.New(t)
 // 	tempDir := t.TempDir()
-// 	db, err := database.New(tempDir, "test.db")
+// 	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 // 	if err != nil {
 // 		assert.FailNow(err.Error())
 // 	}
-// 	mng := NewManager(db, make(chan *models.Podcast))
+// 	mng := NewManager(db, make(chan *update.Job), "LinCast")
 // 	method := "GET"
 
 // 	p := new(models.Podcast)
 // 	addOfflinePodcastToDB(p, db, t)
 
 // 	ep := new(models.Episode) // Played == false
-// 	ep.ParentPodcastID = p.ID
+// 	ep.PodcastID = p.ID
 // 	addOfflineEpisodeToDB(ep, db, t)
 
 // 	vars := map[string]string{
@@ -135,3 +147,41 @@ func TestPlayerPlaybackInfoHandler_PUT(t *testing.T) {
 // 	}
 // 	assert.Equal(body["played"], epFromDB.Played, "The 'played' field should be updated on the database")
 // }
+
+func TestSetEpisodeStatusHandler(t *testing.T) {
+	assert := assert2.New(t)
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+
+	p := models.Podcast{FeedLink: "https://example.com/feed.xml"}
+	addOfflinePodcastToDB(&p, db, t)
+
+	ep := models.Episode{PodcastID: p.ID, GUID: "episode-1"}
+	addOfflineEpisodeToDB(&ep, db, t)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("pID", strconv.Itoa(int(p.ID)))
+	rctx.URLParams.Add("epID", strconv.Itoa(int(ep.ID)))
+
+	req := httptest.NewRequest("PUT", "/", testUtils.NewBody(t, map[string]bool{"played": true}))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	res := httptest.NewRecorder()
+	mng.SetEpisodeStatusHandler(res, req)
+
+	assert.Equal(http.StatusCreated, res.Code)
+
+	// An action recorded before the change made on the web can't overwrite it.
+	updated, err := repositories.NewEpisodeRepository(db).UpdatePlayed(ep.ID, false, time.Now().Add(-time.Minute))
+	if assert.NoError(err) {
+		assert.False(updated, "the change made on the web should be more recent")
+	}
+
+	var stored models.Episode
+	if assert.NoError(db.First(&stored, ep.ID).Error) {
+		assert.True(stored.Played)
+	}
+}
@@ -2,16 +2,21 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"lincast/models"
 	"lincast/podcasts"
+	"lincast/repositories"
+	"lincast/update"
 	"lincast/utils/safe"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joomcode/errorx"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func (m *Manager) SubscribeToPodcastHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,92 +39,45 @@ func (m *Manager) SubscribeToPodcastHandler(w http.ResponseWriter, r *http.Reque
 	// Sanitize the URL before anything
 	u.URL = safe.Sanitize(u.URL)
 
-	// Resolve the given URL first, and then decide if we will save the data or not.
-	p, _, err := podcasts.GetPodcastData(u.URL)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-
-		log.WithFields(log.Fields{
-			"remoteAddr": r.RemoteAddr,
-			"error":      errorx.EnsureStackTrace(err),
-		}).Error("Unable to get the feed of the podcast")
-
-		return
-	}
+	// Just validate the URL here, the feed is resolved (and the podcast stored) by the update queue.
+	if !podcasts.IsValidURL(u.URL) {
+		err := errorx.IllegalArgument.New("the given URL is not valid")
 
-	// Check if the feed's URL is already on the database by counting their appearances.
-	var alreadyOnDB int64
-	res := m.db.Model(&models.Podcast{}).Where("feed_link = ?", p.FeedLink).Count(&alreadyOnDB)
-	if res.Error != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		log.WithFields(log.Fields{
 			"remoteAddr": r.RemoteAddr,
-			"error":      errorx.EnsureStackTrace(err),
-		}).Error("Error when checking if the URL of the podcast's feed is already in the database")
+			"error":      err.Error(),
+			"url":        u.URL,
+		}).Error("Request rejected due to an invalid feed URL")
 
 		return
 	}
 
-	// If alreadyOnDB equals to 0, then the feed is not in db.
-	if alreadyOnDB == 0 {
-		// p.Subscribed = true
-
-		res = m.db.Create(&p)
-		if res.Error != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-
-			log.WithFields(log.Fields{
-				"remoteAddr": r.RemoteAddr,
-				"error":      errorx.EnsureStackTrace(err),
-			}).Error("Error when trying to store the new subscribed podcast")
-
-			return
-		}
-
-		w.WriteHeader(http.StatusCreated)
-	} else { // If alreadyOnDB is different of 0 (should be 1), the feed is already on db and we should just update the 'subscribed' column.
-		res = m.db.Model(&models.Podcast{}).Where("feed_link = ?", p.FeedLink).Update("subscribed", true)
-		if res.Error != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// If the same feed is already being processed (e.g. the client retried the request), the same job is returned.
+	job, added := m.jobs.AddSubscriptionJob(update.NewSubscriptionJob(u.URL))
+	if added {
+		if err := m.enqueue(r.Context(), job); err != nil {
+			queueFull(w, err)
 
 			log.WithFields(log.Fields{
-				"remoteAddr": r.RemoteAddr,
-				"error":      errorx.EnsureStackTrace(err),
-			}).Error("Error when trying to update the subscription of a podcast")
-
-			return
-		}
-
-		var id uint
-		res = m.db.Model(&models.Podcast{}).Where("feed_link = ?", p.FeedLink).Select("id").Find(&id)
-		if res.Error != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-
-			log.WithFields(log.Fields{
-				"remoteAddr": r.RemoteAddr,
-				"error":      errorx.EnsureStackTrace(err),
-			}).Error("Error when trying to get the ID of the updated podcast")
+				"remoteAddr":  r.RemoteAddr,
+				"jobID":       job.ID,
+				"podcastFeed": u.URL,
+				"error":       err.Error(),
+			}).Warning("Subscription rejected, the update queue is full")
 
 			return
 		}
 
-		p.ID = id
-
-		w.WriteHeader(http.StatusNoContent)
+		log.WithFields(log.Fields{
+			"remoteAddr":  r.RemoteAddr,
+			"jobID":       job.ID,
+			"podcastFeed": u.URL,
+		}).Info("Sending feed to the update queue (new subscription)")
 	}
 
-	select {
-	case m.updateChannel <- p:
-	default:
-		{ // Avoid blocking if, for some reason, the channel is busy
-			log.WithFields(log.Fields{
-				"remoteAddr":  r.RemoteAddr,
-				"podcastID":   p.ID,
-				"podcastFeed": p.FeedLink,
-			}).Warning("The channel used to update the recently subscribed podcasts is busy; skipping feed...")
-		}
-	}
+	m.respondJob(w, r, job, "/api/v0/jobs/"+job.ID.String())
 }
 
 func (m *Manager) UnsubscribeToPodcastHandler(w http.ResponseWriter, r *http.Request) {
@@ -151,7 +109,7 @@ func (m *Manager) UnsubscribeToPodcastHandler(w http.ResponseWriter, r *http.Req
 		}).Error("Cannot parse the ID of the podcast to unsubscribe")
 	}
 
-	res := m.db.Model(&models.Podcast{}).Where("id = ?", id).Update("subscribed", false)
+	res := m.db.WithContext(r.Context()).Model(&models.Podcast{}).Where("id = ?", id).Update("subscribed", false)
 	if res.Error != nil {
 		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -182,7 +140,7 @@ func (m *Manager) UnsubscribeToPodcastHandler(w http.ResponseWriter, r *http.Req
 func (m *Manager) GetUserPodcastsHandler(w http.ResponseWriter, r *http.Request) {
 	var p []models.Podcast
 
-	if res := m.db.Where("subscribed = ?", true).Find(&p); res.Error != nil {
+	if res := m.db.WithContext(r.Context()).Where("subscribed = ?", true).Find(&p); res.Error != nil {
 		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 		log.WithFields(log.Fields{
@@ -228,7 +186,7 @@ func (m *Manager) GetPodcastHandler(w http.ResponseWriter, r *http.Request) {
 
 	var p models.Podcast
 
-	if res := m.db.Where("id = ?", id).First(&p); res.Error != nil {
+	if res := m.db.WithContext(r.Context()).Where("id = ?", id).First(&p); res.Error != nil {
 		http.Error(w, "the podcast with the given ID does not exist", http.StatusNotFound)
 
 		log.WithFields(log.Fields{
@@ -256,6 +214,77 @@ func (m *Manager) GetPodcastHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// DeletePodcastHandler deletes a podcast along with its episodes and everything that depends on them. If the query
+// parameter 'keepHistory' is true, the podcast and its episodes are archived instead, keeping the listening history,
+// which is restored if the podcast is subscribed again.
+func (m *Manager) DeletePodcastHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+
+	id := safe.SafeParseInt(idStr)
+	if id == safe.DefaultAllocate {
+		err := errorx.IllegalArgument.New("value is over the limit of int values or can't be parsed")
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The given ID cannot be parsed")
+
+		return
+	}
+
+	keepHistory := false
+
+	if keys, ok := r.URL.Query()["keepHistory"]; ok && len(keys[0]) > 0 {
+		k, err := strconv.ParseBool(keys[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The query parameter 'keepHistory' can't be parsed")
+
+			return
+		}
+
+		keepHistory = k
+	}
+
+	err := repositories.NewPodcastRepository(m.db.WithContext(r.Context())).Delete(uint(id), keepHistory)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "the podcast with the given ID does not exist", http.StatusNotFound)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"givenID":    id,
+			}).Error("Request rejected because the podcast to delete does not exist")
+
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+			"givenID":    id,
+		}).Error("Error when trying to delete the podcast")
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr":  r.RemoteAddr,
+		"podcastID":   id,
+		"keepHistory": keepHistory,
+	}).Info("Podcast deleted")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (m *Manager) GetEpisodesHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 
@@ -275,7 +304,7 @@ func (m *Manager) GetEpisodesHandler(w http.ResponseWriter, r *http.Request) {
 
 	var eps []models.Episode
 
-	res := m.db.Where("parent_podcast_id", id).Find(&eps)
+	res := m.db.WithContext(r.Context()).Where("podcast_id", id).Find(&eps)
 	if res.Error != nil {
 		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -352,7 +381,7 @@ func (m *Manager) EpisodeDetailsHandler(w http.ResponseWriter, r *http.Request)
 
 	var ep models.Episode
 
-	res := m.db.Model(&models.Episode{}).Where("id = ? AND parent_podcast_id = ?", episodeID, podcastID).Find(&ep)
+	res := m.db.WithContext(r.Context()).Model(&models.Episode{}).Where("id = ? AND podcast_id = ?", episodeID, podcastID).Find(&ep)
 	if res.Error != nil {
 		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -434,7 +463,7 @@ func (m *Manager) EpisodeProgressHandler(w http.ResponseWriter, r *http.Request)
 			// Returns the progress of the episode
 			var ep models.Episode
 
-			res := m.db.Model(&models.Episode{}).Where("id = ? AND parent_podcast_id = ?", episodeID, podcastID).Select("current_progress").Find(&ep)
+			res := m.db.WithContext(r.Context()).Model(&models.Episode{}).Where("id = ? AND podcast_id = ?", episodeID, podcastID).Select("current_progress").Find(&ep)
 			if res.Error != nil {
 				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -497,7 +526,9 @@ func (m *Manager) EpisodeProgressHandler(w http.ResponseWriter, r *http.Request)
 				return
 			}
 
-			res := m.db.Model(&models.Episode{}).Where("id = ? AND parent_podcast_id = ?", episodeID, podcastID).UpdateColumn("current_progress", requestBody.Progress)
+			var ep models.Episode
+
+			res := m.db.WithContext(r.Context()).Select("id").Where("id = ? AND podcast_id = ?", episodeID, podcastID).Limit(1).Find(&ep)
 			if res.Error != nil {
 				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -525,6 +556,36 @@ func (m *Manager) EpisodeProgressHandler(w http.ResponseWriter, r *http.Request)
 				return
 			}
 
+			// The change is recorded along with its time, so the older actions synced later don't overwrite it.
+			updated, err := repositories.NewEpisodeRepository(m.db.WithContext(r.Context())).
+				UpdateProgress(ep.ID, requestBody.Progress, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+
+				log.WithFields(log.Fields{
+					"remoteAddr": r.RemoteAddr,
+					"error":      errorx.EnsureStackTrace(err),
+					"podcastID":  podcastID,
+					"episodeID":  episodeID,
+				}).Error("Error when trying to update the progress of an episode")
+
+				return
+			}
+
+			if !updated {
+				e := "the progress of the episode has a more recent change"
+
+				http.Error(w, e, http.StatusConflict)
+
+				log.WithFields(log.Fields{
+					"remoteAddr": r.RemoteAddr,
+					"podcastID":  podcastID,
+					"episodeID":  episodeID,
+				}).Warn(e)
+
+				return
+			}
+
 			w.WriteHeader(http.StatusCreated)
 		}
 	}
@@ -591,7 +652,7 @@ func (m *Manager) LatestEpisodesHandler(w http.ResponseWriter, r *http.Request)
 
 	var eps []models.Episode
 
-	res := m.db.Where("published BETWEEN ? AND ?", from, to).Order("published DESC").Find(&eps)
+	res := m.db.WithContext(r.Context()).Where("published BETWEEN ? AND ?", from, to).Order("published DESC").Find(&eps)
 	if res.Error != nil {
 		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
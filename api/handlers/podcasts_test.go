@@ -1,18 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"lincast/database"
 	"lincast/models"
 	"lincast/podcasts"
+	"lincast/update"
 
 	testUtils "lincast/utils/testing"
 
+	"github.com/joomcode/errorx"
 	"github.com/mmcdole/gofeed"
 	assert2 "github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
@@ -21,11 +25,11 @@ import (
 func TestSubscribeToPodcastHandler(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job, 1), "LinCast")
 
 	method := "POST"
 	body := struct {
@@ -36,13 +40,31 @@ func TestSubscribeToPodcastHandler(t *testing.T) {
 
 	r := testUtils.NewRequest(mng.SubscribeToPodcastHandler, method, "", testUtils.NewBody(t, body))
 
-	assert.Equal(http.StatusCreated, r.StatusCode, "The status code returned on the subscription of a new podcast should be 201 Created")
-	assert.Equal("", r.Header.Get("Content-Type"), "Since the response should not return a body, the 'Content-Type' headers should not be there")
+	assert.Equal(http.StatusAccepted, r.StatusCode, "The status code returned on the subscription of a podcast should be 202 Accepted")
+	assert.Equal("application/json", r.Header.Get("Content-Type"), "Since the response should contain the job, the 'Content-Type' headers should be 'application/json'")
+
+	var job struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&job)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.Equal("/api/v0/jobs/"+job.ID, r.Header.Get("Location"), "The location of the job should be returned")
 
 	r = testUtils.NewRequest(mng.SubscribeToPodcastHandler, method, "", testUtils.NewBody(t, body))
 
-	assert.Equal(http.StatusNoContent, r.StatusCode, "The status code returned on the subscription of a podcast that is already on the database should be 204 No Content")
-	assert.Equal("", r.Header.Get("Content-Type"), "Since the response should not return a body, the 'Content-Type' headers should not be there")
+	var retriedJob struct {
+		ID string `json:"id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&retriedJob)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.Equal(job.ID, retriedJob.ID, "Retrying the subscription while the job is active should return the same job")
 
 	body.Url = "abc123"
 	r = testUtils.NewRequest(mng.SubscribeToPodcastHandler, method, "", testUtils.NewBody(t, body))
@@ -50,16 +72,23 @@ func TestSubscribeToPodcastHandler(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, r.StatusCode, "The status code returned if the feed provided is invalid should be 400 Bad Request")
 	assert.Equal("text/plain; charset=utf-8", r.Header.Get("Content-Type"), "Since the response should contain an error msg in plain text, the "+
 		"'Content-Type' headers should be 'text/plain; charset=utf-8'")
+
+	// The queue only has room for the first job.
+	body.Url = "https://changelog.com/podcast/feed"
+	r = testUtils.NewRequest(mng.SubscribeToPodcastHandler, method, "", testUtils.NewBody(t, body))
+
+	assert.Equal(http.StatusServiceUnavailable, r.StatusCode, "The status code returned if the update queue is full should be 503 Service Unavailable")
+	assert.NotEmpty(r.Header.Get("Retry-After"), "The client should be told when to retry")
 }
 
 func TestUnsubscribeToPodcastHandler(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 	addPodcastToDB("https://gotime.fm/rss", true, db, t) // ID: 1
 	id := 1
@@ -91,11 +120,11 @@ func TestUnsubscribeToPodcastHandler(t *testing.T) {
 func TestGetUserPodcastsHandler(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 	feeds := map[string]bool{
 		"https://gotime.fm/rss":                     true,
@@ -132,11 +161,11 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 // func TestGetPodcastHandler(t *testing.T) {
 // 	assert := assert2.New(t)
 // 	tempDir := t.TempDir()
-// 	db, err := database.New(tempDir, "test.db")
+// 	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 // 	if err != nil {
 // 		assert.FailNow(err.Error())
 // 	}
-// 	mng := NewManager(db, make(chan *models.Podcast))
+// 	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 // 	url := "https://gotime.fm/rss"
 // 	method := "GET"
@@ -208,11 +237,11 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 // func TestGetEpisodesHandler(t *testing.T) {
 // 	assert := assert2.New(t)
 // 	tempDir := t.TempDir()
-// 	db, err := database.New(tempDir, "test.db")
+// 	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 // 	if err != nil {
 // 		assert.FailNow(err.Error())
 // 	}
-// 	mng := NewManager(db, make(chan *models.Podcast))
+// 	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 // 	url := "https://feeds.feedburner.com/iTunesPodcastTTScienceMedicine"
 // 	method := "GET"
@@ -277,16 +306,16 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 // func TestEpisodeDetailsHandler(t *testing.T) {
 // 	assert := assert2.New(t)
 // 	tempDir := t.TempDir()
-// 	db, err := database.New(tempDir, "test.db")
+// 	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 // 	if err != nil {
 // 		assert.FailNow(err.Error())
 // 	}
-// 	mng := NewManager(db, make(chan *models.Podcast))
+// 	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 // 	method := "GET"
 
 // 	dummyEp := models.Episode{
-// 		ParentPodcastID: 76,
+// 		PodcastID: 76,
 // 		Title:           "LinCast Podcast",
 // 		Description:     "Some really boring description.",
 // 		Link:            "https://example.org/",
@@ -301,7 +330,7 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 // 	}
 
 // 	vars := map[string]string{
-// 		"pID":  fmt.Sprint(dummyEp.ParentPodcastID),
+// 		"pID":  fmt.Sprint(dummyEp.PodcastID),
 // 		"epID": fmt.Sprint(dummyEp.ID),
 // 	}
 
@@ -340,11 +369,11 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 // func TestEpisodeProgressHandler_GET(t *testing.T) {
 // 	assert := assert2.New(t)
 // 	tempDir := t.TempDir()
-// 	db, err := database.New(tempDir, "test.db")
+// 	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 // 	if err != nil {
 // 		assert.FailNow(err.Error())
 // 	}
-// 	mng := NewManager(db, make(chan *models.Podcast))
+// 	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 // 	url := "https://feeds.feedburner.com/iTunesPodcastTTScienceMedicine"
 // 	method := "GET"
@@ -400,11 +429,11 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 // func TestEpisodeProgressHandler_PUT(t *testing.T) {
 // 	assert := assert2.New(t)
 // 	tempDir := t.TempDir()
-// 	db, err := database.New(tempDir, "test.db")
+// 	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 // 	if err != nil {
 // 		assert.FailNow(err.Error())
 // 	}
-// 	mng := NewManager(db, make(chan *models.Podcast))
+// 	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 // 	url := "https://feeds.feedburner.com/iTunesPodcastTTScienceMedicine"
 // 	method := "PUT"
@@ -454,11 +483,11 @@ func TestGetUserPodcastsHandler(t *testing.T) {
 func TestLatestEpisodesHandler(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
 
 	method := "GET"
 
@@ -479,80 +508,84 @@ func TestLatestEpisodesHandler(t *testing.T) {
 	eps := map[string][]models.Episode{
 		"includes": {
 			{
-				ParentPodcastID: 1,
-				Title:           "Test ep 1",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "1",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep1Date,
+				PodcastID:   1,
+				Title:       "Test ep 1",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "1",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep1Date,
 			},
 			{
-				ParentPodcastID: 2,
-				Title:           "Test ep 2",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "2",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep2Date,
+				PodcastID:   2,
+				Title:       "Test ep 2",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "2",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep2Date,
 			},
 			{
-				ParentPodcastID: 2,
-				Title:           "Test ep 3",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "3",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep3Date,
+				PodcastID:   2,
+				Title:       "Test ep 3",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "3",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep3Date,
 			},
 			{
-				ParentPodcastID: 3,
-				Title:           "Test ep 4",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "4",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep4Date,
+				PodcastID:   3,
+				Title:       "Test ep 4",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "4",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep4Date,
 			},
 		},
 		"excludes": {
 			{
-				ParentPodcastID: 3,
-				Title:           "Test ep 5",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "5",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep5Date,
+				PodcastID:   3,
+				Title:       "Test ep 5",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "5",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep5Date,
 			},
 			{
-				ParentPodcastID: 4,
-				Title:           "Test ep 6",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "6",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep6Date,
+				PodcastID:   4,
+				Title:       "Test ep 6",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "6",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep6Date,
 			},
 			{
-				ParentPodcastID: 5,
-				Title:           "Test ep 7",
-				Description:     "The description of the random episode",
-				Link:            "https://some.website.com",
-				AuthorName:      "Martin",
-				GUID:            "7",
-				ImageURL:        "https://some.website.com/foo/bar.png",
-				Published:       _ep7Date,
+				PodcastID:   5,
+				Title:       "Test ep 7",
+				Description: "The description of the random episode",
+				Link:        "https://some.website.com",
+				AuthorName:  "Martin",
+				GUID:        "7",
+				ImageURL:    "https://some.website.com/foo/bar.png",
+				Published:   _ep7Date,
 			},
 		},
 	}
 
+	for id := uint(1); id <= 5; id++ {
+		addOfflinePodcastToDB(&models.Podcast{Model: gorm.Model{ID: id}, FeedLink: fmt.Sprintf("https://example.com/%d.xml", id)}, db, t)
+	}
+
 	for i := range eps["includes"] {
 		r := db.Save(&eps["includes"][i])
 		if r.Error != nil {
@@ -589,7 +622,11 @@ func TestLatestEpisodesHandler(t *testing.T) {
 }
 
 func addPodcastToDB(feedURL string, subscribed bool, db *gorm.DB, t *testing.T) {
-	p, _, err := podcasts.GetPodcastData(feedURL)
+	p, _, err := podcasts.GetPodcastData(context.Background(), feedURL)
+	if errorx.IsOfType(err, podcasts.UnreachableError) {
+		t.Skipf("the feed '%s' can't be reached: %v", feedURL, err)
+	}
+
 	if err != nil {
 		assert2.FailNow(t, err.Error())
 	}
@@ -623,7 +660,7 @@ func addEpisodesToDB(originalFeed *gofeed.Feed, parentPodcastID uint, db *gorm.D
 	}
 
 	for _, e := range *eps {
-		e.ParentPodcastID = parentPodcastID
+		e.PodcastID = parentPodcastID
 
 		res := db.Create(&e)
 		if res.Error != nil {
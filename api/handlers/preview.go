@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"lincast/models"
+	"lincast/podcasts"
+	"lincast/utils/safe"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultPreviewEpisodes is the number of episodes returned by the preview of a podcast if the client doesn't
+	// specify it.
+	defaultPreviewEpisodes = 10
+	// maxPreviewEpisodes is the maximum number of episodes that can be returned by the preview of a podcast.
+	maxPreviewEpisodes = 100
+	// previewTimeout is the maximum time to wait for the feed of the preview. It should be lower than the write timeout
+	// of the server.
+	previewTimeout = time.Second * 10
+)
+
+// PreviewPodcastHandler resolves the given feed and returns the podcast and the first episodes as they would be
+// stored if the user subscribes to it, without writing anything to the database.
+func (m *Manager) PreviewPodcastHandler(w http.ResponseWriter, r *http.Request) {
+	keys, ok := r.URL.Query()["url"]
+	if !ok || len(keys[0]) < 1 {
+		err := errorx.IllegalFormat.New("param 'url' is missing")
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Request rejected due to absence of parameter 'url'")
+
+		return
+	}
+
+	feedURL := safe.Sanitize(keys[0])
+
+	if !podcasts.IsValidURL(feedURL) {
+		err := errorx.IllegalArgument.New("the given URL is not valid")
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+			"url":        feedURL,
+		}).Error("Request rejected due to an invalid feed URL")
+
+		return
+	}
+
+	limit := defaultPreviewEpisodes
+
+	if keys, ok := r.URL.Query()["limit"]; ok && len(keys[0]) > 0 {
+		limit = safe.SafeParseInt(keys[0])
+		if limit == safe.DefaultAllocate || limit > maxPreviewEpisodes {
+			err := errorx.IllegalArgument.New("the param 'limit' should be a number between 1 and %d", maxPreviewEpisodes)
+
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The param 'limit' cannot be parsed")
+
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), previewTimeout)
+	defer cancel()
+
+	p, feed, err := podcasts.GetPodcastData(ctx, feedURL)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			http.Error(w, "the feed took too long to respond", http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Unable to get the feed of the podcast")
+
+		return
+	}
+
+	eps, warnings, err := podcasts.GetEpisodesWithWarnings(feed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error on episodes parsing")
+
+		return
+	}
+
+	if p.FeedLink == "" {
+		p.FeedLink = feedURL
+	}
+
+	episodes := *eps
+	total := len(episodes)
+
+	if total > limit {
+		episodes = episodes[:limit]
+	}
+
+	if episodes == nil {
+		episodes = []models.Episode{}
+	}
+
+	if warnings == nil {
+		warnings = []podcasts.ParseWarning{}
+	}
+
+	response := struct {
+		Podcast       *models.Podcast         `json:"podcast"`
+		Episodes      []models.Episode        `json:"episodes"`
+		TotalEpisodes int                     `json:"totalEpisodes"`
+		Warnings      []podcasts.ParseWarning `json:"warnings"`
+	}{
+		Podcast:       p,
+		Episodes:      episodes,
+		TotalEpisodes: total,
+		Warnings:      warnings,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to encode the response to the request")
+
+		return
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"lincast/models"
+	"lincast/repositories"
 	"lincast/utils/safe"
 
 	"github.com/joomcode/errorx"
@@ -51,9 +53,10 @@ func (m *Manager) QueueHandler(w http.ResponseWriter, r *http.Request) {
 				positions = append(positions, ep.Position)
 			}
 
-			// First we delete all the rows of the table.
-			if res := m.db.Unscoped().Where("1 = 1").Delete(&models.QueueEpisode{}); res.Error != nil {
-				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+			// First we delete all the rows of the table. They are soft-deleted, so the sync of the clients can know
+			// when the episodes were removed from the queue.
+			if _, err := repositories.NewQueueRepository(m.db.WithContext(r.Context())).RemoveWhere(time.Now(), "1 = 1"); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 
 				log.WithFields(log.Fields{
 					"remoteAddr": r.RemoteAddr,
@@ -63,13 +66,19 @@ func (m *Manager) QueueHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			// And later we introduce the new elements of the queue.
-			if res := m.db.Create(&q); res.Error != nil {
+			// And later we introduce the new elements of the queue. Their IDs are assigned by the database, since the
+			// ones of the removed elements are still in use, and they are added now.
+			for i := range q {
+				q[i].Model = gorm.Model{}
+				q[i].ChangedAt = time.Time{}
+			}
+
+			if res := m.db.WithContext(r.Context()).Create(&q); res.Error != nil {
 				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 				log.WithFields(log.Fields{
 					"remoteAddr": r.RemoteAddr,
-					"error":      errorx.EnsureStackTrace(err),
+					"error":      errorx.EnsureStackTrace(res.Error),
 				}).Error("Error when trying to set the new queue")
 
 				return
@@ -81,13 +90,13 @@ func (m *Manager) QueueHandler(w http.ResponseWriter, r *http.Request) {
 
 	case http.MethodDelete:
 		{
-			// Delete all the rows of the table.
-			if res := m.db.Unscoped().Where("1 = 1").Delete(&models.QueueEpisode{}); res.Error != nil {
-				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+			// Delete all the rows of the table (soft-deleted, see the method PUT).
+			if _, err := repositories.NewQueueRepository(m.db.WithContext(r.Context())).RemoveWhere(time.Now(), "1 = 1"); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 
 				log.WithFields(log.Fields{
 					"remoteAddr": r.RemoteAddr,
-					"error":      errorx.EnsureStackTrace(res.Error),
+					"error":      errorx.EnsureStackTrace(err),
 				}).Error("Error when trying to clean the queue")
 
 				return
@@ -100,7 +109,7 @@ func (m *Manager) QueueHandler(w http.ResponseWriter, r *http.Request) {
 		{
 			var q []models.QueueEpisode
 
-			res := m.db.Find(&q)
+			res := m.db.WithContext(r.Context()).Find(&q)
 			if res.Error != nil {
 				http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
@@ -177,13 +186,13 @@ func (m *Manager) AddToQueueHandler(w http.ResponseWriter, r *http.Request) {
 		var refPosition uint
 		// To append the new episode to the queue we need to know which is the bigger position
 		// stored, so the position of the new episode will be that + 1.
-		if res := m.db.Model(&models.QueueEpisode{}).Select("position").Limit(1).Order("position desc").First(&refPosition); res.Error != nil {
+		if res := m.db.WithContext(r.Context()).Model(&models.QueueEpisode{}).Select("position").Limit(1).Order("position desc").First(&refPosition); res.Error != nil {
 			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 				// If the error is because there are no episodes stored (which means that the queue is
 				// empty), we know that the new episode should be stored with the position 1.
 				ep.Position = 1
 
-				if res := m.db.Create(&ep); res.Error != nil {
+				if res := m.db.WithContext(r.Context()).Create(&ep); res.Error != nil {
 					http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 					log.WithFields(log.Fields{
@@ -213,7 +222,7 @@ func (m *Manager) AddToQueueHandler(w http.ResponseWriter, r *http.Request) {
 		// Last position + 1
 		ep.Position = refPosition + 1
 
-		if res := m.db.Create(&ep); res.Error != nil {
+		if res := m.db.WithContext(r.Context()).Create(&ep); res.Error != nil {
 			http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 			log.WithFields(log.Fields{
@@ -226,7 +235,7 @@ func (m *Manager) AddToQueueHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// To add the episode with the position 1 we'll need to update the position of the rest
 		// of episodes, adding 1 to each one.
-		if res := m.db.Model(&models.QueueEpisode{}).Where("1 = 1").Update("position", gorm.Expr("position + 1")); res.Error != nil {
+		if res := m.db.WithContext(r.Context()).Model(&models.QueueEpisode{}).Where("1 = 1").Update("position", gorm.Expr("position + 1")); res.Error != nil {
 			http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 			log.WithFields(log.Fields{
@@ -239,7 +248,7 @@ func (m *Manager) AddToQueueHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Now that all the positions have been updated, we need to insert the new episode with the position 1.
 		ep.Position = 1
-		if res := m.db.Create(&ep); res.Error != nil {
+		if res := m.db.WithContext(r.Context()).Create(&ep); res.Error != nil {
 			http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 
 			log.WithFields(log.Fields{
@@ -305,27 +314,28 @@ func (m *Manager) DelFromQueueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := m.db.Unscoped().Delete(&models.QueueEpisode{}, id)
-	if res.Error != nil {
-		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+	// The episode is soft-deleted, so the sync of the clients can know when it was removed from the queue.
+	removed, err := repositories.NewQueueRepository(m.db.WithContext(r.Context())).RemoveWhere(time.Now(), "id = ?", id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 
 		log.WithFields(log.Fields{
 			"remoteAddr": r.RemoteAddr,
-			"error":      errorx.Decorate(res.Error, res.Error.Error()),
+			"error":      errorx.Decorate(err, err.Error()),
 			"usedID":     id,
 		}).Error("Error when trying to remove an episode from the queue")
 
 		return
 	}
 
-	if res.RowsAffected == 0 {
+	if removed == 0 {
 		errmsg := "the episode of the queue with the given ID does not exist"
 
 		http.Error(w, errmsg, http.StatusNotFound)
 
 		log.WithFields(log.Fields{
 			"remoteAddr": r.RemoteAddr,
-			"error":      errorx.Decorate(res.Error, errmsg),
+			"error":      errmsg,
 			"usedID":     id,
 		}).Warning("Usage of the wrong ID when trying to remove an episode from the queue")
 
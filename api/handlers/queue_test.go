@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"lincast/database"
 	"lincast/models"
+	"lincast/update"
 	testUtils "lincast/utils/testing"
 
 	assert2 "github.com/stretchr/testify/assert"
@@ -18,28 +20,26 @@ import (
 func TestQueueHandler_GET(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+	addQueuedEpisodes(db, t, 1, 2, 3, 99, 100)
 	method := "GET"
 
 	expectedQueue := []models.QueueEpisode{
 		{
 			Position:  1,
-			PodcastID: 10,
-			EpisodeID: "guid1",
+			EpisodeID: 1,
 		},
 		{
 			Position:  2,
-			PodcastID: 1,
-			EpisodeID: "guid2",
+			EpisodeID: 2,
 		},
 		{
 			Position:  3,
-			PodcastID: 18,
-			EpisodeID: "guid3",
+			EpisodeID: 3,
 		},
 	}
 
@@ -65,6 +65,10 @@ func TestQueueHandler_GET(t *testing.T) {
 		if assert.True(receivedQueue[i].Model.UpdatedAt.Equal(expectedQueue[i].Model.UpdatedAt)) {
 			receivedQueue[i].Model.UpdatedAt = expectedQueue[i].Model.UpdatedAt
 		}
+
+		if assert.True(receivedQueue[i].ChangedAt.Equal(expectedQueue[i].ChangedAt)) {
+			receivedQueue[i].ChangedAt = expectedQueue[i].ChangedAt
+		}
 	}
 
 	assert.Equal(http.StatusOK, r.StatusCode)
@@ -75,34 +79,32 @@ func TestQueueHandler_GET(t *testing.T) {
 func TestQueueHandler_PUT(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+	addQueuedEpisodes(db, t, 1, 2, 3, 99, 100)
 	method := "PUT"
 
 	expectedQueue := []models.QueueEpisode{
 		{
 			Position:  1,
-			PodcastID: 10,
-			EpisodeID: "guid1",
+			EpisodeID: 1,
 			Model: gorm.Model{
 				ID: 1,
 			},
 		},
 		{
 			Position:  2,
-			PodcastID: 1,
-			EpisodeID: "guid2",
+			EpisodeID: 2,
 			Model: gorm.Model{
 				ID: 2,
 			},
 		},
 		{
 			Position:  3,
-			PodcastID: 18,
-			EpisodeID: "guid3",
+			EpisodeID: 3,
 			Model: gorm.Model{
 				ID: 3,
 			},
@@ -118,8 +120,11 @@ func TestQueueHandler_PUT(t *testing.T) {
 	}
 
 	for i := range queueOnDB {
+		assert.False(queueOnDB[i].ChangedAt.IsZero(), "the time at which the episode was added should be recorded")
+
 		queueOnDB[i].Model.CreatedAt = time.Time{}
 		queueOnDB[i].Model.UpdatedAt = time.Time{}
+		queueOnDB[i].ChangedAt = time.Time{}
 	}
 
 	assert.Equal(http.StatusCreated, r.StatusCode)
@@ -144,28 +149,26 @@ func TestQueueHandler_PUT(t *testing.T) {
 func TestQueueHandler_DELETE(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+	addQueuedEpisodes(db, t, 1, 2, 3, 99, 100)
 	method := "DELETE"
 
 	queueToStore := []models.QueueEpisode{
 		{
 			Position:  1,
-			PodcastID: 10,
-			EpisodeID: "guid1",
+			EpisodeID: 1,
 		},
 		{
 			Position:  2,
-			PodcastID: 1,
-			EpisodeID: "guid2",
+			EpisodeID: 2,
 		},
 		{
 			Position:  3,
-			PodcastID: 18,
-			EpisodeID: "guid3",
+			EpisodeID: 3,
 		},
 	}
 
@@ -190,28 +193,26 @@ func TestQueueHandler_DELETE(t *testing.T) {
 func TestAddToQueueHandler(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+	addQueuedEpisodes(db, t, 1, 2, 3, 99, 100)
 	method := "POST"
 
 	baseQueue := []models.QueueEpisode{
 		{
 			Position:  1,
-			PodcastID: 10,
-			EpisodeID: "guid1",
+			EpisodeID: 1,
 		},
 		{
 			Position:  2,
-			PodcastID: 1,
-			EpisodeID: "guid2",
+			EpisodeID: 2,
 		},
 		{
 			Position:  3,
-			PodcastID: 18,
-			EpisodeID: "guid3",
+			EpisodeID: 3,
 		},
 	}
 
@@ -222,8 +223,7 @@ func TestAddToQueueHandler(t *testing.T) {
 
 	extraEp := models.QueueEpisode{
 		Position:  baseQueue[len(baseQueue)-1].Position + 1,
-		PodcastID: 99,
-		EpisodeID: "guid99",
+		EpisodeID: 99,
 		Model: gorm.Model{
 			ID: baseQueue[len(baseQueue)-1].ID + 1,
 		},
@@ -243,6 +243,7 @@ func TestAddToQueueHandler(t *testing.T) {
 	// Remove fields of type time.Time to avoid a false positive (due to metadata diff)
 	extraEpFromDB.Model.CreatedAt = time.Time{}
 	extraEpFromDB.Model.UpdatedAt = time.Time{}
+	extraEpFromDB.ChangedAt = time.Time{}
 
 	assert.Equal(http.StatusCreated, r.StatusCode)
 	assert.Equal("application/json", r.Header.Get("Content-Type"))
@@ -252,8 +253,7 @@ func TestAddToQueueHandler(t *testing.T) {
 	/* Test 2 - Try to add the episode at the beginning of the queue */
 	extraEp2 := models.QueueEpisode{
 		Position:  1,
-		PodcastID: 100,
-		EpisodeID: "guid100",
+		EpisodeID: 100,
 		Model: gorm.Model{
 			ID: baseQueue[len(baseQueue)-1].ID + 2,
 		},
@@ -271,6 +271,7 @@ func TestAddToQueueHandler(t *testing.T) {
 	// Remove fields of type time.Time to avoid a false positive (due to metadata diff)
 	extraEp2FromDB.Model.CreatedAt = time.Time{}
 	extraEp2FromDB.Model.UpdatedAt = time.Time{}
+	extraEp2FromDB.ChangedAt = time.Time{}
 
 	assert.Equal(http.StatusCreated, r.StatusCode)
 	assert.Equal("application/json", r.Header.Get("Content-Type"))
@@ -284,35 +285,33 @@ func TestAddToQueueHandler(t *testing.T) {
 	}
 
 	for i, e := range allEps {
-		assert.Equal(i+1, e.Position, "One episode has the incorrect position (guid '%s')", e.EpisodeID)
+		assert.Equal(uint(i+1), e.Position, "One episode has the incorrect position (episode %d)", e.EpisodeID)
 	}
 }
 
 func TestDelFromQueueHandler(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
-	db, err := database.New(tempDir, "test.db")
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 	if err != nil {
 		assert.FailNow(err.Error())
 	}
-	mng := NewManager(db, make(chan *models.Podcast))
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+	addQueuedEpisodes(db, t, 1, 2, 3, 99, 100)
 	method := http.MethodDelete
 
 	baseQueue := []models.QueueEpisode{
 		{
 			Position:  1,
-			PodcastID: 10,
-			EpisodeID: "guid1",
+			EpisodeID: 1,
 		},
 		{
 			Position:  2,
-			PodcastID: 1,
-			EpisodeID: "guid2",
+			EpisodeID: 2,
 		},
 		{
 			Position:  3,
-			PodcastID: 18,
-			EpisodeID: "guid3",
+			EpisodeID: 3,
 		},
 	}
 
@@ -342,12 +341,19 @@ func TestDelFromQueueHandler(t *testing.T) {
 		queueFromDB[i].Model.UpdatedAt = time.Time{}
 		expectedQueue[i].Model.CreatedAt = time.Time{}
 		expectedQueue[i].Model.UpdatedAt = time.Time{}
+		queueFromDB[i].ChangedAt = time.Time{}
+		expectedQueue[i].ChangedAt = time.Time{}
 	}
 
 	assert.Equal(http.StatusNoContent, r.StatusCode)
 	assert.Equal("", r.Header.Get("Content-Type"))
 	assert.Equal(expectedQueue, queueFromDB)
 
+	var removed models.QueueEpisode
+	if assert.NoError(db.Unscoped().First(&removed, idToRemove).Error, "the removed episode should be kept to be synced") {
+		assert.True(removed.DeletedAt.Valid, "the removed episode should be soft-deleted")
+	}
+
 	// Try to remove an episode with a non-existent ID
 	r = testUtils.NewRequest(mng.DelFromQueueHandler, method, "?id="+fmt.Sprint(99), testUtils.NewBody(t, nil))
 
@@ -360,3 +366,13 @@ func TestDelFromQueueHandler(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, r.StatusCode)
 	assert.Equal("text/plain; charset=utf-8", r.Header.Get("Content-Type"))
 }
+
+// addQueuedEpisodes stores a podcast with the episodes of the given IDs, so they can be added to the queue.
+func addQueuedEpisodes(db *gorm.DB, t *testing.T, ids ...uint) {
+	p := &models.Podcast{FeedLink: "https://example.com/queue.xml"}
+	addOfflinePodcastToDB(p, db, t)
+
+	for _, id := range ids {
+		addOfflineEpisodeToDB(&models.Episode{Model: gorm.Model{ID: id}, PodcastID: p.ID, GUID: fmt.Sprint("episode-", id)}, db, t)
+	}
+}
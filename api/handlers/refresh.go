@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lincast/models"
+	"lincast/update"
+	"lincast/utils/safe"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+func (m *Manager) RefreshPodcastHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+
+	id := safe.SafeParseInt(idStr)
+	if id == safe.DefaultAllocate {
+		err := errorx.IllegalArgument.New("value is over the limit of int values or can't be parsed")
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The given ID cannot be parsed")
+
+		return
+	}
+
+	wait, err := parseWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'wait' can't be parsed")
+
+		return
+	}
+
+	var p models.Podcast
+
+	if res := m.db.WithContext(r.Context()).Where("id = ?", id).First(&p); res.Error != nil {
+		http.Error(w, "the podcast with the given ID does not exist", http.StatusNotFound)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.Decorate(res.Error, "the podcast with the given ID does not exist"),
+			"givenID":    id,
+		}).Error("Error when trying to get the podcast to refresh")
+
+		return
+	}
+
+	if ok, retryAfter := m.refreshLimit.allow(fmt.Sprintf("podcast:%d", id), time.Duration(m.refreshCooldown.Load())); !ok {
+		tooManyRequests(w, retryAfter)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"podcastID":  id,
+			"retryAfter": retryAfter.String(),
+		}).Warning("Refresh of the podcast rejected, it has been requested too recently")
+
+		return
+	}
+
+	job := update.NewJob(&p)
+	m.jobs.AddJob(job)
+
+	if err := m.enqueue(r.Context(), job); err != nil {
+		queueFull(w, err)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"jobID":      job.ID,
+			"podcastID":  p.ID,
+			"error":      err.Error(),
+		}).Warning("Refresh of the podcast rejected, the update queue is full")
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr":  r.RemoteAddr,
+		"jobID":       job.ID,
+		"podcastID":   p.ID,
+		"podcastFeed": p.FeedLink,
+	}).Info("Sending podcast to the update queue (manual refresh)")
+
+	if wait {
+		waitJob(r, job)
+	}
+
+	m.respondJob(w, r, job, "/api/v0/jobs/"+job.ID.String())
+}
+
+func (m *Manager) RefreshAllPodcastsHandler(w http.ResponseWriter, r *http.Request) {
+	wait, err := parseWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The query parameter 'wait' can't be parsed")
+
+		return
+	}
+
+	if ok, retryAfter := m.refreshLimit.allow("all", time.Duration(m.refreshAllCooldown.Load())); !ok {
+		tooManyRequests(w, retryAfter)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"retryAfter": retryAfter.String(),
+		}).Warning("Refresh of the library rejected, it has been requested too recently")
+
+		return
+	}
+
+	var ps []models.Podcast
+
+	if res := m.db.WithContext(r.Context()).Where("subscribed = ?", true).Find(&ps); res.Error != nil {
+		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(res.Error),
+		}).Error("Error when trying to get the podcasts to refresh")
+
+		return
+	}
+
+	jobs := make([]*update.Job, 0, len(ps))
+	for i := range ps {
+		jobs = append(jobs, update.NewJob(&ps[i]))
+	}
+
+	batch := update.NewBatch(jobs)
+	m.jobs.AddBatch(batch)
+
+	// The jobs that don't fit on the queue are reported as failed by the batch.
+	if err := m.enqueue(r.Context(), jobs...); err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"batchID":    batch.ID,
+			"error":      err.Error(),
+		}).Warning("Some of the podcasts can't be refreshed, the update queue is full")
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"batchID":    batch.ID,
+		"podcasts":   len(jobs),
+	}).Info("Sending all the podcasts to the update queue (manual refresh)")
+
+	if wait {
+		waitJob(r, batch)
+	}
+
+	m.respondJob(w, r, batch, "/api/v0/jobs/"+batch.ID.String())
+}
+
+// tooManyRequests responds with the status 429 Too Many Requests, letting the client know when it should retry.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "too many requests, retry later", http.StatusTooManyRequests)
+}
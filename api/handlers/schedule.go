@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"lincast/models"
+	"lincast/update"
+	"lincast/utils/safe"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetPodcastScheduleHandler sets the update schedule and quiet hours of a podcast. Empty values mean that the global
+// ones should be used.
+func (m *Manager) SetPodcastScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+
+	id := safe.SafeParseInt(idStr)
+	if id == safe.DefaultAllocate {
+		err := errorx.IllegalArgument.New("value is over the limit of int values or can't be parsed")
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The given ID cannot be parsed")
+
+		return
+	}
+
+	reqBody := struct {
+		UpdateSchedule string `json:"updateSchedule"`
+		QuietHours     string `json:"quietHours"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	if reqBody.UpdateSchedule != "" {
+		if _, err := update.ParseSchedule(reqBody.UpdateSchedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"error":      err.Error(),
+			}).Error("The given update schedule is not valid")
+
+			return
+		}
+	}
+
+	if _, err := update.ParseQuietHours(reqBody.QuietHours); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("The given quiet hours are not valid")
+
+		return
+	}
+
+	res := m.db.WithContext(r.Context()).Model(&models.Podcast{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"update_schedule": reqBody.UpdateSchedule,
+		"quiet_hours":     reqBody.QuietHours,
+	})
+	if res.Error != nil {
+		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(res.Error),
+			"podcastID":  id,
+		}).Error("Error when trying to update the schedule of the podcast")
+
+		return
+	}
+
+	if res.RowsAffected == 0 {
+		http.Error(w, "the podcast with the given ID does not exist", http.StatusNotFound)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"podcastID":  id,
+		}).Error("Error when trying to update the schedule of the podcast")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
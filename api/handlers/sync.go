@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"lincast/models"
+	"lincast/repositories"
+	"lincast/update"
+
+	"github.com/google/uuid"
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// subscriptionLinkTimeout is the maximum time to wait for the podcast of a new subscription to be stored before
+// linking it to the user.
+const subscriptionLinkTimeout = time.Minute * 10
+
+// Actions that can be done over an episode (see models.EpisodeAction).
+const (
+	episodeActionDownload = "download"
+	episodeActionPlay     = "play"
+	episodeActionDelete   = "delete"
+	episodeActionNew      = "new"
+)
+
+// applySubscriptionChanges subscribes the user to the feeds of `add` and unsubscribes it from the ones of `remove`,
+// recording the changes so they can be synced by other devices. Returns the timestamp of the changes.
+// Possible errors:
+//   - errorx.IllegalState: if the update queue is full.
+//   - errorx.InternalError: if the changes can't be applied or stored.
+func (m *Manager) applySubscriptionChanges(ctx context.Context, userID uuid.UUID, deviceID string, add, remove []string) (int64, error) {
+	now := time.Now().Unix()
+
+	for _, feed := range add {
+		if err := m.subscribeUser(ctx, userID, feed); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, feed := range remove {
+		if err := m.unsubscribeUser(ctx, userID, feed); err != nil {
+			return 0, err
+		}
+	}
+
+	changes := make([]models.SubscriptionChange, 0, len(add)+len(remove))
+	for _, feed := range add {
+		changes = append(changes, models.SubscriptionChange{
+			UserID: userID, DeviceID: deviceID, FeedLink: feed, Action: models.SubscriptionAdded, Timestamp: now,
+		})
+	}
+
+	for _, feed := range remove {
+		changes = append(changes, models.SubscriptionChange{
+			UserID: userID, DeviceID: deviceID, FeedLink: feed, Action: models.SubscriptionRemoved, Timestamp: now,
+		})
+	}
+
+	if len(changes) > 0 {
+		if err := m.db.WithContext(ctx).Create(&changes).Error; err != nil {
+			return 0, errorx.InternalError.Wrap(err, "the subscription changes can't be stored")
+		}
+	}
+
+	return now, nil
+}
+
+// subscriptionChangesSince returns the feeds to which the user has subscribed and unsubscribed since the given
+// timestamp. If `since` is 0, all the current subscriptions are returned as added.
+func (m *Manager) subscriptionChangesSince(ctx context.Context, userID uuid.UUID, since int64) (add []string, remove []string, err error) {
+	add, remove = []string{}, []string{}
+
+	if since == 0 {
+		err = m.db.WithContext(ctx).Table("podcasts").
+			Joins("JOIN subscriptions ON subscriptions.podcast_id = podcasts.id").
+			Where("subscriptions.user_id = ? AND podcasts.deleted_at IS NULL", userID).
+			Pluck("podcasts.feed_link", &add).Error
+		if err != nil {
+			return nil, nil, errorx.InternalError.Wrap(err, "the subscriptions of the user can't be obtained")
+		}
+
+		return add, remove, nil
+	}
+
+	var changes []models.SubscriptionChange
+
+	err = m.db.WithContext(ctx).Where("user_id = ? AND timestamp >= ?", userID, since).Order("timestamp, id").Find(&changes).Error
+	if err != nil {
+		return nil, nil, errorx.InternalError.Wrap(err, "the subscription changes can't be obtained")
+	}
+
+	// Only the last change of each feed matters.
+	last := make(map[string]string)
+	var order []string
+
+	for _, c := range changes {
+		if _, ok := last[c.FeedLink]; !ok {
+			order = append(order, c.FeedLink)
+		}
+
+		last[c.FeedLink] = c.Action
+	}
+
+	for _, feed := range order {
+		if last[feed] == models.SubscriptionAdded {
+			add = append(add, feed)
+		} else {
+			remove = append(remove, feed)
+		}
+	}
+
+	return add, remove, nil
+}
+
+// subscribeUser links the user to the podcast with the given feed. If the podcast is not on the database, a
+// subscription job is sent to the update queue and the user is linked once it's stored.
+// Possible errors:
+//   - errorx.IllegalState: if the update queue is full.
+//   - errorx.InternalError: if the podcast or the subscription can't be obtained or stored.
+func (m *Manager) subscribeUser(ctx context.Context, userID uuid.UUID, feed string) error {
+	var p models.Podcast
+
+	res := m.db.WithContext(ctx).Where("feed_link = ?", feed).Limit(1).Find(&p)
+	if res.Error != nil {
+		return errorx.InternalError.Wrap(res.Error, "the podcast can't be obtained")
+	}
+
+	if res.RowsAffected != 0 {
+		return m.linkSubscription(ctx, userID, p.ID)
+	}
+
+	job, added := m.jobs.AddSubscriptionJob(update.NewSubscriptionJob(feed))
+	if added {
+		if err := m.enqueue(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	// The subscription is linked after answering, so it can't be cancelled along with the request.
+	linkCtx := context.WithoutCancel(ctx)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), subscriptionLinkTimeout)
+		defer cancel()
+
+		if !job.Wait(ctx) {
+			return
+		}
+
+		p := job.StoredPodcast()
+		if p == nil {
+			return
+		}
+
+		if err := m.linkSubscription(linkCtx, userID, p.ID); err != nil {
+			log.WithFields(log.Fields{
+				"userID":    userID,
+				"podcastID": p.ID,
+				"error":     errorx.EnsureStackTrace(err),
+			}).Error("The new subscription can't be linked to the user")
+		}
+	}()
+
+	return nil
+}
+
+// unsubscribeUser removes the link between the user and the podcast with the given feed, if any.
+func (m *Manager) unsubscribeUser(ctx context.Context, userID uuid.UUID, feed string) error {
+	var p models.Podcast
+
+	res := m.db.WithContext(ctx).Where("feed_link = ?", feed).Limit(1).Find(&p)
+	if res.Error != nil {
+		return errorx.InternalError.Wrap(res.Error, "the podcast can't be obtained")
+	}
+
+	if res.RowsAffected == 0 {
+		return nil
+	}
+
+	err := m.db.WithContext(ctx).Exec("DELETE FROM subscriptions WHERE user_id = ? AND podcast_id = ?", userID, p.ID).Error
+	if err != nil {
+		return errorx.InternalError.Wrap(err, "the subscription can't be removed")
+	}
+
+	return nil
+}
+
+// linkSubscription stores the subscription of the user to the podcast, making sure that the podcast is kept updated.
+func (m *Manager) linkSubscription(ctx context.Context, userID uuid.UUID, podcastID uint) error {
+	err := m.db.WithContext(ctx).Table("subscriptions").
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(map[string]interface{}{"user_id": userID, "podcast_id": podcastID}).Error
+	if err != nil {
+		return errorx.InternalError.Wrap(err, "the subscription can't be stored")
+	}
+
+	err = m.db.WithContext(ctx).Model(&models.Podcast{}).Where("id = ?", podcastID).Update("subscribed", true).Error
+	if err != nil {
+		return errorx.InternalError.Wrap(err, "the podcast can't be marked as subscribed")
+	}
+
+	return nil
+}
+
+// storeEpisodeActions stores the given actions (that should belong to the same user) and applies them over the
+// progress and played status of the episodes. Returns the timestamp at which they have been received.
+func (m *Manager) storeEpisodeActions(ctx context.Context, userID uuid.UUID, actions []models.EpisodeAction) (int64, error) {
+	now := time.Now().Unix()
+
+	if len(actions) == 0 {
+		return now, nil
+	}
+
+	for i := range actions {
+		actions[i].UserID = userID
+		actions[i].Received = now
+	}
+
+	if err := m.db.WithContext(ctx).Create(&actions).Error; err != nil {
+		return 0, errorx.InternalError.Wrap(err, "the episode actions can't be stored")
+	}
+
+	for _, a := range actions {
+		if err := m.applyEpisodeAction(ctx, userID, a); err != nil {
+			log.WithFields(log.Fields{
+				"userID":  userID,
+				"episode": a.EpisodeURL,
+				"action":  a.Action,
+				"error":   errorx.EnsureStackTrace(err),
+			}).Error("The episode action can't be applied")
+		}
+	}
+
+	return now, nil
+}
+
+// applyEpisodeAction updates the progress and played status of the user on the episode referenced by the action,
+// unless they have been changed by a more recent action. Actions over episodes that are not on the database are
+// ignored.
+func (m *Manager) applyEpisodeAction(ctx context.Context, userID uuid.UUID, a models.EpisodeAction) error {
+	if a.Action != episodeActionPlay && a.Action != episodeActionNew {
+		return nil
+	}
+
+	ep, err := m.findEpisode(ctx, a.PodcastURL, a.EpisodeURL, a.GUID)
+	if err != nil || ep == nil {
+		return err
+	}
+
+	position := a.Position
+	if a.Action == episodeActionNew || position < 0 {
+		position = 0
+	}
+
+	progress := repositories.NewEpisodeProgressRepository(m.db.WithContext(ctx))
+
+	if _, err := progress.UpdateProgress(userID, ep.ID, uint(position), a.Timestamp); err != nil {
+		return err
+	}
+
+	played := a.Action == episodeActionPlay && a.Total > 0 && a.Position >= a.Total
+	if played || a.Action == episodeActionNew {
+		if _, err := progress.UpdatePlayed(userID, ep.ID, played, a.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findEpisode returns the episode of the podcast with the given feed URL that has the given enclosure URL or GUID,
+// or nil if there is not any.
+func (m *Manager) findEpisode(ctx context.Context, podcastURL, enclosureURL, guid string) (*models.Episode, error) {
+	var ep models.Episode
+
+	match := m.db.Where("episodes.enclosure_url = ?", enclosureURL)
+	if guid != "" {
+		match = match.Or("episodes.guid = ?", guid)
+	}
+
+	err := m.db.WithContext(ctx).
+		Joins("JOIN podcasts ON podcasts.id = episodes.podcast_id").
+		Where("podcasts.feed_link = ?", podcastURL).
+		Where(match).
+		First(&ep).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &ep, nil
+}
+
+// episodeActionsSince returns the actions of the user received since the given timestamp, optionally filtered by
+// podcast and device. If `aggregated` is true, only the last action of each episode is returned.
+func (m *Manager) episodeActionsSince(ctx context.Context, userID uuid.UUID, since int64, podcast, device string, aggregated bool) ([]models.EpisodeAction, error) {
+	var actions []models.EpisodeAction
+
+	q := m.db.WithContext(ctx).Where("user_id = ? AND received >= ?", userID, since)
+	if podcast != "" {
+		q = q.Where("podcast_url = ?", podcast)
+	}
+
+	if device != "" {
+		q = q.Where("device_id = ?", device)
+	}
+
+	if err := q.Order("timestamp, id").Find(&actions).Error; err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the episode actions can't be obtained")
+	}
+
+	if !aggregated {
+		return actions, nil
+	}
+
+	latest := make(map[string]int)
+	var result []models.EpisodeAction
+
+	for _, a := range actions {
+		if i, ok := latest[a.EpisodeURL]; ok {
+			result[i] = a
+
+			continue
+		}
+
+		latest[a.EpisodeURL] = len(result)
+		result = append(result, a)
+	}
+
+	return result, nil
+}
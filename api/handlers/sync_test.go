@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lincast/database"
+	"lincast/models"
+	"lincast/update"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestStoreEpisodeActions(t *testing.T) {
+	assert := assert2.New(t)
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+	mng := NewManager(db, make(chan *update.Job), "LinCast")
+
+	user := models.User{Username: "user", Email: "user"}
+	db.Create(&user)
+
+	// Both podcasts have an episode with the same GUID.
+	var eps []models.Episode
+	for _, feed := range []string{"https://example.com/a.xml", "https://example.com/b.xml"} {
+		p := &models.Podcast{FeedLink: feed}
+		addOfflinePodcastToDB(p, db, t)
+
+		ep := models.Episode{PodcastID: p.ID, GUID: "episode-1", EnclosureURL: feed + ".mp3"}
+		addOfflineEpisodeToDB(&ep, db, t)
+
+		eps = append(eps, ep)
+	}
+
+	now := time.Now()
+	actions := []models.EpisodeAction{
+		{PodcastURL: "https://example.com/b.xml", GUID: "episode-1", Action: episodeActionPlay, Timestamp: now, Position: 600, Total: 600},
+		{PodcastURL: "https://example.com/b.xml", GUID: "episode-1", Action: episodeActionPlay, Timestamp: now.Add(-time.Hour), Position: 60},
+	}
+
+	if _, err := mng.storeEpisodeActions(context.Background(), user.ID, actions); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	var progress []models.EpisodeProgress
+	db.Where("user_id = ?", user.ID).Find(&progress)
+
+	if assert.Len(progress, 1, "the episode of another podcast with the same GUID should not be modified") {
+		assert.Equal(eps[1].ID, progress[0].EpisodeID)
+		assert.EqualValues(600, progress[0].Progress, "an older action should not overwrite the progress")
+		assert.True(progress[0].Played, "the episode played until the end should be marked as played")
+	}
+
+	var b models.Episode
+	db.First(&b, eps[1].ID)
+
+	assert.Zero(b.CurrentProgress, "the progress of the user should not be stored on the shared episode")
+	assert.False(b.Played)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"lincast/update"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// workersStatus is the state of the workers of the update queue.
+type workersStatus struct {
+	Workers    int `json:"workers"`
+	Busy       int `json:"busy"`
+	MaxWorkers int `json:"maxWorkers"`
+}
+
+func (m *Manager) workersStatus() workersStatus {
+	return workersStatus{
+		Workers:    m.queue.Workers(),
+		Busy:       m.queue.Busy(),
+		MaxWorkers: update.MaxWorkers,
+	}
+}
+
+// GetWorkersHandler returns the number of workers of the update queue and how many of them are processing a job.
+func (m *Manager) GetWorkersHandler(w http.ResponseWriter, r *http.Request) {
+	if m.queue == nil {
+		http.Error(w, "the update queue is not available", http.StatusServiceUnavailable)
+
+		log.WithField("remoteAddr", r.RemoteAddr).Error("The workers can't be obtained, there is no update queue")
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, m.workersStatus())
+}
+
+// SetWorkersHandler changes the number of workers of the update queue. The workers that are stopped finish the job
+// that they are processing. The change is not persisted, so the configured number is used again after a restart.
+func (m *Manager) SetWorkersHandler(w http.ResponseWriter, r *http.Request) {
+	if m.queue == nil {
+		http.Error(w, "the update queue is not available", http.StatusServiceUnavailable)
+
+		log.WithField("remoteAddr", r.RemoteAddr).Error("The workers can't be changed, there is no update queue")
+
+		return
+	}
+
+	reqBody := struct {
+		Workers int `json:"workers"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      err.Error(),
+		}).Error("Error when trying to decode the body of the request")
+
+		return
+	}
+
+	previous := m.queue.Workers()
+
+	if err := m.queue.SetWorkers(reqBody.Workers); err != nil {
+		status := http.StatusInternalServerError
+		if errorx.IsOfType(err, errorx.IllegalArgument) {
+			status = http.StatusBadRequest
+		} else if errorx.IsOfType(err, errorx.IllegalState) {
+			status = http.StatusServiceUnavailable
+		}
+
+		http.Error(w, err.Error(), status)
+
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"workers":    reqBody.Workers,
+			"error":      err.Error(),
+		}).Error("The number of workers can't be changed")
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"previous":   previous,
+		"workers":    reqBody.Workers,
+	}).Info("Number of workers of the update queue changed")
+
+	writeJSON(w, r, http.StatusOK, m.workersStatus())
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lincast/metrics"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lincast_http_requests_total",
+		Help: "Number of HTTP requests answered, by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lincast_http_request_duration_seconds",
+		Help:    "Time spent answering the HTTP requests, by method and route pattern.",
+		Buckets: metrics.DefaultBuckets,
+	}, []string{"method", "route"})
+)
+
+// unmatchedRoute is the route of the metrics of the requests that don't match any route, so the paths requested
+// (which can be anything) don't create new samples.
+const unmatchedRoute = "unmatched"
+
+// instrument is a middleware that records the number and the duration of the requests by their route pattern (e.g.
+// "/api/v0/podcasts/{id}"), instead of by their path. It should be used on the root router.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		// The pattern is complete once the request has been routed.
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
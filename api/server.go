@@ -6,7 +6,10 @@ import (
 	"time"
 
 	"lincast/api/handlers"
-	"lincast/models"
+	"lincast/config"
+	"lincast/health"
+	"lincast/metrics"
+	"lincast/update"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -14,27 +17,63 @@ import (
 	"gorm.io/gorm"
 )
 
-// New creates a new instance of http.Server with the specified configurations.
+// Server is the HTTP server of LinCast. Its limits and allowed origins can be changed while it's running.
+type Server struct {
+	*http.Server
+
+	handlers *handlers.Manager
+	cors     *corsPolicy
+}
+
+// New creates a new instance of Server with the specified configurations.
 // It takes the following parameters:
-// - port: The port number on which the server will listen.
-// - localServer: A boolean indicating whether the server should only listen on the local loopback interface (127.0.0.1).
-// - devMode: A boolean indicating whether the server is running in development mode.
-// - logRequests: A boolean indicating whether to log incoming requests.
+// - cfg: The configuration of the server (listening port, whether it only listens on the local loopback interface,
+// dev mode, whether to log incoming requests, whether to expose the metrics and the origins allowed to make
+// cross-origin requests).
+// - auth: The configuration of the authentication of the users.
+// - limits: The limits of the requests (see SetLimits).
 // - db: A pointer to a gorm.DB instance representing the database connection.
-// - manualUpdate: A channel used for manual updates of podcast data.
+// - manualUpdate: A channel used to send jobs for manual updates of podcast data.
+// - queue: The update queue that processes the jobs, whose workers can be managed through the API (it can be nil).
+// - checker: The checks of the dependencies answered on /readyz (it can be nil).
 //
-// It returns a pointer to the created http.Server instance.
-func New(port uint, localServer bool, devMode bool, logRequests bool, db *gorm.DB, manualUpdate chan *models.Podcast) *http.Server {
-	handlersManager := handlers.NewManager(db, manualUpdate)
+// It returns a pointer to the created Server instance.
+func New(
+	cfg config.Server, auth config.Auth, limits config.Limits, db *gorm.DB, manualUpdate chan *update.Job,
+	queue *update.UpdateQueue, checker *health.Checker,
+) *Server {
+	handlersManager := handlers.NewManager(db, manualUpdate, auth.Realm)
+	handlersManager.SetUpdateQueue(queue)
+	cors := newCORSPolicy(cfg.CORSOrigins)
+
+	if checker == nil {
+		checker = health.NewChecker(0)
+	}
+
+	mux := createRouter(handlersManager)
+	mux.Method(http.MethodGet, "/healthz", health.LivenessHandler())
+	mux.Method(http.MethodGet, "/readyz", checker.ReadinessHandler())
+
+	// The metrics are only exposed if they have been enabled, since they are not authenticated.
+	if cfg.Metrics {
+		mux.Method(http.MethodGet, "/metrics", metrics.Handler())
+	}
 
-	router := createRouter(handlersManager)
+	// The CORS policy wraps the router, so the preflight requests are answered before being routed.
+	router := cors.handler(mux)
 
 	var addr string
-	if localServer {
+	if cfg.Local {
 		addr = "127.0.0.1"
 	}
 
-	s := createServer(addr, int(port), router)
+	s := &Server{
+		Server:   createServer(addr, int(cfg.Port), router),
+		handlers: handlersManager,
+		cors:     cors,
+	}
+
+	s.SetLimits(limits)
 
 	log.WithFields(log.Fields{
 		"address":        s.Addr,
@@ -46,6 +85,17 @@ func New(port uint, localServer bool, devMode bool, logRequests bool, db *gorm.D
 	return s
 }
 
+// SetLimits changes the limits of the requests. It can be called while the server is running.
+func (s *Server) SetLimits(limits config.Limits) {
+	s.handlers.SetRefreshCooldowns(limits.RefreshCooldown, limits.RefreshAllCooldown)
+}
+
+// SetCORSOrigins changes the origins allowed to make cross-origin requests to the comma separated list `origins` ("*"
+// allows any origin). It can be called while the server is running.
+func (s *Server) SetCORSOrigins(origins string) {
+	s.cors.setOrigins(origins)
+}
+
 // createServer creates a new HTTP server with the given address, port, and router.
 // The server is configured with a read timeout and write timeout of 15 seconds each,
 // and a maximum header size of 8KB.
@@ -72,6 +122,8 @@ func createRouter(handlersManager *handlers.Manager) *chi.Mux {
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID)
+	router.Use(instrument)
+	router.Use(trace)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.Heartbeat("/ping"))
@@ -87,8 +139,14 @@ func createRouter(handlersManager *handlers.Manager) *chi.Mux {
 
 		r.Route("/podcasts", func(r chi.Router) {
 			r.Post("/subscribe", handlersManager.SubscribeToPodcastHandler)
+			r.Post("/subscribe/bulk", handlersManager.BulkSubscribeHandler)
 			r.Put("/unsubscribe", handlersManager.UnsubscribeToPodcastHandler)
+			r.Get("/preview", handlersManager.PreviewPodcastHandler)
+			r.Post("/refresh-all", handlersManager.RefreshAllPodcastsHandler)
 			r.Get("/{id:[0-9]+}", handlersManager.GetPodcastHandler)
+			r.Delete("/{id:[0-9]+}", handlersManager.DeletePodcastHandler)
+			r.Post("/{id:[0-9]+}/refresh", handlersManager.RefreshPodcastHandler)
+			r.Put("/{id:[0-9]+}/schedule", handlersManager.SetPodcastScheduleHandler)
 			r.Get("/{id:[0-9]+}/episodes", handlersManager.GetEpisodesHandler)
 			r.Get("/{id:[0-9]+}/episodes/{epID:[0-9]+}", handlersManager.EpisodeDetailsHandler)
 			r.Get("/{id:[0-9]+}/episodes/{epID:[0-9]+}/progress", handlersManager.EpisodeProgressHandler)
@@ -97,8 +155,28 @@ func createRouter(handlersManager *handlers.Manager) *chi.Mux {
 			r.Get("/podcasts/latest_eps", handlersManager.LatestEpisodesHandler)
 		})
 
+		r.Get("/sync", handlersManager.SyncHandler)
+		r.Post("/sync", handlersManager.UploadSyncActionsHandler)
+
+		r.Route("/jobs", func(r chi.Router) {
+			r.Get("/{id}", handlersManager.JobHandler)
+			r.Get("/{id}/events", handlersManager.JobEventsHandler)
+		})
+
 		r.Route("/user", func(r chi.Router) {
 			r.Get("/subscriptions", handlersManager.GetUserPodcastsHandler)
+			r.Get("/subscriptions.opml", handlersManager.ExportOPMLHandler)
+			r.Post("/subscriptions.opml", handlersManager.ImportOPMLHandler)
+			r.Post("/history/import", handlersManager.ImportHistoryHandler)
+			r.Get("/backup", handlersManager.ExportAccountHandler)
+			r.Post("/restore", handlersManager.RestoreAccountHandler)
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(handlersManager.RequireAuth)
+
+			r.Get("/workers", handlersManager.GetWorkersHandler)
+			r.Put("/workers", handlersManager.SetWorkersHandler)
 		})
 
 		r.Route("/player", func(r chi.Router) {
@@ -116,5 +194,25 @@ func createRouter(handlersManager *handlers.Manager) *chi.Mux {
 		})
 	})
 
+	// gpodder.net compatible API, used by clients like AntennaPod to sync subscriptions and progress.
+	router.Route("/api/2", func(r chi.Router) {
+		r.Post("/auth/{username}/login.json", handlersManager.GpodderLoginHandler)
+		r.Post("/auth/{username}/logout.json", handlersManager.GpodderLogoutHandler)
+		r.Get("/devices/{username}", handlersManager.GpodderDevicesHandler)
+		r.Post("/devices/{username}/{deviceid}", handlersManager.GpodderUpdateDeviceHandler)
+		r.Get("/subscriptions/{username}/{deviceid}", handlersManager.GpodderSubscriptionsHandler)
+		r.Post("/subscriptions/{username}/{deviceid}", handlersManager.GpodderSubscriptionsHandler)
+		r.Get("/episodes/{username}", handlersManager.GpodderEpisodeActionsHandler)
+		r.Post("/episodes/{username}", handlersManager.GpodderEpisodeActionsHandler)
+	})
+
+	// Nextcloud gPodder Sync app compatible API, for the clients that only support that sync backend.
+	router.Route("/index.php/apps/gpoddersync", func(r chi.Router) {
+		r.Get("/subscriptions", handlersManager.NextcloudSubscriptionsHandler)
+		r.Post("/subscription_change/create", handlersManager.NextcloudSubscriptionChangeHandler)
+		r.Get("/episode_action", handlersManager.NextcloudEpisodeActionsHandler)
+		r.Post("/episode_action/create", handlersManager.NextcloudCreateEpisodeActionsHandler)
+	})
+
 	return router
 }
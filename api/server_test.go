@@ -6,11 +6,14 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"lincast/api/handlers"
-	"lincast/models"
+	"lincast/config"
+	"lincast/database"
+	"lincast/update"
 
 	assert2 "github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -49,6 +52,10 @@ func (s *ServerTestSuite) TestIndex() {
 
 	// Open the index file and read the content.
 	indexFileContent, err := os.ReadFile(fd)
+	if os.IsNotExist(err) {
+		s.T().Skip("the frontend is not built")
+	}
+
 	if err != nil {
 		panic(err)
 	}
@@ -71,7 +78,7 @@ func (s *ServerTestSuite) TestNewServer() {
 
 	// Mock dependencies
 	db := &gorm.DB{}
-	manualUpdate := make(chan *models.Podcast)
+	manualUpdate := make(chan *update.Job)
 
 	// Test cases
 	tests := []struct {
@@ -87,7 +94,8 @@ func (s *ServerTestSuite) TestNewServer() {
 	}
 
 	for _, tt := range tests {
-		server := New(tt.port, tt.localServer, tt.devMode, tt.logRequests, db, manualUpdate)
+		cfg := config.Server{Port: tt.port, Local: tt.localServer, DevMode: tt.devMode, LogRequests: tt.logRequests}
+		server := New(cfg, config.Defaults().Auth, config.Defaults().Limits, db, manualUpdate, nil, nil)
 
 		expectedAddr := "127.0.0.1:" + strconv.Itoa(int(tt.port))
 		if !tt.localServer {
@@ -100,3 +108,33 @@ func (s *ServerTestSuite) TestNewServer() {
 		assert.Equal(8000, server.MaxHeaderBytes, "server max header bytes should be 8KB")
 	}
 }
+
+func (s *ServerTestSuite) TestRestrictedRoutes() {
+	assert := assert2.New(s.T())
+
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(s.T().TempDir(), "test.db")})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	server := New(config.Server{}, config.Defaults().Auth, config.Defaults().Limits, db, make(chan *update.Job), nil, nil)
+
+	res := httptest.NewRecorder()
+	server.Handler.ServeHTTP(res, httptest.NewRequest("PUT", "/api/v0/admin/workers", strings.NewReader(`{"workers":1}`)))
+
+	assert.Equal(401, res.Code, "the administration should require authentication")
+
+	res = httptest.NewRecorder()
+	server.Handler.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.NotContains(res.Body.String(), "go_goroutines", "the metrics should not be exposed unless enabled")
+
+	server = New(config.Server{Metrics: true}, config.Defaults().Auth, config.Defaults().Limits, db,
+		make(chan *update.Job), nil, nil)
+
+	res = httptest.NewRecorder()
+	server.Handler.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(200, res.Code)
+	assert.Contains(res.Body.String(), "go_goroutines")
+}
@@ -0,0 +1,345 @@
+package backup
+
+import (
+	"errors"
+	"time"
+
+	"lincast/history"
+	"lincast/models"
+	"lincast/repositories"
+
+	"github.com/joomcode/errorx"
+	"gorm.io/gorm"
+)
+
+// AccountVersion is the version of the format of the account archives. Archives with a greater version can't be
+// restored. Version 1 holds the subscriptions (with their settings), the queue, the playback info and the listening
+// history; the users, devices and episode actions of the gpodder.net compatible API are not part of it.
+const AccountVersion = 1
+
+// AccountKind identifies the account archives.
+const AccountKind = "lincast-account"
+
+// Account is the portable snapshot of the data of the user: subscriptions (with their settings), queue, playback
+// info and listening history (played state and progress of the episodes, as synced by all the clients). Episodes are
+// referenced by feed URL, GUID and enclosure URL, since their identifiers differ between instances. The state of the
+// gpodder.net compatible sync (users, devices and episode actions) and the configuration of the instance are not
+// included.
+type Account struct {
+	Kind          string         `json:"kind"`
+	Version       int            `json:"version"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	Queue         []QueueEntry   `json:"queue"`
+	Playback      *EpisodeRef    `json:"playback,omitempty"` // Episode being played, if any
+	History       []EpisodeState `json:"history"`            // Episodes played or started
+}
+
+// Subscription is a subscription of the user, with its settings.
+type Subscription struct {
+	FeedURL        string `json:"feedURL"`
+	Title          string `json:"title"`
+	Group          string `json:"group,omitempty"`
+	UpdateSchedule string `json:"updateSchedule,omitempty"`
+	QuietHours     string `json:"quietHours,omitempty"`
+}
+
+// EpisodeRef references an episode in a portable way.
+type EpisodeRef struct {
+	FeedURL      string `json:"feedURL"`
+	GUID         string `json:"guid,omitempty"`
+	EnclosureURL string `json:"enclosureURL,omitempty"`
+	Title        string `json:"title,omitempty"`
+}
+
+// QueueEntry is an episode of the queue.
+type QueueEntry struct {
+	Episode  EpisodeRef `json:"episode"`
+	Position uint       `json:"position"`
+}
+
+// EpisodeState is the played state and progress of an episode.
+type EpisodeState struct {
+	Episode   EpisodeRef    `json:"episode"`
+	Played    bool          `json:"played"`
+	Progress  time.Duration `json:"progress"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+// RestoreReport is the result of restoring an account archive.
+type RestoreReport struct {
+	Subscriptions int          `json:"subscriptions"`
+	Missing       []string     `json:"missing"` // Feeds of the subscriptions that are not on the instance yet
+	History       int          `json:"history"`
+	Queue         int          `json:"queue"`
+	Playback      bool         `json:"playback"`
+	Unmatched     []EpisodeRef `json:"unmatched"` // Episodes of the archive not found on the instance
+}
+
+// ExportAccount returns a snapshot of the data of the user (see Account for what is included).
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+func ExportAccount(db *gorm.DB) (*Account, error) {
+	acc := &Account{
+		Kind:          AccountKind,
+		Version:       AccountVersion,
+		CreatedAt:     time.Now().UTC(),
+		Subscriptions: []Subscription{},
+		Queue:         []QueueEntry{},
+		History:       []EpisodeState{},
+	}
+
+	var podcasts []models.Podcast
+
+	if err := db.Where("subscribed = ?", true).Order("id").Find(&podcasts).Error; err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the subscriptions can't be obtained")
+	}
+
+	for _, p := range podcasts {
+		acc.Subscriptions = append(acc.Subscriptions, Subscription{
+			FeedURL:        p.FeedLink,
+			Title:          p.Title,
+			Group:          p.Group,
+			UpdateSchedule: p.UpdateSchedule,
+			QuietHours:     p.QuietHours,
+		})
+	}
+
+	refs := newRefResolver(db)
+
+	var episodes []models.Episode
+
+	if err := db.Where("played = ? OR current_progress > 0", true).Order("id").Find(&episodes).Error; err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the listening history can't be obtained")
+	}
+
+	for _, ep := range episodes {
+		ref, err := refs.ref(&ep)
+		if err != nil {
+			return nil, err
+		}
+
+		acc.History = append(acc.History, EpisodeState{
+			Episode:   ref,
+			Played:    ep.Played,
+			Progress:  ep.CurrentProgress,
+			UpdatedAt: ep.UpdatedAt,
+		})
+	}
+
+	var queue []models.QueueEpisode
+
+	if err := db.Preload("Episode").Order("position").Find(&queue).Error; err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the queue can't be obtained")
+	}
+
+	for _, q := range queue {
+		ref, err := refs.ref(&q.Episode)
+		if err != nil {
+			return nil, err
+		}
+
+		acc.Queue = append(acc.Queue, QueueEntry{Episode: ref, Position: q.Position})
+	}
+
+	var playback models.PlaybackInfo
+
+	err := db.Preload("Episode").First(&playback).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errorx.InternalError.Wrap(err, "the playback info can't be obtained")
+	}
+
+	if err == nil && playback.EpisodeID != 0 {
+		ref, err := refs.ref(&playback.Episode)
+		if err != nil {
+			return nil, err
+		}
+
+		acc.Playback = &ref
+	}
+
+	return acc, nil
+}
+
+// MissingFeeds returns the subscriptions of the archive whose podcast is not on the database. They should be
+// subscribed to before restoring the archive, so their episodes can be found.
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+func MissingFeeds(db *gorm.DB, acc *Account) ([]Subscription, error) {
+	var missing []Subscription
+
+	for _, s := range acc.Subscriptions {
+		var count int64
+
+		if err := db.Model(&models.Podcast{}).Where("feed_link = ?", s.FeedURL).Count(&count).Error; err != nil {
+			return nil, errorx.InternalError.Wrap(err, "the podcast can't be obtained")
+		}
+
+		if count == 0 {
+			missing = append(missing, s)
+		}
+	}
+
+	return missing, nil
+}
+
+// RestoreAccount restores the archive over the database. The values of the archive replace the current ones (the
+// queue is replaced whole), so restoring the same archive several times has the same result. The subscriptions
+// whose podcast is not on the database are reported as missing and their episodes as unmatched.
+// Possible errors:
+//   - errorx.IllegalArgument: if the archive is not an account archive or its version is not supported.
+//   - errorx.InternalError: if there is an error with the database.
+func RestoreAccount(db *gorm.DB, acc *Account) (*RestoreReport, error) {
+	if err := checkVersion(acc.Kind, AccountKind, acc.Version, AccountVersion); err != nil {
+		return nil, err
+	}
+
+	report := &RestoreReport{Missing: []string{}, Unmatched: []EpisodeRef{}}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, s := range acc.Subscriptions {
+			res := tx.Model(&models.Podcast{}).Where("feed_link = ?", s.FeedURL).Updates(map[string]interface{}{
+				"subscribed":      true,
+				"group_name":      s.Group,
+				"update_schedule": s.UpdateSchedule,
+				"quiet_hours":     s.QuietHours,
+			})
+			if res.Error != nil {
+				return errorx.InternalError.Wrap(res.Error, "the subscription to '%s' can't be restored", s.FeedURL)
+			}
+
+			if res.RowsAffected == 0 {
+				report.Missing = append(report.Missing, s.FeedURL)
+			} else {
+				report.Subscriptions++
+			}
+		}
+
+		matcher := history.NewMatcher(tx)
+		find := func(ref EpisodeRef) (*models.Episode, error) {
+			ep, err := matcher.Episode(ref.FeedURL, ref.GUID, ref.EnclosureURL)
+			if err == nil && ep == nil {
+				report.Unmatched = append(report.Unmatched, ref)
+			}
+
+			return ep, err
+		}
+
+		for _, st := range acc.History {
+			ep, err := find(st.Episode)
+			if err != nil {
+				return err
+			}
+
+			if ep == nil {
+				continue
+			}
+
+			err = tx.Model(ep).Updates(map[string]interface{}{
+				"played":           st.Played,
+				"current_progress": st.Progress,
+			}).Error
+			if err != nil {
+				return errorx.InternalError.Wrap(err, "the state of the episode %d can't be restored", ep.ID)
+			}
+
+			report.History++
+		}
+
+		queue := make([]models.QueueEpisode, 0, len(acc.Queue))
+
+		for _, q := range acc.Queue {
+			ep, err := find(q.Episode)
+			if err != nil {
+				return err
+			}
+
+			if ep != nil {
+				queue = append(queue, models.QueueEpisode{EpisodeID: ep.ID, Position: q.Position})
+			}
+		}
+
+		// The entries are soft-deleted, so the sync of the clients can know that they were removed from the queue.
+		if _, err := repositories.NewQueueRepository(tx).RemoveWhere(time.Now(), "1 = 1"); err != nil {
+			return errorx.InternalError.Wrap(err, "the queue can't be cleaned")
+		}
+
+		if len(queue) > 0 {
+			if err := tx.Create(&queue).Error; err != nil {
+				return errorx.InternalError.Wrap(err, "the queue can't be restored")
+			}
+		}
+
+		report.Queue = len(queue)
+
+		if acc.Playback != nil {
+			ep, err := find(*acc.Playback)
+			if err != nil {
+				return err
+			}
+
+			if ep != nil {
+				if err := restorePlayback(tx, ep.ID); err != nil {
+					return err
+				}
+
+				report.Playback = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// restorePlayback sets the episode being played, updating the only row of the playback info or creating it.
+func restorePlayback(tx *gorm.DB, episodeID uint) error {
+	var playback models.PlaybackInfo
+
+	res := tx.Limit(1).Find(&playback)
+	if res.Error != nil {
+		return errorx.InternalError.Wrap(res.Error, "the playback info can't be obtained")
+	}
+
+	if res.RowsAffected == 0 {
+		res = tx.Create(&models.PlaybackInfo{EpisodeID: episodeID})
+	} else {
+		res = tx.Model(&playback).Update("episode_id", episodeID)
+	}
+
+	if res.Error != nil {
+		return errorx.InternalError.Wrap(res.Error, "the playback info can't be restored")
+	}
+
+	return nil
+}
+
+// refResolver builds the references of the episodes, caching the feed of each podcast.
+type refResolver struct {
+	db    *gorm.DB
+	feeds map[uint]string
+}
+
+func newRefResolver(db *gorm.DB) *refResolver {
+	return &refResolver{db: db, feeds: make(map[uint]string)}
+}
+
+func (r *refResolver) ref(ep *models.Episode) (EpisodeRef, error) {
+	feed, ok := r.feeds[ep.PodcastID]
+	if !ok {
+		var p models.Podcast
+
+		if err := r.db.Unscoped().Select("feed_link").Where("id = ?", ep.PodcastID).Limit(1).Find(&p).Error; err != nil {
+			return EpisodeRef{}, errorx.InternalError.Wrap(err, "the podcast %d can't be obtained", ep.PodcastID)
+		}
+
+		feed = p.FeedLink
+		r.feeds[ep.PodcastID] = feed
+	}
+
+	return EpisodeRef{FeedURL: feed, GUID: ep.GUID, EnclosureURL: ep.EnclosureURL, Title: ep.Title}, nil
+}
@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"lincast/models"
+
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns an in-memory database with a subscribed podcast with two episodes.
+func newTestDB(t *testing.T) *gorm.DB {
+	db := newEmptyDB(t)
+
+	p := models.Podcast{FeedLink: "https://changelog.com/gotime/feed", Title: "Go Time", Subscribed: true}
+	db.Create(&p)
+
+	db.Create(&[]models.Episode{
+		{PodcastID: p.ID, GUID: "gt-1", EnclosureURL: "https://example.com/gt-1.mp3"},
+		{PodcastID: p.ID, GUID: "gt-2", EnclosureURL: "https://example.com/gt-2.mp3"},
+	})
+
+	return db
+}
+
+func TestAccountRoundTrip(t *testing.T) {
+	assert := assert2.New(t)
+
+	src := newTestDB(t)
+	src.Model(&models.Podcast{}).Where("1 = 1").Updates(map[string]interface{}{"group_name": "Tech", "quiet_hours": "23:00-07:00"})
+	src.Model(&models.Episode{}).Where("guid = ?", "gt-1").Update("played", true)
+	src.Model(&models.Episode{}).Where("guid = ?", "gt-2").Update("current_progress", time.Minute)
+	src.Create(&models.QueueEpisode{EpisodeID: 2, Position: 1})
+	src.Create(&models.PlaybackInfo{EpisodeID: 2})
+
+	acc, err := ExportAccount(src)
+	if !assert.NoError(err, "the account should be exported without errors") {
+		return
+	}
+
+	var buf bytes.Buffer
+	if !assert.NoError(WriteAccount(&buf, acc, FormatZIP)) {
+		return
+	}
+
+	read, err := ReadAccount(&buf)
+	if !assert.NoError(err, "the ZIP archive should be read without errors") {
+		return
+	}
+
+	dst := newTestDB(t)
+
+	for i := 0; i < 2; i++ {
+		report, err := RestoreAccount(dst, read)
+		if !assert.NoError(err, "the account should be restored without errors") {
+			return
+		}
+
+		assert.Equal(1, report.Subscriptions)
+		assert.Equal(2, report.History)
+		assert.Equal(1, report.Queue)
+		assert.True(report.Playback)
+		assert.Empty(report.Unmatched)
+	}
+
+	var p models.Podcast
+	dst.First(&p)
+	assert.Equal("Tech", p.Group, "the settings of the subscription should be restored")
+	assert.Equal("23:00-07:00", p.QuietHours, "the settings of the subscription should be restored")
+
+	var episodes []models.Episode
+	dst.Order("id").Find(&episodes)
+	assert.True(episodes[0].Played, "the played state should be restored")
+	assert.Equal(time.Minute, episodes[1].CurrentProgress, "the progress should be restored")
+
+	var queue []models.QueueEpisode
+	dst.Find(&queue)
+	assert.Len(queue, 1, "restoring the archive twice shouldn't duplicate the queue")
+}
+
+func TestReadAccountNewerVersion(t *testing.T) {
+	assert := assert2.New(t)
+
+	_, err := ReadAccount(bytes.NewBufferString(`{"kind": "lincast-account", "version": 99}`))
+	assert.Error(err, "an archive with a newer version should be rejected")
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/joomcode/errorx"
+)
+
+// Formats of the archives.
+const (
+	FormatJSON = "json"
+	FormatZIP  = "zip"
+)
+
+// accountFile is the name of the file that contains the account inside ZIP archives.
+const accountFile = "account.json"
+
+// zipMagic are the first bytes of every ZIP file.
+var zipMagic = []byte("PK\x03\x04")
+
+// WriteAccount writes the account to `w` as a JSON document or, if `format` is FormatZIP, as a ZIP archive
+// containing it.
+// Possible errors:
+//   - errorx.IllegalArgument: if the format is not supported.
+//   - errorx.ExternalError: if the archive can't be written.
+func WriteAccount(w io.Writer, acc *Account, format string) error {
+	return writeArchive(w, accountFile, acc, format)
+}
+
+// ReadAccount reads an account archive, either a JSON document or a ZIP archive containing it.
+// Possible errors:
+//   - errorx.IllegalFormat: if the archive can't be parsed.
+//   - errorx.IllegalArgument: if it's not an account archive or its version is not supported.
+func ReadAccount(r io.Reader) (*Account, error) {
+	var acc Account
+
+	if err := readArchive(r, accountFile, &acc); err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion(acc.Kind, AccountKind, acc.Version, AccountVersion); err != nil {
+		return nil, err
+	}
+
+	return &acc, nil
+}
+
+func writeArchive(w io.Writer, name string, v interface{}, format string) error {
+	switch format {
+	case FormatJSON, "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(v); err != nil {
+			return errorx.ExternalError.Wrap(err, "the archive can't be written")
+		}
+
+	case FormatZIP:
+		zw := zip.NewWriter(w)
+
+		f, err := zw.Create(name)
+		if err != nil {
+			return errorx.ExternalError.Wrap(err, "the archive can't be written")
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(v); err != nil {
+			return errorx.ExternalError.Wrap(err, "the archive can't be written")
+		}
+
+		if err := zw.Close(); err != nil {
+			return errorx.ExternalError.Wrap(err, "the archive can't be written")
+		}
+
+	default:
+		return errorx.IllegalArgument.New("unknown archive format '%s'", format)
+	}
+
+	return nil
+}
+
+// readArchive decodes on `v` the JSON document read from `r` or, if `r` is a ZIP archive, the file `name` inside it.
+func readArchive(r io.Reader, name string, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errorx.IllegalFormat.Wrap(err, "the archive can't be read")
+	}
+
+	if bytes.HasPrefix(data, zipMagic) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return errorx.IllegalFormat.Wrap(err, "the ZIP archive can't be read")
+		}
+
+		f, err := zr.Open(name)
+		if err != nil {
+			return errorx.IllegalFormat.Wrap(err, "the ZIP archive doesn't contain '%s'", name)
+		}
+		defer f.Close()
+
+		if err := json.NewDecoder(f).Decode(v); err != nil {
+			return errorx.IllegalFormat.Wrap(err, "the archive can't be parsed")
+		}
+
+		return nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errorx.IllegalFormat.Wrap(err, "the archive can't be parsed")
+	}
+
+	return nil
+}
+
+// checkVersion returns an error if the archive is not of the expected kind or it has been created by a newer
+// version of LinCast.
+func checkVersion(kind, expectedKind string, version, maxVersion int) error {
+	if kind != expectedKind {
+		return errorx.IllegalArgument.New("the archive is not of type '%s'", expectedKind)
+	}
+
+	if version < 1 || version > maxVersion {
+		return errorx.IllegalArgument.New("the version %d of the archive is not supported (max: %d)", version, maxVersion)
+	}
+
+	return nil
+}
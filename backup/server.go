@@ -0,0 +1,391 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ServerVersion is the version of the format of the server archives. Archives with a greater version can't be
+// restored.
+const ServerVersion = 1
+
+// ServerKind identifies the server archives.
+const ServerKind = "lincast-server"
+
+// manifestFile is the name of the file that describes the content of server archives.
+const manifestFile = "manifest.json"
+
+// batchSize is the number of rows read or written at once.
+const batchSize = 500
+
+// Manifest describes the content of a server archive. Each table is stored on its own file, with a JSON object per
+// line whose keys are the names of the columns.
+type Manifest struct {
+	Kind      string      `json:"kind"`
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Tables    []TableInfo `json:"tables"`
+}
+
+// TableInfo describes a table of a server archive.
+type TableInfo struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Rows int64  `json:"rows"`
+}
+
+// table is a table to back up: the one of a model or a join table (without model) of a many to many relationship.
+type table struct {
+	name   string
+	schema *schema.Schema // nil for join tables
+}
+
+// WriteServer streams all the rows (including the soft deleted ones) of the tables of the given models, and of
+// their join tables, to a ZIP archive written to `w`. The models should be sorted so every model comes after the
+// ones it references, since they are restored in the same order.
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+//   - errorx.ExternalError: if the archive can't be written.
+func WriteServer(w io.Writer, db *gorm.DB, models []interface{}) (*Manifest, error) {
+	tables, err := tablesOf(db, models)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Kind: ServerKind, Version: ServerVersion, CreatedAt: time.Now().UTC()}
+	zw := zip.NewWriter(w)
+
+	for _, t := range tables {
+		info := TableInfo{Name: t.name, File: "tables/" + t.name + ".jsonl"}
+
+		f, err := zw.Create(info.File)
+		if err != nil {
+			return nil, errorx.ExternalError.Wrap(err, "the archive can't be written")
+		}
+
+		info.Rows, err = dumpTable(db, t, json.NewEncoder(f))
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Tables = append(manifest.Tables, info)
+	}
+
+	f, err := zw.Create(manifestFile)
+	if err != nil {
+		return nil, errorx.ExternalError.Wrap(err, "the archive can't be written")
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(manifest); err != nil {
+		return nil, errorx.ExternalError.Wrap(err, "the archive can't be written")
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, errorx.ExternalError.Wrap(err, "the archive can't be written")
+	}
+
+	return manifest, nil
+}
+
+// RestoreServer restores a server archive into the given database, that should already have the tables of the
+// models and be empty. All the rows are inserted in a single transaction, keeping their primary keys.
+// Possible errors:
+//   - errorx.IllegalFormat: if the archive can't be read.
+//   - errorx.IllegalArgument: if it's not a server archive, its version is not supported, it contains unknown tables
+//     or the database is not empty.
+//   - errorx.InternalError: if there is an error with the database.
+func RestoreServer(r io.ReaderAt, size int64, db *gorm.DB, models []interface{}) (*Manifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the ZIP archive can't be read")
+	}
+
+	mf, err := zr.Open(manifestFile)
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the archive doesn't contain '%s'", manifestFile)
+	}
+
+	var manifest Manifest
+
+	err = json.NewDecoder(mf).Decode(&manifest)
+	mf.Close()
+
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the manifest of the archive can't be parsed")
+	}
+
+	if err := checkVersion(manifest.Kind, ServerKind, manifest.Version, ServerVersion); err != nil {
+		return nil, err
+	}
+
+	tables, err := tablesOf(db, models)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]table, len(tables))
+	for _, t := range tables {
+		byName[t.name] = t
+	}
+
+	for _, info := range manifest.Tables {
+		t, ok := byName[info.Name]
+		if !ok {
+			return nil, errorx.IllegalArgument.New("the table '%s' of the archive is unknown", info.Name)
+		}
+
+		var count int64
+
+		if err := db.Table(t.name).Count(&count).Error; err != nil {
+			return nil, errorx.InternalError.Wrap(err, "the rows of the table '%s' can't be counted", t.name)
+		}
+
+		if count > 0 {
+			return nil, errorx.IllegalArgument.New("the database is not empty (table '%s' has %d rows)", t.name, count)
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Session(&gorm.Session{SkipHooks: true})
+
+		for _, info := range manifest.Tables {
+			f, err := zr.Open(info.File)
+			if err != nil {
+				return errorx.IllegalFormat.Wrap(err, "the archive doesn't contain '%s'", info.File)
+			}
+
+			err = loadTable(tx, byName[info.Name], json.NewDecoder(f))
+			f.Close()
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return resetSequences(tx, tables)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// resetSequences advances the sequences of the auto increment primary keys on PostgreSQL past the restored rows,
+// since inserting explicit keys doesn't update them.
+func resetSequences(tx *gorm.DB, tables []table) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	for _, t := range tables {
+		if t.schema == nil || t.schema.PrioritizedPrimaryField == nil || !t.schema.PrioritizedPrimaryField.AutoIncrement {
+			continue
+		}
+
+		column := t.schema.PrioritizedPrimaryField.DBName
+
+		err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', '%[2]s'), COALESCE(MAX(%[2]s), 0) + 1, false) FROM %[1]s",
+			t.name, column)).Error
+		if err != nil {
+			return errorx.InternalError.Wrap(err, "the sequence of the table '%s' can't be reset", t.name)
+		}
+	}
+
+	return nil
+}
+
+// tablesOf returns the tables of the given models, followed by their join tables.
+func tablesOf(db *gorm.DB, models []interface{}) ([]table, error) {
+	var tables []table
+	var joinTables []table
+
+	seen := make(map[string]bool)
+
+	for _, m := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return nil, errorx.InternalError.Wrap(err, "the schema of the model can't be parsed")
+		}
+
+		tables = append(tables, table{name: stmt.Schema.Table, schema: stmt.Schema})
+		seen[stmt.Schema.Table] = true
+
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable != nil && !seen[rel.JoinTable.Table] {
+				seen[rel.JoinTable.Table] = true
+				joinTables = append(joinTables, table{name: rel.JoinTable.Table})
+			}
+		}
+	}
+
+	return append(tables, joinTables...), nil
+}
+
+// dumpTable encodes every row of the table with `enc`, returning the number of rows.
+func dumpTable(db *gorm.DB, t table, enc *json.Encoder) (int64, error) {
+	var rows int64
+
+	if t.schema == nil {
+		// Join tables don't have a primary key to read them in batches, so they are read row by row.
+		cursor, err := db.Table(t.name).Rows()
+		if err != nil {
+			return 0, wrapDumpError(err, t.name)
+		}
+		defer cursor.Close()
+
+		for cursor.Next() {
+			row := make(map[string]interface{})
+
+			if err := db.ScanRows(cursor, &row); err != nil {
+				return 0, wrapDumpError(err, t.name)
+			}
+
+			if err := enc.Encode(row); err != nil {
+				return 0, errorx.ExternalError.Wrap(err, "the archive can't be written")
+			}
+
+			rows++
+		}
+
+		return rows, wrapDumpError(cursor.Err(), t.name)
+	}
+
+	ctx := context.Background()
+	batch := reflect.New(reflect.SliceOf(t.schema.ModelType))
+
+	res := db.Unscoped().Model(reflect.New(t.schema.ModelType).Interface()).
+		FindInBatches(batch.Interface(), batchSize, func(tx *gorm.DB, _ int) error {
+			values := batch.Elem()
+
+			for i := 0; i < values.Len(); i++ {
+				row := make(map[string]interface{}, len(t.schema.DBNames))
+
+				for _, f := range t.schema.Fields {
+					if f.DBName != "" {
+						row[f.DBName], _ = f.ValueOf(ctx, values.Index(i))
+					}
+				}
+
+				if err := enc.Encode(row); err != nil {
+					return errorx.ExternalError.Wrap(err, "the archive can't be written")
+				}
+			}
+
+			rows += int64(values.Len())
+
+			return nil
+		})
+
+	return rows, wrapDumpError(res.Error, t.name)
+}
+
+func wrapDumpError(err error, table string) error {
+	if err == nil || errorx.IsOfType(err, errorx.ExternalError) {
+		return err
+	}
+
+	return errorx.InternalError.Wrap(err, "the rows of the table '%s' can't be read", table)
+}
+
+// loadTable inserts all the rows decoded by `dec` into the table.
+func loadTable(tx *gorm.DB, t table, dec *json.Decoder) error {
+	dec.UseNumber()
+
+	ctx := context.Background()
+
+	var batch reflect.Value
+	var rows []map[string]interface{}
+
+	if t.schema != nil {
+		batch = reflect.MakeSlice(reflect.SliceOf(t.schema.ModelType), 0, batchSize)
+	}
+
+	flush := func() error {
+		var err error
+
+		if t.schema != nil && batch.Len() > 0 {
+			ptr := reflect.New(batch.Type())
+			ptr.Elem().Set(batch)
+
+			err = tx.Omit(clause.Associations).Create(ptr.Interface()).Error
+			batch = batch.Slice(0, 0)
+		} else if len(rows) > 0 {
+			err = tx.Table(t.name).Create(&rows).Error
+			rows = rows[:0]
+		}
+
+		if err != nil {
+			return errorx.InternalError.Wrap(err, "the rows of the table '%s' can't be restored", t.name)
+		}
+
+		return nil
+	}
+
+	for {
+		var raw map[string]json.RawMessage
+
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return errorx.IllegalFormat.Wrap(err, "the rows of the table '%s' can't be parsed", t.name)
+		}
+
+		if t.schema == nil {
+			row := make(map[string]interface{}, len(raw))
+
+			for column, value := range raw {
+				var v interface{}
+
+				d := json.NewDecoder(bytes.NewReader(value))
+				d.UseNumber()
+
+				if err := d.Decode(&v); err != nil {
+					return errorx.IllegalFormat.Wrap(err, "the rows of the table '%s' can't be parsed", t.name)
+				}
+
+				row[column] = v
+			}
+
+			rows = append(rows, row)
+		} else {
+			v := reflect.New(t.schema.ModelType).Elem()
+
+			for _, f := range t.schema.Fields {
+				value, ok := raw[f.DBName]
+				if f.DBName == "" || !ok {
+					continue
+				}
+
+				if err := json.Unmarshal(value, f.ReflectValueOf(ctx, v).Addr().Interface()); err != nil {
+					return errorx.IllegalFormat.Wrap(err, "the column '%s' of the table '%s' can't be parsed", f.DBName, t.name)
+				}
+			}
+
+			batch = reflect.Append(batch, v)
+		}
+
+		if batch.IsValid() && batch.Len() >= batchSize || len(rows) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
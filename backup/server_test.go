@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"lincast/database"
+	"lincast/models"
+
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newEmptyDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(database.Models...); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	assert := assert2.New(t)
+
+	src := newEmptyDB(t)
+
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	assert.NoError(user.SetPassword("secret"))
+	assert.NoError(src.Create(&user).Error)
+
+	p := models.Podcast{FeedLink: "https://changelog.com/gotime/feed", Title: "Go Time"}
+	assert.NoError(src.Create(&p).Error)
+	assert.NoError(src.Exec("INSERT INTO subscriptions (podcast_id, user_id) VALUES (?, ?)", p.ID, user.ID).Error)
+
+	episodes := []models.Episode{{PodcastID: p.ID, GUID: "gt-1"}, {PodcastID: p.ID, GUID: "gt-2"}}
+	assert.NoError(src.Create(&episodes).Error)
+	assert.NoError(src.Delete(&episodes[1]).Error)
+
+	var buf bytes.Buffer
+
+	manifest, err := WriteServer(&buf, src, database.Models)
+	if !assert.NoError(err, "the server should be backed up without errors") {
+		return
+	}
+
+	assert.Equal(ServerVersion, manifest.Version)
+
+	dst := newEmptyDB(t)
+	archive := bytes.NewReader(buf.Bytes())
+
+	_, err = RestoreServer(archive, archive.Size(), dst, database.Models)
+	if !assert.NoError(err, "the archive should be restored without errors") {
+		return
+	}
+
+	var restored models.User
+	assert.NoError(dst.First(&restored).Error)
+	assert.Equal(user.ID, restored.ID, "the primary keys should be kept")
+	assert.True(restored.CheckPassword("secret"), "the password hash should be kept")
+
+	var count int64
+	dst.Unscoped().Model(&models.Episode{}).Count(&count)
+	assert.EqualValues(2, count, "the soft deleted rows should be restored too")
+
+	dst.Model(&models.Episode{}).Count(&count)
+	assert.EqualValues(1, count, "the soft deleted rows should stay deleted")
+
+	dst.Table("subscriptions").Count(&count)
+	assert.EqualValues(1, count, "the join tables should be restored")
+
+	_, err = RestoreServer(archive, archive.Size(), dst, database.Models)
+	assert.Error(err, "an archive shouldn't be restored over a database that is not empty")
+}
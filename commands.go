@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"lincast/backup"
+	"lincast/config"
+	"lincast/database"
+	"lincast/history"
+	"lincast/maintenance"
+	"lincast/models"
+	"lincast/opml"
+	"lincast/podcasts"
+	"lincast/update"
+)
+
+// handleSubcommands runs the command given as first positional argument (e.g. `lincast import-opml <file>`) and exits.
+// If there is no command, it just returns.
+func handleSubcommands(cfg *config.Config) {
+	if flag.NArg() == 0 {
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "import-opml":
+		importOPML(cfg, flag.Args()[1:])
+
+	case "import-history":
+		importHistory(cfg, flag.Args()[1:])
+
+	case "export-account":
+		exportAccount(cfg, flag.Args()[1:])
+
+	case "import-account":
+		importAccount(cfg, flag.Args()[1:])
+
+	case "backup":
+		backupServer(cfg, flag.Args()[1:])
+
+	case "restore":
+		restoreServer(cfg, flag.Args()[1:])
+
+	case "migrate":
+		migrateCmd(cfg, flag.Args()[1:])
+
+	case "add-user":
+		addUser(cfg, flag.Args()[1:])
+
+	case "purge":
+		purgeCmd(cfg, flag.Args()[1:])
+
+	case "config":
+		configCmd(cfg, flag.Args()[1:])
+
+	default:
+		{
+			fmt.Printf("Unknown command '%s'\n", flag.Arg(0))
+			os.Exit(1)
+		}
+	}
+
+	os.Exit(0)
+}
+
+// importOPML subscribes to all the feeds referenced by the given OPML file, printing the outcome of each one.
+func importOPML(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: lincast import-opml <file>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println("Error when trying to open the OPML file:", err.Error())
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	feeds, err := opml.Parse(f)
+	if err != nil {
+		fmt.Println("Error when trying to parse the OPML file:", err.Error())
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	updateQueue, err := update.NewUpdateQueue(db, cfg.Updater.Workers)
+	if err != nil {
+		fmt.Println("Error when trying to create the update queue:", err.Error())
+		os.Exit(1)
+	}
+
+	if err := updateQueue.SetJobTimeout(cfg.Updater.JobTimeout); err != nil {
+		fmt.Println("Error when trying to set the timeout of the update jobs:", err.Error())
+		os.Exit(1)
+	}
+
+	jobs := make(map[string]*update.Job)
+	var toSend []*update.Job
+
+	for _, feed := range feeds {
+		if !podcasts.IsValidURL(feed.URL) || jobs[feed.URL] != nil {
+			continue
+		}
+
+		j := update.NewSubscriptionJob(feed.URL)
+		j.Group = feed.Group
+
+		jobs[feed.URL] = j
+		toSend = append(toSend, j)
+	}
+
+	fmt.Printf("Subscribing to %d feeds...\n", len(toSend))
+
+	go func() {
+		// The jobs that can't be sent are finished with the error, so waiting for them doesn't block.
+		for _, j := range toSend {
+			_ = updateQueue.Send(j)
+		}
+	}()
+
+	summary := make(map[update.SubscriptionOutcome]int)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "OUTCOME\tGROUP\tTITLE\tURL\tERROR")
+
+	for _, feed := range feeds {
+		outcome := update.OutcomeInvalidURL
+		errMsg := ""
+
+		if j, ok := jobs[feed.URL]; ok {
+			j.Wait(context.Background())
+
+			outcome = j.Outcome()
+			if err := j.Err(); err != nil {
+				errMsg = err.Error()
+			}
+		}
+
+		summary[outcome]++
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", outcome, feed.Group, feed.Title, feed.URL, errMsg)
+	}
+
+	tw.Flush()
+
+	fmt.Println()
+	for outcome, n := range summary {
+		fmt.Printf("%s: %d\n", outcome, n)
+	}
+}
+
+// addUser creates a user with the given username, reading its password from the standard input. The user can then
+// authenticate on the sync API.
+func addUser(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: lincast add-user <username>")
+		os.Exit(1)
+	}
+
+	fmt.Print("Password: ")
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Println("Error when trying to read the password:", err.Error())
+		os.Exit(1)
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Println("The password can't be empty")
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	user := models.User{Username: args[0], Email: args[0]}
+	if err := user.SetPassword(password); err != nil {
+		fmt.Println("Error when trying to hash the password:", err.Error())
+		os.Exit(1)
+	}
+
+	if err := db.Create(&user).Error; err != nil {
+		fmt.Println("Error when trying to create the user:", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("User '%s' created\n", user.Username)
+}
+
+// importHistory imports the listening history from the export of another podcast app, printing the records that
+// don't match any episode.
+func importHistory(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("import-history", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only match the episodes, without modifying them")
+	opmlFile := fs.String("opml", "", "OPML export of the same app, used to resolve podcast titles on CSV exports")
+
+	fs.Usage = func() {
+		fmt.Println("Usage: lincast import-history [-dry-run] [-opml <file>] <csv|antennapod> <file>")
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var feeds []opml.Feed
+
+	if *opmlFile != "" {
+		f, err := os.Open(*opmlFile)
+		if err != nil {
+			fmt.Println("Error when trying to open the OPML file:", err.Error())
+			os.Exit(1)
+		}
+
+		feeds, err = opml.Parse(f)
+		f.Close()
+
+		if err != nil {
+			fmt.Println("Error when trying to parse the OPML file:", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var records []history.Record
+	var err error
+
+	switch fs.Arg(0) {
+	case "csv":
+		f, ferr := os.Open(fs.Arg(1))
+		if ferr != nil {
+			fmt.Println("Error when trying to open the CSV file:", ferr.Error())
+			os.Exit(1)
+		}
+
+		records, err = history.ParseCSV(f, feeds)
+		f.Close()
+
+	case "antennapod":
+		records, err = history.ParseAntennaPod(fs.Arg(1))
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println("Error when trying to parse the listening history:", err.Error())
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	report, err := history.Import(db, records, *dryRun)
+	if err != nil {
+		fmt.Println("Error when trying to import the listening history:", err.Error())
+		os.Exit(1)
+	}
+
+	if len(report.Unmatched) > 0 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "UNMATCHED\tFEED\tGUID\tENCLOSURE")
+		for _, rec := range report.Unmatched {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", rec.Title, rec.FeedURL, rec.GUID, rec.EnclosureURL)
+		}
+
+		tw.Flush()
+		fmt.Println()
+	}
+
+	if report.DryRun {
+		fmt.Println("Dry run, no episode has been modified")
+	}
+
+	fmt.Printf("Records: %d, matched: %d (played: %d, with position: %d), unmatched: %d\n",
+		report.Total, report.Matched, report.Played, report.Positions, len(report.Unmatched))
+}
+
+// exportAccount writes an archive with the data of the user to the given file. The format (JSON or ZIP) is chosen by
+// the extension of the file.
+func exportAccount(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: lincast export-account <file.json|file.zip>")
+		os.Exit(1)
+	}
+
+	format := backup.FormatJSON
+	if strings.HasSuffix(strings.ToLower(args[0]), ".zip") {
+		format = backup.FormatZIP
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	acc, err := backup.ExportAccount(db)
+	if err != nil {
+		fmt.Println("Error when trying to export the account:", err.Error())
+		os.Exit(1)
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		fmt.Println("Error when trying to create the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	err = backup.WriteAccount(f, acc, format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		fmt.Println("Error when trying to write the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Account exported: %d subscriptions, %d episodes in the history, %d in the queue\n",
+		len(acc.Subscriptions), len(acc.History), len(acc.Queue))
+}
+
+// importAccount restores an account archive, subscribing first to the podcasts that are not on the database.
+func importAccount(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: lincast import-account <file>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println("Error when trying to open the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	acc, err := backup.ReadAccount(f)
+	f.Close()
+
+	if err != nil {
+		fmt.Println("Error when trying to read the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	missing, err := backup.MissingFeeds(db, acc)
+	if err != nil {
+		fmt.Println("Error when trying to check the subscriptions:", err.Error())
+		os.Exit(1)
+	}
+
+	if len(missing) > 0 {
+		updateQueue, err := update.NewUpdateQueue(db, cfg.Updater.Workers)
+		if err != nil {
+			fmt.Println("Error when trying to create the update queue:", err.Error())
+			os.Exit(1)
+		}
+
+		if err := updateQueue.SetJobTimeout(cfg.Updater.JobTimeout); err != nil {
+			fmt.Println("Error when trying to set the timeout of the update jobs:", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("Subscribing to %d podcasts...\n", len(missing))
+
+		jobs := make([]*update.Job, 0, len(missing))
+		for _, s := range missing {
+			j := update.NewSubscriptionJob(s.FeedURL)
+			j.Group = s.Group
+
+			jobs = append(jobs, j)
+		}
+
+		go func() {
+			// The jobs that can't be sent are finished with the error, so waiting for them doesn't block.
+			for _, j := range jobs {
+				_ = updateQueue.Send(j)
+			}
+		}()
+
+		update.NewBatch(jobs).Wait(context.Background())
+	}
+
+	report, err := backup.RestoreAccount(db, acc)
+	if err != nil {
+		fmt.Println("Error when trying to restore the account:", err.Error())
+		os.Exit(1)
+	}
+
+	for _, ref := range report.Unmatched {
+		fmt.Printf("Episode not found: %s (%s)\n", ref.Title, ref.FeedURL)
+	}
+
+	fmt.Printf("Account restored: %d subscriptions (%d missing), %d episodes in the history, %d in the queue\n",
+		report.Subscriptions, len(report.Missing), report.History, report.Queue)
+}
+
+// backupServer writes all the data of the database to the given file, as a ZIP archive that can be restored on any
+// supported database engine.
+func backupServer(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: lincast backup <file.zip>")
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		fmt.Println("Error when trying to create the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	manifest, err := backup.WriteServer(f, db, database.Models)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(args[0])
+
+		fmt.Println("Error when trying to back up the database:", err.Error())
+		os.Exit(1)
+	}
+
+	for _, t := range manifest.Tables {
+		fmt.Printf("%s: %d rows\n", t.Name, t.Rows)
+	}
+
+	fmt.Println("Backup written to", args[0])
+}
+
+// restoreServer restores an archive created by `lincast backup` into the database, that should be empty.
+func restoreServer(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: lincast restore <file.zip>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println("Error when trying to open the archive:", err.Error())
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Println("Error when trying to open the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	manifest, err := backup.RestoreServer(f, info.Size(), db, database.Models)
+	if err != nil {
+		fmt.Println("Error when trying to restore the archive:", err.Error())
+		os.Exit(1)
+	}
+
+	for _, t := range manifest.Tables {
+		fmt.Printf("%s: %d rows\n", t.Name, t.Rows)
+	}
+
+	fmt.Printf("Backup from %s restored\n", manifest.CreatedAt.Format(time.RFC3339))
+}
+
+// purgeCmd purges the database once, following the policy of the maintenance section of the configuration. Note that
+// the server purges the database periodically.
+func purgeCmd(cfg *config.Config, args []string) {
+	if len(args) != 0 {
+		fmt.Println("Usage: lincast purge")
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to initialize the database:", err.Error())
+		os.Exit(1)
+	}
+
+	purger, err := maintenance.NewPurger(db, purgePolicy(cfg.Maintenance))
+	if err != nil {
+		fmt.Println("Error when trying to create the purger:", err.Error())
+		os.Exit(1)
+	}
+
+	report, err := purger.Purge(time.Now())
+	if err != nil {
+		fmt.Println("Error when trying to purge the database:", err.Error())
+		os.Exit(1)
+	}
+
+	tables := make([]string, 0, len(report.Purged))
+	for table := range report.Purged {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		fmt.Printf("%s: %d deleted rows purged\n", table, report.Purged[table])
+	}
+
+	fmt.Printf("%d episodes of podcasts not subscribed pruned\n", report.PrunedEpisodes)
+}
+
+// configCmd prints (`print`) the effective configuration, after applying the configuration file, the environment and
+// the flags. The secrets are masked.
+func configCmd(cfg *config.Config, args []string) {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Println("Usage: lincast config print")
+		os.Exit(1)
+	}
+
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Println("Error when trying to print the configuration:", err.Error())
+		os.Exit(1)
+	}
+}
+
+// migrateCmd applies (`up [version]`) or reverts (`down [steps]`) the migrations of the database, or lists them with
+// their status (`status`). Note that the server applies the pending migrations on startup.
+func migrateCmd(cfg *config.Config, args []string) {
+	usage := func() {
+		fmt.Println("Usage: lincast migrate up [version] | down [steps] | status")
+		os.Exit(1)
+	}
+
+	if len(args) == 0 || len(args) > 2 {
+		usage()
+	}
+
+	n := 0
+	if len(args) == 2 {
+		var err error
+
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			usage()
+		}
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		fmt.Println("Error when trying to open the database:", err.Error())
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "up":
+		err = database.MigrateUp(db, n)
+
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+
+		err = database.MigrateDown(db, n)
+
+	case "status":
+		if len(args) != 1 {
+			usage()
+		}
+
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Println("Error when trying to migrate the database:", err.Error())
+		os.Exit(1)
+	}
+
+	status, err := database.Status(db)
+	if err != nil {
+		fmt.Println("Error when trying to get the status of the migrations:", err.Error())
+		os.Exit(1)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tDESCRIPTION\tAPPLIED AT")
+
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Description, appliedAt)
+	}
+
+	tw.Flush()
+}
+
+// checkTimeout is how long checkConfig waits for the database to answer.
+const checkTimeout = time.Second * 10
+
+// checkConfig checks that the database of the configuration (already validated) can be reached, printing the outcome,
+// and exits.
+func checkConfig(cfg *config.Config) {
+	fmt.Println("Configuration: OK")
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		fmt.Println("Database: error when trying to open the database:", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	if err := database.Ping(ctx, db); err != nil {
+		fmt.Println("Database:", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Database: OK (%s)\n", cfg.Database.Driver)
+	os.Exit(0)
+}
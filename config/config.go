@@ -0,0 +1,219 @@
+// Package config reads the configuration of LinCast. Each setting can be given on the configuration file (YAML), on an
+// environment variable or on a flag, in increasing order of precedence.
+package config
+
+import (
+	"runtime"
+	"strings"
+	"time"
+
+	"lincast/database"
+	"lincast/tracing"
+	"lincast/update"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of LinCast. The fields of each section are tagged with their key on the configuration
+// file (`yaml`), the environment variable that sets them (`env`), the flag that sets them (`flag`, along with its
+// `usage`), whether they should be masked when printed (`secret`) and whether they can be changed without restarting
+// LinCast (`reload`).
+type Config struct {
+	Server      Server          `yaml:"server"`
+	Database    database.Config `yaml:"database"`
+	Updater     Updater         `yaml:"updater"`
+	Logging     Logging         `yaml:"logging"`
+	Auth        Auth            `yaml:"auth"`
+	Maintenance Maintenance     `yaml:"maintenance"`
+	Limits      Limits          `yaml:"limits"`
+	Tracing     Tracing         `yaml:"tracing"`
+}
+
+// Server is the configuration of the HTTP server.
+type Server struct {
+	Port        uint   `yaml:"port" env:"LINCAST_PORT" flag:"port" usage:"Server's listening port"`
+	Local       bool   `yaml:"local" env:"LINCAST_LOCAL" flag:"local" usage:"If the server should only listen to local requests (localhost)"`
+	LogRequests bool   `yaml:"logRequests" env:"LINCAST_LOG_REQUESTS" flag:"log" usage:"Whether server should log information or not"`
+	DevMode     bool   `yaml:"devMode" env:"LINCAST_DEV_MODE" flag:"dev-mode" usage:"Enable API's dev mode"`
+	Metrics     bool   `yaml:"metrics" env:"LINCAST_METRICS" flag:"metrics" usage:"Expose the Prometheus metrics on /metrics, without authentication"`
+	CORSOrigins string `yaml:"corsOrigins" env:"LINCAST_CORS_ORIGINS" flag:"cors-origins" usage:"Comma separated origins allowed to make cross-origin requests ('*' allows any)" reload:"true"`
+}
+
+// Updater is the configuration of the updates of the feeds.
+type Updater struct {
+	Frequency  time.Duration `yaml:"frequency" env:"LINCAST_UPDATE_FREQ" flag:"update-freq" usage:"Server feed update frequency" reload:"true"`
+	Schedule   string        `yaml:"schedule" env:"LINCAST_UPDATE_SCHEDULE" flag:"update-schedule" usage:"Cron expressions (separated by ';') that define when the feeds should be updated. Overrides -update-freq" reload:"true"`
+	QuietHours string        `yaml:"quietHours" env:"LINCAST_UPDATE_QUIET_HOURS" flag:"update-quiet-hours" usage:"Comma separated windows (e.g. '01:00-06:00') during which the feeds should not be updated" reload:"true"`
+	Workers    int           `yaml:"workers" env:"LINCAST_UPDATE_WORKERS" flag:"update-workers" usage:"Number of feeds that are updated at the same time (workers of the update queue)" reload:"true"`
+	JobTimeout time.Duration `yaml:"jobTimeout" env:"LINCAST_UPDATE_JOB_TIMEOUT" flag:"update-job-timeout" usage:"Maximum time that the update of a feed can take (0 for no limit)" reload:"true"`
+}
+
+// Logging is the configuration of the logs.
+type Logging struct {
+	Level    string `yaml:"level" env:"LINCAST_LOG_LEVEL" flag:"log-level" usage:"Minimum level of the logs (trace, debug, info, warning, error, fatal or panic)" reload:"true"`
+	ToFile   bool   `yaml:"toFile" env:"LINCAST_LOG_TO_FILE" flag:"log-to-file" usage:"Log to a file"`
+	Filename string `yaml:"filename" env:"LINCAST_LOGS_FILENAME" flag:"logs-filename" usage:"Logs filename"`
+}
+
+// Auth is the configuration of the authentication of the users.
+type Auth struct {
+	Realm string `yaml:"realm" env:"LINCAST_AUTH_REALM" flag:"auth-realm" usage:"Realm sent to the clients when asking for credentials"`
+}
+
+// Maintenance is the configuration of the purges of the database (see maintenance.Purger).
+type Maintenance struct {
+	PurgeInterval time.Duration `yaml:"purgeInterval" env:"LINCAST_PURGE_INTERVAL" flag:"purge-interval" usage:"Frequency with which the database is purged"`
+	Retention     time.Duration `yaml:"retention" env:"LINCAST_PURGE_RETENTION" flag:"purge-retention" usage:"How long the deleted rows are kept before being purged (0 keeps them forever)"`
+	KeepEpisodes  int           `yaml:"keepEpisodes" env:"LINCAST_PURGE_KEEP_EPISODES" flag:"purge-keep-episodes" usage:"Number of episodes kept of each podcast that is not subscribed (0 keeps all of them)"`
+}
+
+// Limits is the configuration of the limits of the requests to the API.
+type Limits struct {
+	RefreshCooldown    time.Duration `yaml:"refreshCooldown" env:"LINCAST_REFRESH_COOLDOWN" flag:"refresh-cooldown" usage:"Minimum time between two manual refreshes of the same podcast" reload:"true"`
+	RefreshAllCooldown time.Duration `yaml:"refreshAllCooldown" env:"LINCAST_REFRESH_ALL_COOLDOWN" flag:"refresh-all-cooldown" usage:"Minimum time between two manual refreshes of the entire library" reload:"true"`
+}
+
+// Tracing is the configuration of the export of the traces (see tracing.Setup). The environment variables of the
+// endpoint and the service name are the standard ones of OpenTelemetry.
+type Tracing struct {
+	Exporter    string `yaml:"exporter" env:"LINCAST_TRACING_EXPORTER" flag:"tracing-exporter" usage:"Where the traces are exported ('otlp' or 'stdout'). Tracing is disabled if it's empty"`
+	Endpoint    string `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"tracing-endpoint" usage:"Base URL of the OTLP/HTTP collector to which the traces are exported"`
+	ServiceName string `yaml:"serviceName" env:"OTEL_SERVICE_NAME" flag:"tracing-service-name" usage:"Name of the service on the exported traces"`
+}
+
+// Defaults returns the configuration used when a setting is not given.
+func Defaults() Config {
+	return Config{
+		Server: Server{
+			Port:        8080,
+			Local:       true,
+			LogRequests: true,
+		},
+		Database: database.Config{
+			Driver: database.DriverMySQL,
+		},
+		Updater: Updater{
+			Frequency:  time.Minute * 30,
+			Workers:    defaultWorkers(),
+			JobTimeout: update.DefaultJobTimeout,
+		},
+		Logging: Logging{
+			Level:    log.InfoLevel.String(),
+			Filename: "lincast.log",
+		},
+		Auth: Auth{
+			Realm: "LinCast",
+		},
+		Maintenance: Maintenance{
+			PurgeInterval: time.Hour * 24,
+			Retention:     time.Hour * 24 * 30,
+			KeepEpisodes:  100,
+		},
+		Limits: Limits{
+			RefreshCooldown:    time.Minute,
+			RefreshAllCooldown: time.Minute * 5,
+		},
+		Tracing: Tracing{
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "lincast",
+		},
+	}
+}
+
+// defaultWorkers returns the default number of workers that update the feeds. Since the updates are mostly waiting for
+// the network, there are more workers than CPUs.
+func defaultWorkers() int {
+	return min(runtime.NumCPU()*4, update.MaxWorkers)
+}
+
+// Validate checks that all the settings have valid values. All the problems found are reported on the same error
+// (see Problems).
+// Possible errors:
+//   - errorx.IllegalArgument: if any setting is not valid.
+func (c *Config) Validate() error {
+	var p problems
+
+	c.validate(&p)
+
+	return p.err()
+}
+
+// validate adds to `p` the problems found on the values of the settings.
+func (c *Config) validate(p *problems) {
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		p.add("server.port should be between 1 and 65535 (got %d)", c.Server.Port)
+	}
+
+	if err := c.Database.Validate(); err != nil {
+		p.add("database: %s", describe(err))
+	}
+
+	if c.Updater.Frequency <= 0 {
+		p.add("updater.frequency should be positive (got %s)", c.Updater.Frequency)
+	}
+
+	if c.Updater.Schedule != "" {
+		if _, err := update.ParseSchedule(c.Updater.Schedule); err != nil {
+			p.add("updater.schedule: %s", describe(err))
+		}
+	}
+
+	if _, err := update.ParseQuietHours(c.Updater.QuietHours); err != nil {
+		p.add("updater.quietHours: %s", describe(err))
+	}
+
+	if c.Updater.Workers < 1 || c.Updater.Workers > update.MaxWorkers {
+		p.add("updater.workers should be between 1 and %d (got %d)", update.MaxWorkers, c.Updater.Workers)
+	}
+
+	if c.Updater.JobTimeout < 0 {
+		p.add("updater.jobTimeout can't be negative (got %s)", c.Updater.JobTimeout)
+	}
+
+	if _, err := log.ParseLevel(c.Logging.Level); err != nil {
+		p.add("logging.level '%s' is not a valid level", c.Logging.Level)
+	}
+
+	if c.Logging.ToFile && c.Logging.Filename == "" {
+		p.add("logging.filename is required when logging to a file")
+	}
+
+	if c.Auth.Realm == "" {
+		p.add("auth.realm can't be empty")
+	}
+
+	if c.Maintenance.PurgeInterval <= 0 {
+		p.add("maintenance.purgeInterval should be positive (got %s)", c.Maintenance.PurgeInterval)
+	}
+
+	if c.Maintenance.Retention < 0 {
+		p.add("maintenance.retention can't be negative (got %s)", c.Maintenance.Retention)
+	}
+
+	if c.Maintenance.KeepEpisodes < 0 {
+		p.add("maintenance.keepEpisodes can't be negative (got %d)", c.Maintenance.KeepEpisodes)
+	}
+
+	if c.Limits.RefreshCooldown < 0 {
+		p.add("limits.refreshCooldown can't be negative (got %s)", c.Limits.RefreshCooldown)
+	}
+
+	if c.Limits.RefreshAllCooldown < 0 {
+		p.add("limits.refreshAllCooldown can't be negative (got %s)", c.Limits.RefreshAllCooldown)
+	}
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if !strings.HasPrefix(c.Tracing.Endpoint, "http://") && !strings.HasPrefix(c.Tracing.Endpoint, "https://") {
+			p.add("tracing.endpoint should be an HTTP(S) URL (got '%s')", c.Tracing.Endpoint)
+		}
+	default:
+		p.add("tracing.exporter should be '%s', '%s' or empty (got '%s')", tracing.ExporterOTLP, tracing.ExporterStdout,
+			c.Tracing.Exporter)
+	}
+
+	if c.Tracing.Exporter != tracing.ExporterNone && c.Tracing.ServiceName == "" {
+		p.add("tracing.serviceName can't be empty when tracing is enabled")
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lincast/database"
+
+	"github.com/joomcode/errorx"
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func newFlagSet(t *testing.T, args ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet("lincast", flag.ContinueOnError)
+	RegisterFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return fs
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	assert := assert2.New(t)
+
+	path := writeFile(t, "lincast.yaml", `
+server:
+  port: 9000
+  local: false
+database:
+  driver: sqlite
+  path: /var/lib/lincast/lincast.db
+updater:
+  frequency: 1h
+  schedule: "0 * * * *"
+logging:
+  level: debug
+`)
+
+	t.Setenv(FileEnv, path)
+	t.Setenv("LINCAST_PORT", "9001")
+	t.Setenv("LINCAST_UPDATE_FREQ", "2h")
+	t.Setenv("LINCAST_LOG_LEVEL", "")
+
+	cfg, err := Load(newFlagSet(t, "-port", "9002"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.EqualValues(9002, cfg.Server.Port, "the flags should take precedence over the environment")
+	assert.Equal(time.Hour*2, cfg.Updater.Frequency, "the environment should take precedence over the file")
+	assert.False(cfg.Server.Local, "the settings of the file should be applied")
+	assert.Equal("0 * * * *", cfg.Updater.Schedule)
+	assert.Equal("debug", cfg.Logging.Level, "the empty variables should be ignored")
+	assert.Equal(database.DriverSQLite, cfg.Database.Driver)
+	assert.True(cfg.Server.LogRequests, "the settings that are not given should keep their default")
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	assert := assert2.New(t)
+
+	t.Setenv("DB_DRIVER", database.DriverPostgres)
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_PORT", "5432")
+	t.Setenv("DB_NAME", "lincast")
+	t.Setenv("DB_PASSWORD_FILE", writeFile(t, "db_password", "s3cret\n"))
+
+	cfg, err := Load(newFlagSet(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal("s3cret", cfg.Database.Password, "the secret should be read from the file, without the newline")
+
+	t.Setenv("DB_PASSWORD", "other")
+
+	_, err = Load(newFlagSet(t))
+	assert.Error(err, "a variable and its file variable can't be set at the same time")
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{"unknown setting", "server:\n  prot: 80\n", nil},
+		{"invalid duration", "updater:\n  frequency: often\n", nil},
+		{"invalid schedule", "updater:\n  schedule: every day\n", nil},
+		{"port out of range", "server:\n  port: 70000\n", nil},
+		{"missing database path", "database:\n  path: ''\n", nil},
+		{"invalid variable", "", map[string]string{"LINCAST_LOCAL": "maybe"}},
+		{"unsupported driver", "", map[string]string{"DB_DRIVER": "oracle"}},
+		{"unknown tracing exporter", "tracing:\n  exporter: jaeger\n", nil},
+		{"invalid tracing endpoint", "", map[string]string{"LINCAST_TRACING_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_ENDPOINT": "localhost:4318"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_DRIVER", database.DriverSQLite)
+			t.Setenv("DB_PATH", "")
+			t.Setenv(FileEnv, writeFile(t, "lincast.yaml", "database:\n  path: lincast.db\n"+tt.file))
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := Load(newFlagSet(t))
+			if assert2.Error(t, err) {
+				assert2.True(t, errorx.IsOfType(err, errorx.IllegalArgument), "the error should be of type IllegalArgument: %v", err)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	assert := assert2.New(t)
+
+	cfg := Defaults()
+	cfg.Database.Password = "s3cret"
+
+	var buf bytes.Buffer
+	if !assert.NoError(cfg.Print(&buf)) {
+		return
+	}
+
+	assert.NotContains(buf.String(), "s3cret", "the secrets should be masked")
+	assert.Contains(buf.String(), "frequency: 30m0s")
+
+	// The printed configuration should be a valid configuration file.
+	printed := Defaults()
+	printed.Updater.Frequency = 0
+
+	var p problems
+
+	if assert.NoError(printed.applyFile("printed.yaml", buf.Bytes(), &p)) && assert.Empty(p) {
+		assert.Equal(time.Minute*30, printed.Updater.Frequency)
+	}
+}
+
+func TestLoadAggregatedProblems(t *testing.T) {
+	assert := assert2.New(t)
+
+	t.Setenv("DB_DRIVER", database.DriverMySQL)
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_PORT", "")
+	t.Setenv("DB_NAME", "")
+	t.Setenv("LINCAST_UPDATE_FREQ", "often")
+	t.Setenv(FileEnv, writeFile(t, "lincast.yaml", "server:\n  prot: 80\nlogging:\n  level: loud\n"))
+
+	_, err := Load(newFlagSet(t))
+	if !assert.Error(err) {
+		return
+	}
+
+	problems := Problems(err)
+
+	assert.Len(problems, 4, "all the problems should be reported: %v", problems)
+	assert.Contains(err.Error(), "unknown setting 'server.prot'")
+	assert.Contains(err.Error(), "LINCAST_UPDATE_FREQ")
+	assert.Contains(err.Error(), "logging.level 'loud'")
+	assert.Contains(err.Error(), "the host of the database is required; the port of the database")
+}
+
+func TestDiff(t *testing.T) {
+	assert := assert2.New(t)
+
+	old := Defaults()
+	cfg := Defaults()
+
+	assert.Empty(cfg.Diff(&old), "there should be no changes between equal configurations")
+
+	cfg.Logging.Level = "debug"
+	cfg.Server.Port = 9000
+	cfg.Database.Password = "s3cret"
+
+	changes := cfg.Diff(&old)
+	if !assert.Len(changes, 3) {
+		return
+	}
+
+	assert.Equal(Change{Key: "server.port", Old: "8080", New: "9000", Reload: false}, changes[0])
+	assert.Equal(Change{Key: "database.password", Old: "", New: secretMask, Reload: false}, changes[1],
+		"the secrets should be masked")
+	assert.Equal(Change{Key: "logging.level", Old: "info", New: "debug", Reload: true}, changes[2])
+}
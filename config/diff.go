@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Change is a setting whose value differs between two configurations.
+type Change struct {
+	Key    string // Key on the configuration file, as "section.field"
+	Old    string // Masked if the setting is secret
+	New    string // Masked if the setting is secret
+	Reload bool   // Whether the change can be applied without restarting LinCast
+}
+
+// Diff returns the settings whose value on `c` differs from the one on `old`, in order of declaration.
+func (c *Config) Diff(old *Config) []Change {
+	var changes []Change
+
+	oldSettings := old.settings()
+
+	for i, s := range c.settings() {
+		o := oldSettings[i]
+
+		if s.value.Interface() == o.value.Interface() {
+			continue
+		}
+
+		changes = append(changes, Change{
+			Key:    s.key,
+			Old:    o.display(),
+			New:    s.display(),
+			Reload: s.reload,
+		})
+	}
+
+	return changes
+}
+
+// display returns the value of the setting as it should be shown on the logs, masked if the setting is secret.
+func (s setting) display() string {
+	switch v := s.value.Interface().(type) {
+	case time.Duration:
+		return v.String()
+	case string:
+		if s.secret && v != "" {
+			return secretMask
+		}
+
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
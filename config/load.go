@@ -0,0 +1,304 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the flag and the environment variable that give the path of the configuration file.
+const (
+	FileFlag = "config"
+	FileEnv  = "LINCAST_CONFIG"
+)
+
+// fileSuffix is the suffix of the environment variables that give the path of a file that contains the value of a
+// setting, instead of the value itself (e.g. DB_PASSWORD_FILE=/run/secrets/db_password).
+const fileSuffix = "_FILE"
+
+// setting is a field of a section of Config.
+type setting struct {
+	key    string // Key on the configuration file, as "section.field"
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	reload bool // Whether the setting can be changed without restarting LinCast
+	value  reflect.Value
+}
+
+// settings returns all the settings of the configuration, in order of declaration.
+func (c *Config) settings() []setting {
+	var res []setting
+
+	v := reflect.ValueOf(c).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		section := v.Type().Field(i).Tag.Get("yaml")
+		sv := v.Field(i)
+
+		for j := 0; j < sv.NumField(); j++ {
+			f := sv.Type().Field(j)
+
+			res = append(res, setting{
+				key:    section + "." + f.Tag.Get("yaml"),
+				env:    f.Tag.Get("env"),
+				flag:   f.Tag.Get("flag"),
+				usage:  f.Tag.Get("usage"),
+				secret: f.Tag.Get("secret") == "true",
+				reload: f.Tag.Get("reload") == "true",
+				value:  sv.Field(j),
+			})
+		}
+	}
+
+	return res
+}
+
+// RegisterFlags defines on `fs` the flag of each setting that can be given as a flag, and the flag of the path of the
+// configuration file. The defaults of the flags are the defaults of the settings.
+func RegisterFlags(fs *flag.FlagSet) {
+	defaults := Defaults()
+
+	fs.String(FileFlag, "", "Path of the configuration file (YAML). Can also be given with "+FileEnv)
+
+	for _, s := range defaults.settings() {
+		if s.flag == "" {
+			continue
+		}
+
+		switch v := s.value.Interface().(type) {
+		case time.Duration:
+			fs.Duration(s.flag, v, s.usage)
+		case string:
+			fs.String(s.flag, v, s.usage)
+		case bool:
+			fs.Bool(s.flag, v, s.usage)
+		case int:
+			fs.Int(s.flag, v, s.usage)
+		case uint:
+			fs.Uint(s.flag, v, s.usage)
+		default:
+			panic(fmt.Sprintf("the setting %s has an unsupported type %T", s.key, v))
+		}
+	}
+}
+
+// Load returns the configuration given by the configuration file, the environment and the flags of `fs` that have been
+// set (in increasing order of precedence), over the defaults. The path of the configuration file is given by the flag
+// FileFlag or the environment variable FileEnv; without them, no file is read. The configuration is validated, and all
+// the problems found (on the values given and on the resulting configuration) are reported together (see Problems).
+// Possible errors:
+//   - errorx.IllegalArgument: if the configuration file can't be read, or if any setting is unknown or not valid.
+//   - errorx.IllegalFormat: if the configuration file is not valid YAML.
+func Load(fs *flag.FlagSet) (*Config, error) {
+	cfg := Defaults()
+
+	var p problems
+
+	path := os.Getenv(FileEnv)
+	if f := fs.Lookup(FileFlag); f != nil && f.Value.String() != "" {
+		path = f.Value.String()
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errorx.IllegalArgument.Wrap(err, "the configuration file can't be read")
+		}
+
+		if err := cfg.applyFile(path, data, &p); err != nil {
+			return nil, errorx.Decorate(err, "invalid configuration file '%s'", path)
+		}
+	}
+
+	cfg.applyEnv(os.LookupEnv, &p)
+	cfg.applyFlags(fs, &p)
+	cfg.validate(&p)
+
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// applyFile sets the settings given on the YAML document `data` (read from the file `name`), which should contain a
+// mapping for each section. The problems with the settings are added to `p`.
+// Possible errors:
+//   - errorx.IllegalFormat: if the document is not valid YAML or it doesn't have the expected structure.
+func (c *Config) applyFile(name string, data []byte, p *problems) error {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errorx.IllegalFormat.Wrap(err, "the YAML can't be parsed")
+	}
+
+	// An empty document sets nothing.
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	settings := make(map[string]setting)
+	for _, s := range c.settings() {
+		settings[s.key] = s
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return errorx.IllegalFormat.New("line %d: the document should be a mapping of sections", root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		sectionKey, section := root.Content[i], root.Content[i+1]
+
+		if section.Kind != yaml.MappingNode {
+			return errorx.IllegalFormat.New("line %d: the section '%s' should be a mapping", section.Line, sectionKey.Value)
+		}
+
+		for j := 0; j+1 < len(section.Content); j += 2 {
+			key, value := section.Content[j], section.Content[j+1]
+			id := sectionKey.Value + "." + key.Value
+
+			s, ok := settings[id]
+			if !ok {
+				p.add("%s:%d: unknown setting '%s'", name, key.Line, id)
+
+				continue
+			}
+
+			if value.Kind != yaml.ScalarNode {
+				p.add("%s:%d: %s should be a scalar", name, value.Line, id)
+
+				continue
+			}
+
+			if err := set(s.value, value.Value); err != nil {
+				p.add("%s:%d: %s: %s", name, value.Line, id, describe(err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyEnv sets the settings given on the environment, looked up with `lookup`. If the variable of a setting with the
+// suffix fileSuffix is set, the value is read from the file that it references. Empty variables are ignored, like the
+// ones left blank on the .env file. The problems with the variables are added to `p`.
+func (c *Config) applyEnv(lookup func(string) (string, bool), p *problems) {
+	for _, s := range c.settings() {
+		if s.env == "" {
+			continue
+		}
+
+		value, ok := lookup(s.env)
+
+		if path, fromFile := lookup(s.env + fileSuffix); fromFile {
+			if ok {
+				p.add("only one of %s and %s can be set", s.env, s.env+fileSuffix)
+
+				continue
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				p.add("%s: the file can't be read: %s", s.env+fileSuffix, err.Error())
+
+				continue
+			}
+
+			// Files usually end with a newline, which is not part of the value.
+			value, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+
+		if !ok || value == "" {
+			continue
+		}
+
+		if err := set(s.value, value); err != nil {
+			p.add("%s: %s", s.env, describe(err))
+		}
+	}
+}
+
+// applyFlags sets the settings given on the flags of `fs` that have been set. The problems with the flags are added to
+// `p`.
+func (c *Config) applyFlags(fs *flag.FlagSet, p *problems) {
+	settings := make(map[string]setting)
+	for _, s := range c.settings() {
+		if s.flag != "" {
+			settings[s.flag] = s
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := settings[f.Name]
+		if !ok {
+			return
+		}
+
+		if err := set(s.value, f.Value.String()); err != nil {
+			p.add("-%s: %s", f.Name, describe(err))
+		}
+	})
+}
+
+// set parses `str` according to the type of the setting and stores it on `v`.
+// Possible errors:
+//   - errorx.IllegalArgument: if `str` can't be parsed as the type of the setting.
+func set(v reflect.Value, str string) error {
+	str = strings.TrimSpace(str)
+
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return errorx.IllegalArgument.New("'%s' is not a valid duration (e.g. '30m' or '1h30m')", str)
+		}
+
+		v.SetInt(int64(d))
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return errorx.IllegalArgument.New("'%s' is not a valid boolean", str)
+		}
+
+		v.SetBool(b)
+
+	case reflect.Int:
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return errorx.IllegalArgument.New("'%s' is not a valid integer", str)
+		}
+
+		v.SetInt(int64(n))
+
+	case reflect.Uint:
+		n, err := strconv.ParseUint(str, 10, 0)
+		if err != nil {
+			return errorx.IllegalArgument.New("'%s' is not a valid positive integer", str)
+		}
+
+		v.SetUint(n)
+
+	default:
+		return errorx.IllegalArgument.New("settings of type %s are not supported", v.Type())
+	}
+
+	return nil
+}
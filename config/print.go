@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"gopkg.in/yaml.v3"
+)
+
+// secretMask replaces the value of the secret settings when the configuration is printed.
+const secretMask = "********"
+
+// Print writes the configuration to `w` as a YAML document that can be used as configuration file. The values of the
+// secret settings are masked.
+// Possible errors:
+//   - errorx.InternalError: if the document can't be written.
+func (c *Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	for _, s := range c.settings() {
+		sectionKey, key, _ := strings.Cut(s.key, ".")
+
+		section, ok := sections[sectionKey]
+		if !ok {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			sections[sectionKey] = section
+
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: sectionKey}, section)
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode}
+
+		switch v := s.value.Interface().(type) {
+		case time.Duration:
+			value.SetString(v.String())
+		case string:
+			if s.secret && v != "" {
+				v = secretMask
+			}
+
+			value.SetString(v)
+		default:
+			value.Value = fmt.Sprint(v)
+		}
+
+		section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(root); err != nil {
+		return errorx.InternalError.Wrap(err, "the configuration can't be written")
+	}
+
+	if err := enc.Close(); err != nil {
+		return errorx.InternalError.Wrap(err, "the configuration can't be written")
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joomcode/errorx"
+)
+
+// problemsProperty holds the list of problems on the errors returned by Load and Config.Validate.
+var problemsProperty = errorx.RegisterProperty("problems")
+
+// problems collects the problems found on the configuration, so all of them are reported together instead of one
+// per run.
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// err returns an errorx.IllegalArgument error that lists all the problems, or nil if there are none.
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	return errorx.IllegalArgument.New("the configuration is not valid:\n  - %s", strings.Join(p, "\n  - ")).
+		WithProperty(problemsProperty, []string(p))
+}
+
+// Problems returns the problems reported by an error returned by Load or Config.Validate. Other errors are returned as
+// a single problem.
+func Problems(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	if v, ok := errorx.ExtractProperty(err, problemsProperty); ok {
+		if list, ok := v.([]string); ok {
+			return list
+		}
+	}
+
+	return []string{describe(err)}
+}
+
+// describe returns the messages of the error and its causes, without the names of their errorx types.
+func describe(err error) string {
+	var msgs []string
+
+	for err != nil {
+		e := errorx.Cast(err)
+		if e == nil {
+			msgs = append(msgs, err.Error())
+
+			break
+		}
+
+		if m := e.Message(); m != "" {
+			msgs = append(msgs, m)
+		}
+
+		err = e.Cause()
+	}
+
+	return strings.Join(msgs, ": ")
+}
@@ -0,0 +1,161 @@
+// Package baseline contains copies of the models as they were when the versioned migrations were introduced, which
+// define the initial schema (the migration 1). They must not be modified, so that schema doesn't change along with the
+// models: the later changes are applied by new migrations.
+package baseline
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Models are the models of the initial schema, sorted so every model comes after the ones it references.
+var Models = []interface{}{
+	&User{},
+	&Podcast{},
+	&Episode{},
+	&PlaybackInfo{},
+	&QueueEpisode{},
+	&EpisodeProgress{},
+	&Device{},
+	&SubscriptionChange{},
+	&EpisodeAction{},
+}
+
+type User struct {
+	ID              uuid.UUID `gorm:"type:char(36);primary_key"`
+	Username        string    `gorm:"unique"`
+	PasswordHash    string
+	PasswordSalt    string
+	Email           string `gorm:"unique"`
+	Name            string
+	PlayerID        uuid.UUID
+	Player          PlaybackInfo
+	Queue           []QueueEpisode
+	EpisodeProgress []EpisodeProgress
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+	DeletedAt       time.Time `gorm:"autoDeleteTime"`
+}
+
+type Podcast struct {
+	AuthorName     string
+	AuthorEmail    string
+	Title          string
+	Description    string
+	Categories     string
+	ImageURL       string
+	ImageTitle     string
+	Link           string
+	FeedLink       string `gorm:"unique"`
+	FeedType       string
+	FeedVersion    string
+	Language       string
+	Updated        time.Time
+	LastCheck      time.Time
+	Added          time.Time
+	Subscribed     bool
+	UpdateSchedule string
+	QuietHours     string
+	Group          string `gorm:"column:group_name"`
+	Episodes       []Episode
+	AddedBy        User `gorm:"foreignKey:AddedByID"`
+	AddedByID      uuid.UUID
+	Subscriptions  []*User `gorm:"many2many:subscriptions;"`
+
+	gorm.Model
+}
+
+type Episode struct {
+	PodcastID       uint
+	Title           string
+	Description     string
+	Link            string
+	AuthorName      string
+	GUID            string
+	ImageURL        string
+	ImageTitle      string
+	Categories      string
+	EnclosureURL    string
+	EnclosureLength string
+	EnclosureType   string
+	Season          string
+	Published       time.Time
+	Updated         time.Time
+	Played          bool
+	CurrentProgress time.Duration
+
+	QueuesAddedTo   []QueueEpisode
+	BeingPlayedOn   []PlaybackInfo
+	EpisodeProgress []EpisodeProgress
+
+	gorm.Model
+}
+
+type PlaybackInfo struct {
+	ID        uuid.UUID `gorm:"type:char(36);primarykey"`
+	EpisodeID uint
+	Episode   Episode   `gorm:"foreignKey:EpisodeID"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	DeletedAt time.Time `gorm:"autoDeleteTime"`
+}
+
+type QueueEpisode struct {
+	EpisodeID uint
+	Episode   Episode `gorm:"foreignKey:EpisodeID"`
+	Position  uint
+	User      User `gorm:"foreignKey:UserID"`
+	UserID    uuid.UUID
+
+	gorm.Model
+}
+
+type EpisodeProgress struct {
+	EpisodeID uint
+	Episode   Episode `gorm:"foreignKey:EpisodeID"`
+	UserID    uuid.UUID
+	User      User `gorm:"foreignKey:UserID"`
+	Progress  uint
+
+	gorm.Model
+}
+
+type Device struct {
+	UserID   uuid.UUID `gorm:"uniqueIndex:idx_device_user"`
+	User     User      `gorm:"foreignKey:UserID"`
+	DeviceID string    `gorm:"size:191;uniqueIndex:idx_device_user"`
+	Caption  string
+	Type     string
+
+	gorm.Model
+}
+
+type SubscriptionChange struct {
+	UserID    uuid.UUID `gorm:"index"`
+	User      User      `gorm:"foreignKey:UserID"`
+	DeviceID  string
+	FeedLink  string
+	Action    string
+	Timestamp int64 `gorm:"index"`
+
+	gorm.Model
+}
+
+type EpisodeAction struct {
+	UserID     uuid.UUID `gorm:"index"`
+	User       User      `gorm:"foreignKey:UserID"`
+	DeviceID   string
+	PodcastURL string
+	EpisodeURL string
+	GUID       string
+	Action     string
+	Timestamp  time.Time
+	Started    int
+	Position   int
+	Total      int
+	Received   int64 `gorm:"index"`
+
+	gorm.Model
+}
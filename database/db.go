@@ -1,19 +1,128 @@
 package database
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"lincast/database/baseline"
 	"lincast/models"
 
+	"github.com/google/uuid"
 	"github.com/joomcode/errorx"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-func New(dbPort int, dbHost, dbUser, dbPassword, dbName string) (*gorm.DB, error) {
+// Supported database drivers.
+const (
+	DriverMySQL    = "mysql"
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Config holds the parameters used to connect to the database. The tags are used by the package config to read them
+// from the configuration file and the environment.
+type Config struct {
+	Driver   string `yaml:"driver" env:"DB_DRIVER"` // DriverMySQL (default), DriverSQLite or DriverPostgres
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	Path     string `yaml:"path" env:"DB_PATH"`       // File of the database, only used by DriverSQLite
+	SSLMode  string `yaml:"sslMode" env:"DB_SSLMODE"` // Only used by DriverPostgres ("disable" if empty)
+}
+
+// Validate checks that the driver is supported and that the parameters it requires are set. All the problems found are
+// reported on the same error.
+// Possible errors:
+//   - errorx.IllegalArgument: if the driver is not supported or a required parameter is missing.
+func (cfg Config) Validate() error {
+	var problems []string
+
+	switch cfg.Driver {
+	case DriverSQLite:
+		if cfg.Path == "" {
+			problems = append(problems, "the path of the SQLite database is required")
+		}
+
+	case DriverMySQL, DriverPostgres, "":
+		if cfg.Host == "" {
+			problems = append(problems, "the host of the database is required")
+		}
+
+		if cfg.Port < 1 || cfg.Port > 65535 {
+			problems = append(problems, fmt.Sprintf("the port of the database should be between 1 and 65535 (got %d)", cfg.Port))
+		}
+
+		if cfg.Name == "" {
+			problems = append(problems, "the name of the database is required")
+		}
+
+	default:
+		problems = append(problems, fmt.Sprintf("the database driver '%s' is not supported", cfg.Driver))
+	}
+
+	if len(problems) > 0 {
+		return errorx.IllegalArgument.New("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// New opens the database described by `cfg` and applies the pending migrations.
+// Possible errors:
+//   - errorx.IllegalArgument: if the driver is not supported or a required parameter is missing.
+//   - errorx.IllegalState: if the schema of the database is newer than the one known by the binary.
+//   - errorx.InternalError: if a migration fails.
+//   - Any error returned by the driver when trying to connect.
+func New(cfg Config) (*gorm.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := MigrateUp(db, 0); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Ping checks that the database can be reached. It's meant to be used as a health.CheckFunc.
+// Possible errors:
+//   - errorx.ExternalError: if the database can't be reached.
+//   - errorx.InternalError: if the connection pool can't be obtained.
+func Ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errorx.InternalError.Wrap(err, "the connection to the database can't be obtained")
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return errorx.ExternalError.Wrap(err, "the database can't be reached")
+	}
+
+	return nil
+}
+
+// Open opens the database described by `cfg` without applying the migrations. The queries are traced (see
+// tracing.Start) when tracing is set up.
+// Possible errors:
+//   - errorx.IllegalArgument: if the driver is not supported or a required parameter is missing.
+//   - errorx.InternalError: if the tracing of the queries can't be registered.
+//   - Any error returned by the driver when trying to connect.
+func Open(cfg Config) (*gorm.DB, error) {
 	l := logger.New(
 		log.StandardLogger(),
 		logger.Config{
@@ -24,29 +133,109 @@ func New(dbPort int, dbHost, dbUser, dbPassword, dbName string) (*gorm.DB, error
 		},
 	)
 
-	mysqlDSN := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		dbUser, dbPassword, dbHost, dbPort, dbName)
+	dialector, err := dialectorFor(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := gorm.Open(mysql.Open(mysqlDSN), &gorm.Config{Logger: l})
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: metricsLogger{Interface: l}})
 	if err != nil {
 		return nil, err
 	}
 
-	migrate(db)
+	if err := registerTracing(db); err != nil {
+		return nil, err
+	}
+
+	if cfg.Driver == DriverPostgres {
+		if err := nativeUUIDs(db); err != nil {
+			return nil, err
+		}
+	}
 
 	return db, nil
 }
 
-func migrate(db *gorm.DB) {
-	err := db.AutoMigrate(
-		&models.User{},
-		&models.Podcast{},
-		&models.Episode{},
-		&models.PlaybackInfo{},
-		&models.QueueEpisode{},
-		&models.EpisodeProgress{},
-	)
-	if err != nil {
-		log.WithError(errorx.EnsureStackTrace(err)).Panic("error when executing automigration")
+// dialectorFor returns the gorm dialector of the driver of the configuration.
+func dialectorFor(cfg Config) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case DriverMySQL, "":
+		mysqlDSN := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+
+		return mysql.Open(mysqlDSN), nil
+
+	case DriverSQLite:
+		if cfg.Path == "" {
+			return nil, errorx.IllegalArgument.New("the path of the SQLite database is required")
+		}
+
+		// The busy timeout and WAL mode let the update workers and the API write concurrently without failing with
+		// "database is locked".
+		sqliteDSN := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on", cfg.Path)
+
+		return sqlite.Open(sqliteDSN), nil
+
+	case DriverPostgres:
+		sslMode := cfg.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+
+		// The DSN is built as a URL, so the values with spaces or quotes (e.g. on the password) are escaped.
+		postgresDSN := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.User, cfg.Password),
+			Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			Path:     cfg.Name,
+			RawQuery: url.Values{"sslmode": []string{sslMode}}.Encode(),
+		}
+
+		return postgres.Open(postgresDSN.String()), nil
+
+	default:
+		return nil, errorx.IllegalArgument.New("unknown database driver '%s'", cfg.Driver)
+	}
+}
+
+// Models are all the models stored on the database, sorted so every model comes after the ones it references.
+var Models = []interface{}{
+	&models.User{},
+	&models.Podcast{},
+	&models.Episode{},
+	&models.PlaybackInfo{},
+	&models.QueueEpisode{},
+	&models.EpisodeProgress{},
+	&models.Device{},
+	&models.SubscriptionChange{},
+	&models.EpisodeAction{},
+}
+
+// nativeUUIDs makes the columns of type uuid.UUID (stored as char(36) or text by the other drivers) use the native
+// uuid type of PostgreSQL. The schemas of the models are cached by gorm, so the change applies to the migrations and
+// to the join tables of the relationships.
+func nativeUUIDs(db *gorm.DB) error {
+	uuidType := reflect.TypeOf(uuid.UUID{})
+
+	for _, m := range append(append([]interface{}{}, Models...), baseline.Models...) {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return err
+		}
+
+		fields := stmt.Schema.Fields
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable != nil {
+				fields = append(fields, rel.JoinTable.Fields...)
+			}
+		}
+
+		for _, f := range fields {
+			if f.FieldType == uuidType {
+				f.DataType = "uuid"
+			}
+		}
 	}
+
+	return nil
 }
@@ -1,17 +1,72 @@
 package database
 
 import (
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	"lincast/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func TestNew(t *testing.T) {
 	assert := assert2.New(t)
 	tempDir := t.TempDir()
 
-	db, err := New(tempDir, "test.db")
+	db, err := New(Config{Driver: DriverSQLite, Path: filepath.Join(tempDir, "test.db")})
 
 	assert.NoError(err, "The database should be initialized without errors")
 	assert.NotNil(db, "A valid instance of the database should be returned")
 }
+
+func TestPostgresDSN(t *testing.T) {
+	assert := assert2.New(t)
+
+	cfg := Config{Driver: DriverPostgres, Host: "localhost", Port: 5432, User: "lincast", Password: "p@ss word='x'", Name: "lincast"}
+
+	dialector, err := dialectorFor(cfg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	parsed, err := pgx.ParseConfig(dialector.(*postgres.Dialector).DSN)
+	if assert.NoError(err, "the DSN should be valid whatever the values of the configuration") {
+		assert.Equal(cfg.Host, parsed.Host)
+		assert.EqualValues(cfg.Port, parsed.Port)
+		assert.Equal(cfg.User, parsed.User)
+		assert.Equal(cfg.Password, parsed.Password, "the password should be escaped")
+		assert.Equal(cfg.Name, parsed.Database)
+	}
+}
+
+func TestNativeUUIDs(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(nativeUUIDs(db)) {
+		return
+	}
+
+	for _, m := range []interface{}{&models.User{}, &models.PlaybackInfo{}, &models.QueueEpisode{}} {
+		stmt := &gorm.Statement{DB: db}
+		if !assert.NoError(stmt.Parse(m)) {
+			return
+		}
+
+		for _, f := range stmt.Schema.Fields {
+			if f.FieldType == reflect.TypeOf(uuid.UUID{}) {
+				assert.EqualValues("uuid", f.DataType, "the column '%s' of '%s' should be of type uuid", f.DBName, stmt.Schema.Table)
+			}
+		}
+	}
+}
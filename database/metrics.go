@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"lincast/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	queryDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "lincast_db_query_duration_seconds",
+		Help:    "Time spent running the queries to the database.",
+		Buckets: metrics.DefaultBuckets,
+	})
+	queryErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lincast_db_query_errors_total",
+		Help: "Number of queries to the database that failed.",
+	})
+)
+
+// metricsLogger is a gorm logger that records the duration of each query (see Trace) before passing it to the
+// wrapped logger.
+type metricsLogger struct {
+	logger.Interface
+}
+
+func (l metricsLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return metricsLogger{Interface: l.Interface.LogMode(level)}
+}
+
+// Trace is called by gorm after running each query, regardless of the level of the logs. Only the elapsed time is
+// recorded, so the SQL is built by the wrapped logger only when it's going to be logged.
+func (l metricsLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	queryDuration.Observe(time.Since(begin).Seconds())
+
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		queryErrors.Inc()
+	}
+
+	l.Interface.Trace(ctx, begin, fc, err)
+}
@@ -0,0 +1,460 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"lincast/database/baseline"
+	"lincast/models"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Migration is a reversible change of the schema of the database. Migrations are applied in order of version, and
+// the versions that have been applied are stored on the table of SchemaVersion.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// SchemaVersion is a migration applied to the database.
+type SchemaVersion struct {
+	Version     int       `gorm:"primaryKey;autoIncrement:false"`
+	Description string    `gorm:"size:255"`
+	AppliedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// MigrationStatus is a migration known by the binary and whether it has been applied to the database.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// migrations are all the migrations known by the binary, sorted by version. New changes of the models must be added
+// as new migrations instead of modifying the existing ones.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(baseline.Models...)
+		},
+		Down: func(tx *gorm.DB) error {
+			tables := []interface{}{"subscriptions"}
+			for i := len(baseline.Models) - 1; i >= 0; i-- {
+				tables = append(tables, baseline.Models[i])
+			}
+
+			return tx.Migrator().DropTable(tables...)
+		},
+	},
+	{
+		Version:     2,
+		Description: "move episodes.parent_podcast_id to episodes.podcast_id",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn("episodes", "parent_podcast_id") {
+				return nil
+			}
+
+			err := tx.Exec("UPDATE episodes SET podcast_id = parent_podcast_id WHERE podcast_id IS NULL OR podcast_id = 0").Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Migrator().DropColumn("episodes", "parent_podcast_id")
+		},
+		Down: func(tx *gorm.DB) error {
+			type episode struct {
+				ParentPodcastID uint
+			}
+
+			if err := tx.Table("episodes").Migrator().AddColumn(&episode{}, "ParentPodcastID"); err != nil {
+				return err
+			}
+
+			return tx.Exec("UPDATE episodes SET parent_podcast_id = podcast_id").Error
+		},
+	},
+	{
+		Version:     3,
+		Description: "cascade the deletion of podcasts and episodes to their dependents",
+		Up: func(tx *gorm.DB) error {
+			if err := deleteOrphans(tx); err != nil {
+				return err
+			}
+
+			for _, c := range unsatisfiedConstraints {
+				if tx.Migrator().HasConstraint(c.model, c.relation) {
+					if err := tx.Migrator().DropConstraint(c.model, c.relation); err != nil {
+						return err
+					}
+				}
+			}
+
+			// The constraints are created again, so they are defined with the ON DELETE clause of the models.
+			for _, c := range cascadingConstraints {
+				if tx.Migrator().HasConstraint(c.model, c.relation) {
+					if err := tx.Migrator().DropConstraint(c.model, c.relation); err != nil {
+						return err
+					}
+				}
+
+				if err := tx.Migrator().CreateConstraint(c.model, c.relation); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// The dropped constraints can't be restored, since the rows stored by the single-user API don't satisfy
+			// them.
+			return errorx.UnsupportedOperation.New("the dropped foreign keys can't be restored")
+		},
+	},
+	{
+		Version:     4,
+		Description: "add episodes.played_changed_at and episodes.progress_changed_at",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"PlayedChangedAt", "ProgressChangedAt"} {
+				if tx.Migrator().HasColumn(&models.Episode{}, field) {
+					continue
+				}
+
+				if err := tx.Migrator().AddColumn(&models.Episode{}, field); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range []string{"PlayedChangedAt", "ProgressChangedAt"} {
+				if err := tx.Migrator().DropColumn(&models.Episode{}, field); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: "add queue_episodes.changed_at",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&models.QueueEpisode{}, "ChangedAt") {
+				if err := tx.Migrator().AddColumn(&models.QueueEpisode{}, "ChangedAt"); err != nil {
+					return err
+				}
+			}
+
+			// The existing entries were changed through the API, when they were added or removed.
+			return tx.Exec("UPDATE queue_episodes SET changed_at = COALESCE(deleted_at, created_at) WHERE changed_at IS NULL").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&models.QueueEpisode{}, "ChangedAt")
+		},
+	},
+	{
+		Version:     6,
+		Description: "add episode_progresses.played, episode_progresses.played_changed_at and episode_progresses.progress_changed_at",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"Played", "PlayedChangedAt", "ProgressChangedAt"} {
+				if tx.Migrator().HasColumn(&models.EpisodeProgress{}, field) {
+					continue
+				}
+
+				if err := tx.Migrator().AddColumn(&models.EpisodeProgress{}, field); err != nil {
+					return err
+				}
+			}
+
+			// The existing progress was stored when it was last changed.
+			return tx.Exec("UPDATE episode_progresses SET progress_changed_at = updated_at WHERE progress_changed_at IS NULL").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range []string{"Played", "PlayedChangedAt", "ProgressChangedAt"} {
+				if err := tx.Migrator().DropColumn(&models.EpisodeProgress{}, field); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+}
+
+// constraint is a foreign key constraint, identified by the model and the name of the relation that defines it.
+type constraint struct {
+	model    interface{}
+	relation string
+}
+
+// cascadingConstraints are the foreign keys whose rows are deleted along with the referenced podcast or episode.
+var cascadingConstraints = []constraint{
+	{&models.Podcast{}, "Episodes"},
+	{&models.Episode{}, "QueuesAddedTo"},
+	{&models.Episode{}, "BeingPlayedOn"},
+	{&models.Episode{}, "EpisodeProgress"},
+}
+
+// unsatisfiedConstraints are the foreign keys created by the initial schema that the rows stored by the single-user
+// API (without user or player) don't satisfy, so they are dropped.
+var unsatisfiedConstraints = []constraint{
+	{&models.Podcast{}, "AddedBy"},
+	{&models.User{}, "Player"},
+	{&models.User{}, "Queue"},
+}
+
+// deleteOrphans deletes the rows that reference podcasts or episodes that don't exist anymore, which would prevent
+// the creation of the foreign key constraints.
+func deleteOrphans(tx *gorm.DB) error {
+	queries := []string{
+		"DELETE FROM episodes WHERE podcast_id NOT IN (SELECT id FROM podcasts)",
+		"DELETE FROM queue_episodes WHERE episode_id NOT IN (SELECT id FROM episodes)",
+		"DELETE FROM playback_infos WHERE episode_id NOT IN (SELECT id FROM episodes)",
+		"DELETE FROM episode_progresses WHERE episode_id NOT IN (SELECT id FROM episodes)",
+		"DELETE FROM subscriptions WHERE podcast_id NOT IN (SELECT id FROM podcasts)",
+	}
+
+	for _, q := range queries {
+		if err := tx.Exec(q).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LatestVersion returns the version of the last migration known by the binary.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// CurrentVersion returns the version of the last migration applied to the database (0 if there is not any).
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+func CurrentVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return 0, errorx.InternalError.Wrap(err, "the table of schema versions can't be created")
+	}
+
+	var version int
+
+	if err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, errorx.InternalError.Wrap(err, "the schema version can't be obtained")
+	}
+
+	return version, nil
+}
+
+// CheckMigrations checks that all the migrations known by the binary have been applied, without modifying the database
+// (unlike CurrentVersion). It's meant to be used as a health.CheckFunc.
+// Possible errors:
+//   - errorx.IllegalState: if there are pending migrations, or the schema is newer than the one known by the binary.
+//   - errorx.InternalError: if there is an error with the database.
+func CheckMigrations(ctx context.Context, db *gorm.DB) error {
+	db = db.WithContext(ctx)
+
+	var version int
+
+	if db.Migrator().HasTable(&SchemaVersion{}) {
+		if err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+			return errorx.InternalError.Wrap(err, "the schema version can't be obtained")
+		}
+	}
+
+	if err := checkSchemaVersion(version); err != nil {
+		return err
+	}
+
+	if latest := LatestVersion(); version < latest {
+		return errorx.IllegalState.New("the schema of the database is at version %d, but the latest one is %d", version, latest)
+	}
+
+	return nil
+}
+
+// MigrateUp applies, in order, the pending migrations up to the given version (all of them if `target` is 0).
+// Possible errors:
+//   - errorx.IllegalState: if the schema of the database is newer than the one known by the binary.
+//   - errorx.InternalError: if a migration fails. The migrations applied before it are kept.
+func MigrateUp(db *gorm.DB, target int) error {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSchemaVersion(current); err != nil {
+		return err
+	}
+
+	if target == 0 {
+		target = LatestVersion()
+	}
+
+	return withoutForeignKeys(db, func(db *gorm.DB) error {
+		for _, m := range migrations {
+			if m.Version <= current || m.Version > target {
+				continue
+			}
+
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := m.Up(tx); err != nil {
+					return err
+				}
+
+				return tx.Create(&SchemaVersion{Version: m.Version, Description: m.Description}).Error
+			})
+			if err != nil {
+				return errorx.InternalError.Wrap(err, "the migration %d (%s) can't be applied", m.Version, m.Description)
+			}
+
+			log.WithFields(log.Fields{
+				"version":     m.Version,
+				"description": m.Description,
+			}).Info("Migration applied")
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown reverts, in reverse order, the given number of applied migrations.
+// Possible errors:
+//   - errorx.IllegalState: if the schema of the database is newer than the one known by the binary.
+//   - errorx.UnsupportedOperation: if a migration can't be reverted. The migrations reverted before it are kept
+//     reverted.
+//   - errorx.InternalError: if a migration fails. The migrations reverted before it are kept reverted.
+func MigrateDown(db *gorm.DB, steps int) error {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSchemaVersion(current); err != nil {
+		return err
+	}
+
+	return withoutForeignKeys(db, func(db *gorm.DB) error {
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if m.Version > current {
+				continue
+			}
+
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := m.Down(tx); err != nil {
+					return err
+				}
+
+				return tx.Delete(&SchemaVersion{}, m.Version).Error
+			})
+			if errorx.IsOfType(err, errorx.UnsupportedOperation) {
+				return errorx.Decorate(err, "the migration %d (%s) can't be reverted", m.Version, m.Description)
+			}
+
+			if err != nil {
+				return errorx.InternalError.Wrap(err, "the migration %d (%s) can't be reverted", m.Version, m.Description)
+			}
+
+			log.WithFields(log.Fields{
+				"version":     m.Version,
+				"description": m.Description,
+			}).Info("Migration reverted")
+
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Status returns all the migrations known by the binary and whether they have been applied.
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	if _, err := CurrentVersion(db); err != nil {
+		return nil, err
+	}
+
+	var applied []SchemaVersion
+
+	if err := db.Order("version").Find(&applied).Error; err != nil {
+		return nil, errorx.InternalError.Wrap(err, "the applied migrations can't be obtained")
+	}
+
+	byVersion := make(map[int]SchemaVersion, len(applied))
+	for _, v := range applied {
+		byVersion[v.Version] = v
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		v, ok := byVersion[m.Version]
+		status = append(status, MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     ok,
+			AppliedAt:   v.AppliedAt,
+		})
+	}
+
+	return status, nil
+}
+
+// checkSchemaVersion returns an error if the version of the schema is newer than the one known by the binary, since
+// running against it could corrupt the data.
+func checkSchemaVersion(current int) error {
+	if latest := LatestVersion(); current > latest {
+		return errorx.IllegalState.New("the schema of the database (version %d) is newer than the one supported by this "+
+			"version of LinCast (version %d)", current, latest)
+	}
+
+	return nil
+}
+
+// withoutForeignKeys runs fn with the enforcement of foreign keys disabled if the database is SQLite, since its
+// migrator recreates the tables to change their constraints, and dropping a referenced table would delete (or fail
+// on) the rows that reference it. The foreign keys are checked again once fn returns.
+// Possible errors:
+//   - errorx.IllegalState: if there are rows that violate a foreign key after running fn.
+//   - Any error returned by fn or by the database.
+func withoutForeignKeys(db *gorm.DB, fn func(db *gorm.DB) error) error {
+	if db.Dialector.Name() != DriverSQLite {
+		return fn(db)
+	}
+
+	// The pragma applies to a single connection, so all the statements must run on the same one.
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+
+		if err := fn(conn); err != nil {
+			return err
+		}
+
+		var violations []struct {
+			Table string
+		}
+
+		if err := conn.Raw("PRAGMA foreign_key_check").Scan(&violations).Error; err != nil {
+			return errorx.InternalError.Wrap(err, "the foreign keys can't be checked")
+		}
+
+		if len(violations) > 0 {
+			return errorx.IllegalState.New("%d rows violate a foreign key after the migrations (first one on the table "+
+				"'%s')", len(violations), violations[0].Table)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"lincast/models"
+
+	"github.com/joomcode/errorx"
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	assert := assert2.New(t)
+
+	cfg := Config{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")}
+
+	db, err := New(cfg)
+	if !assert.NoError(err, "the database should be initialized without errors") {
+		return
+	}
+
+	status, err := Status(db)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Len(status, LatestVersion(), "all the migrations should be listed")
+	for _, s := range status {
+		assert.True(s.Applied, "the migration %d should be applied on startup", s.Version)
+	}
+
+	err = MigrateDown(db, LatestVersion())
+	assert.True(errorx.IsOfType(err, errorx.UnsupportedOperation),
+		"reverting the migration 3, whose constraints can't be restored, should be refused: %v", err)
+
+	version, _ := CurrentVersion(db)
+	assert.Equal(3, version, "the migrations after the refused one should be reverted")
+
+	if !assert.NoError(MigrateUp(db, 0), "the migrations should be applied again without errors") {
+		return
+	}
+
+	assert.False(db.Migrator().HasColumn("episodes", "parent_podcast_id"), "the renamed column should be dropped")
+
+	// The migrations before the 3 can be reverted.
+	db, err = Open(Config{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if !assert.NoError(err) || !assert.NoError(MigrateUp(db, 2)) {
+		return
+	}
+
+	if !assert.NoError(MigrateDown(db, 2), "the migrations should be reverted without errors") {
+		return
+	}
+
+	version, _ = CurrentVersion(db)
+	assert.Zero(version, "no migration should be applied after reverting all of them")
+	assert.False(db.Migrator().HasTable(&models.Podcast{}), "the tables should be dropped")
+}
+
+func TestNewerSchema(t *testing.T) {
+	assert := assert2.New(t)
+
+	cfg := Config{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")}
+
+	db, err := New(cfg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	db.Create(&SchemaVersion{Version: LatestVersion() + 1, Description: "from the future"})
+
+	_, err = New(cfg)
+	if assert.Error(err, "a database with a newer schema should be rejected") {
+		assert.True(errorx.IsOfType(err, errorx.IllegalState), "the error should be of type IllegalState")
+	}
+}
+
+func TestCascadingConstraints(t *testing.T) {
+	assert := assert2.New(t)
+
+	cfg := Config{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")}
+
+	db, err := New(cfg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// The single-user API stores podcasts and queue entries without user.
+	p := models.Podcast{FeedLink: "https://example.com/feed"}
+	if !assert.NoError(db.Create(&p).Error, "a podcast without user should be stored") {
+		return
+	}
+
+	ep := models.Episode{PodcastID: p.ID, GUID: "ep-1"}
+	if !assert.NoError(db.Create(&ep).Error) {
+		return
+	}
+
+	assert.NoError(db.Create(&models.QueueEpisode{EpisodeID: ep.ID, Position: 1}).Error, "a queue entry without user should be stored")
+	assert.NoError(db.Create(&models.PlaybackInfo{EpisodeID: ep.ID}).Error)
+
+	assert.Error(db.Create(&models.Episode{PodcastID: p.ID + 1, GUID: "ep-2"}).Error,
+		"an episode of a podcast that doesn't exist should be rejected")
+
+	if !assert.NoError(db.Unscoped().Delete(&p).Error) {
+		return
+	}
+
+	for _, m := range []interface{}{&models.Episode{}, &models.QueueEpisode{}, &models.PlaybackInfo{}} {
+		var count int64
+		db.Unscoped().Model(m).Count(&count)
+
+		assert.Zero(count, "the dependents of the podcast should be deleted along with it")
+	}
+}
+
+func TestCheckMigrations(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, err := Open(Config{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(Ping(context.Background(), db), "the database should be reachable")
+
+	err = CheckMigrations(context.Background(), db)
+	assert.True(errorx.IsOfType(err, errorx.IllegalState), "a database without migrations should fail the check: %v", err)
+	assert.False(db.Migrator().HasTable(&SchemaVersion{}), "the check should not modify the database")
+
+	if !assert.NoError(MigrateUp(db, 1)) {
+		return
+	}
+
+	assert.False(db.Migrator().HasColumn("episodes", "played_changed_at"),
+		"the initial schema should not include the columns added by later migrations")
+	assert.Error(CheckMigrations(context.Background(), db), "a database with pending migrations should fail the check")
+
+	if !assert.NoError(MigrateUp(db, 0)) {
+		return
+	}
+
+	assert.NoError(CheckMigrations(context.Background(), db))
+}
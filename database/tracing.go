@@ -0,0 +1,97 @@
+package database
+
+import (
+	"errors"
+
+	"lincast/tracing"
+
+	"github.com/joomcode/errorx"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// spanKey is the key of the setting of the statements that holds their span.
+const spanKey = "lincast:span"
+
+// registerTracing registers the callbacks that record a client span for each query made with `db`. The spans are
+// children of the one carried by the context of the query, so the queries should be made with gorm.DB.WithContext to
+// be part of the trace of a request or a job.
+// Possible errors:
+//   - errorx.InternalError: if the callbacks can't be registered.
+func registerTracing(db *gorm.DB) error {
+	c := db.Callback()
+
+	for _, err := range []error{
+		c.Create().Before("gorm:create").Register("lincast:trace_before_insert", startSpan("insert")),
+		c.Create().After("gorm:create").Register("lincast:trace_after_insert", endSpan),
+		c.Query().Before("gorm:query").Register("lincast:trace_before_select", startSpan("select")),
+		c.Query().After("gorm:query").Register("lincast:trace_after_select", endSpan),
+		c.Update().Before("gorm:update").Register("lincast:trace_before_update", startSpan("update")),
+		c.Update().After("gorm:update").Register("lincast:trace_after_update", endSpan),
+		c.Delete().Before("gorm:delete").Register("lincast:trace_before_delete", startSpan("delete")),
+		c.Delete().After("gorm:delete").Register("lincast:trace_after_delete", endSpan),
+		c.Row().Before("gorm:row").Register("lincast:trace_before_row", startSpan("row")),
+		c.Row().After("gorm:row").Register("lincast:trace_after_row", endSpan),
+		c.Raw().Before("gorm:raw").Register("lincast:trace_before_raw", startSpan("raw")),
+		c.Raw().After("gorm:raw").Register("lincast:trace_after_raw", endSpan),
+	} {
+		if err != nil {
+			return errorx.InternalError.Wrap(err, "the tracing of the queries can't be registered")
+		}
+	}
+
+	return nil
+}
+
+// startSpan returns the callback that starts the span of a query of the given operation.
+func startSpan(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if !tracing.Enabled() || db.Statement.Context == nil {
+			return
+		}
+
+		name := "db." + operation
+		if db.Statement.Table != "" {
+			name += " " + db.Statement.Table
+		}
+
+		// The context of the statement is not replaced, since it can be reused by the next queries of the same chain.
+		_, span := tracing.Start(db.Statement.Context, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperationName(operation),
+			),
+		)
+
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+// endSpan is the callback that ends the span of a query, once it has been run. The statement is recorded with its
+// placeholders, so the values of the query are not part of the span.
+func endSpan(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+
+	span, ok := v.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		semconv.DBCollectionName(db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+
+	if !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		tracing.RecordError(span, db.Error)
+	}
+
+	span.End()
+}
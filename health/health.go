@@ -0,0 +1,183 @@
+// Package health reports whether LinCast is alive and ready to serve requests, in a way suitable for the probes of
+// Kubernetes and other orchestrators.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout is the maximum time that a check can take before being considered failed.
+const DefaultTimeout = time.Second * 5
+
+// Status of a check or of a report.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// CheckFunc checks a dependency of LinCast, returning an error if it's not ready. It should return when `ctx` is
+// done.
+type CheckFunc func(ctx context.Context) error
+
+// CheckResult is the result of a check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the result of all the checks of a Checker.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Ready reports whether all the checks succeeded.
+func (r *Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// Checker runs a set of named checks.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]CheckFunc
+}
+
+// NewChecker returns a new Checker without checks, that waits `timeout` for each check (DefaultTimeout if it's 0).
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Checker{timeout: timeout, checks: make(map[string]CheckFunc)}
+}
+
+// Add adds a check with the given name, replacing the one with the same name if any.
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+}
+
+// Run runs all the checks at the same time and returns their results. A check that doesn't finish before the timeout
+// is considered failed.
+func (c *Checker) Run(ctx context.Context) *Report {
+	c.mu.RLock()
+	checks := make(map[string]CheckFunc, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.RUnlock()
+
+	report := &Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+
+			result := c.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+// run runs a single check with the timeout of the checker.
+func (c *Checker) run(ctx context.Context, check CheckFunc) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errorx.TimeoutElapsed.New("the check didn't finish in %s", c.timeout)
+	}
+
+	result := CheckResult{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// LivenessHandler answers if the process is alive, which is the case whenever it can answer. It doesn't check any
+// dependency, so a failure of one of them doesn't get the process restarted.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, struct {
+			Status string `json:"status"`
+		}{StatusOK})
+	})
+}
+
+// ReadinessHandler answers with the report of the checks of `c`, with the status code 200 if all of them succeeded or
+// 503 otherwise.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"checks":     report.Checks,
+			}).Warning("LinCast is not ready")
+		}
+
+		writeJSON(w, r, status, report)
+	})
+}
+
+// writeJSON writes `v` encoded as JSON with the given status code. The probes shouldn't be cached.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr": r.RemoteAddr,
+			"error":      errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to encode the response to the request")
+	}
+}
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	assert := assert2.New(t)
+
+	c := NewChecker(time.Millisecond * 50)
+	c.Add("database", func(ctx context.Context) error { return nil })
+
+	serve := func() (*httptest.ResponseRecorder, Report) {
+		res := httptest.NewRecorder()
+		c.ReadinessHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var report Report
+		assert.NoError(json.NewDecoder(res.Body).Decode(&report))
+
+		return res, report
+	}
+
+	res, report := serve()
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(StatusOK, report.Status)
+	assert.Equal(StatusOK, report.Checks["database"].Status)
+
+	c.Add("workers", func(ctx context.Context) error { return errors.New("there are no workers") })
+	c.Add("scheduler", func(ctx context.Context) error {
+		<-ctx.Done()
+
+		return nil
+	})
+
+	res, report = serve()
+	assert.Equal(http.StatusServiceUnavailable, res.Code, "LinCast should not be ready if any check fails")
+	assert.Equal(StatusUnavailable, report.Status)
+	assert.Equal(StatusOK, report.Checks["database"].Status)
+	assert.Equal("there are no workers", report.Checks["workers"].Error)
+	assert.Equal(StatusUnavailable, report.Checks["scheduler"].Status, "the checks that time out should fail")
+}
+
+func TestLivenessHandler(t *testing.T) {
+	res := httptest.NewRecorder()
+	LivenessHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert2.Equal(t, http.StatusOK, res.Code)
+	assert2.JSONEq(t, `{"status":"ok"}`, res.Body.String())
+}
@@ -0,0 +1,78 @@
+package history
+
+import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joomcode/errorx"
+	_ "github.com/mattn/go-sqlite3" // SQLite driver used to read the database exports
+)
+
+// antennaPodQuery returns the played or started episodes of an AntennaPod database export. The position is stored in
+// milliseconds and the column 'read' is 1 for played episodes.
+const antennaPodQuery = `
+SELECT Feeds.download_url, FeedItems.item_identifier, FeedItems.title, FeedMedia.download_url,
+       FeedItems.read, FeedMedia.position
+FROM FeedItems
+JOIN Feeds ON FeedItems.feed = Feeds.id
+LEFT JOIN FeedMedia ON FeedMedia.feeditem = FeedItems.id
+WHERE FeedItems.read = 1 OR FeedMedia.position > 0`
+
+// ParseAntennaPod reads the listening history from the database export of AntennaPod stored on `path`.
+// Possible errors:
+//   - errorx.IllegalFormat: if the file is not an AntennaPod database.
+func ParseAntennaPod(path string) ([]Record, error) {
+	// The path is escaped as part of an URI, so the characters with a meaning on it (e.g. '#') can be used on the name.
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the path of the AntennaPod database is not valid")
+	}
+
+	absPath = filepath.ToSlash(absPath)
+	if !strings.HasPrefix(absPath, "/") {
+		absPath = "/" + absPath
+	}
+
+	uri := url.URL{Scheme: "file", Path: absPath, RawQuery: "mode=ro"}
+
+	db, err := sql.Open("sqlite3", uri.String())
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the AntennaPod database can't be opened")
+	}
+	defer db.Close()
+
+	rows, err := db.Query(antennaPodQuery)
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the file is not an AntennaPod database")
+	}
+	defer rows.Close()
+
+	var records []Record
+
+	for rows.Next() {
+		var feedURL, guid, title, enclosureURL sql.NullString
+		var read, position sql.NullInt64
+
+		if err := rows.Scan(&feedURL, &guid, &title, &enclosureURL, &read, &position); err != nil {
+			return nil, errorx.IllegalFormat.Wrap(err, "the AntennaPod database can't be read")
+		}
+
+		records = append(records, Record{
+			FeedURL:      feedURL.String,
+			GUID:         guid.String,
+			EnclosureURL: enclosureURL.String,
+			Title:        title.String,
+			Played:       read.Int64 == 1,
+			Position:     time.Duration(position.Int64) * time.Millisecond,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the AntennaPod database can't be read")
+	}
+
+	return records, nil
+}
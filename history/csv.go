@@ -0,0 +1,131 @@
+package history
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"lincast/opml"
+
+	"github.com/joomcode/errorx"
+)
+
+// Accepted names (normalized with normalizeColumn) for the columns of the CSV exports, since each app names them differently.
+var csvColumns = map[string][]string{
+	"feed":      {"feed_url", "feedurl", "podcast_url", "podcasturl", "feed", "podcast", "podcast_title"},
+	"guid":      {"guid", "episode_guid", "item_identifier", "uuid", "episode_uuid"},
+	"enclosure": {"enclosure_url", "enclosureurl", "episode_url", "audio_url", "media_url", "url"},
+	"title":     {"title", "episode_title", "episode"},
+	"played":    {"played", "status", "playing_status", "playingstatus", "completed"},
+	"position":  {"position", "played_up_to", "playedupto", "progress", "position_seconds"},
+}
+
+// Values of the played column considered as played.
+var playedValues = map[string]bool{
+	"1": true, "true": true, "yes": true, "played": true, "completed": true, "finished": true, "3": true,
+}
+
+// ParseCSV reads a CSV export of the listening history with a header row. The position is expected in seconds. The
+// podcast column may contain the title of the podcast instead of its feed URL; in that case, it's resolved using
+// `feeds` (usually the OPML export of the same app), if given.
+// Possible errors:
+//   - errorx.IllegalFormat: if the document can't be parsed or it doesn't have the needed columns.
+func ParseCSV(r io.Reader, feeds []opml.Feed) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the header of the CSV document can't be read")
+	}
+
+	columns := csvIndexes(header)
+	if _, ok := columns["guid"]; !ok {
+		if _, ok := columns["enclosure"]; !ok {
+			return nil, errorx.IllegalFormat.New("the CSV document should have a GUID or an enclosure URL column")
+		}
+	}
+
+	byTitle := make(map[string]string, len(feeds))
+	for _, f := range feeds {
+		byTitle[strings.ToLower(f.Title)] = f.URL
+	}
+
+	var records []Record
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, errorx.IllegalFormat.Wrap(err, "the line %d of the CSV document can't be read", line)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+
+			return ""
+		}
+
+		rec := Record{
+			FeedURL:      field("feed"),
+			GUID:         field("guid"),
+			EnclosureURL: field("enclosure"),
+			Title:        field("title"),
+			Played:       playedValues[strings.ToLower(field("played"))],
+		}
+
+		if rec.FeedURL != "" && !strings.Contains(rec.FeedURL, "://") {
+			rec.FeedURL = byTitle[strings.ToLower(rec.FeedURL)]
+		}
+
+		if p := field("position"); p != "" {
+			seconds, err := strconv.ParseFloat(p, 64)
+			if err != nil || seconds < 0 {
+				return nil, errorx.IllegalFormat.New("the position '%s' of the line %d is not valid", p, line)
+			}
+
+			rec.Position = time.Duration(seconds * float64(time.Second))
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// csvIndexes returns the index of each known column on the header.
+func csvIndexes(header []string) map[string]int {
+	indexes := make(map[string]int)
+
+	for name, aliases := range csvColumns {
+		for _, alias := range aliases {
+			for i, h := range header {
+				if normalizeColumn(h) == alias {
+					indexes[name] = i
+
+					break
+				}
+			}
+
+			if _, ok := indexes[name]; ok {
+				break
+			}
+		}
+	}
+
+	return indexes
+}
+
+// normalizeColumn returns the name of a column in lower case, with underscores instead of spaces or dashes.
+func normalizeColumn(name string) string {
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
@@ -0,0 +1,155 @@
+package history
+
+import (
+	"errors"
+	"time"
+
+	"lincast/models"
+	"lincast/repositories"
+
+	"github.com/joomcode/errorx"
+	"gorm.io/gorm"
+)
+
+// Record is the listening history of an episode, as exported by another podcast app.
+type Record struct {
+	FeedURL      string        `json:"feedURL"`
+	GUID         string        `json:"guid"`
+	EnclosureURL string        `json:"enclosureURL"`
+	Title        string        `json:"title"`
+	Played       bool          `json:"played"`
+	Position     time.Duration `json:"position"`
+}
+
+// Report is the result of importing a listening history.
+type Report struct {
+	DryRun    bool     `json:"dryRun"`
+	Total     int      `json:"total"`
+	Matched   int      `json:"matched"`
+	Played    int      `json:"played"`    // Matched episodes marked as played
+	Positions int      `json:"positions"` // Matched episodes whose progress has been set
+	Unmatched []Record `json:"unmatched"`
+}
+
+// Import writes the played state and the position of the given records over the matching episodes, as changes done
+// at the time of the import, in a single transaction. An episode matches a record if it belongs to the podcast with
+// the same feed URL and has the same GUID, or if it has the same enclosure URL. If `dryRun` is true, the records are
+// only matched.
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+func Import(db *gorm.DB, records []Record, dryRun bool) (*Report, error) {
+	report := &Report{DryRun: dryRun, Total: len(records), Unmatched: []Record{}}
+	now := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		matcher := NewMatcher(tx)
+		episodes := repositories.NewEpisodeRepository(tx)
+
+		for _, rec := range records {
+			ep, err := matcher.Episode(rec.FeedURL, rec.GUID, rec.EnclosureURL)
+			if err != nil {
+				return err
+			}
+
+			if ep == nil {
+				report.Unmatched = append(report.Unmatched, rec)
+
+				continue
+			}
+
+			report.Matched++
+
+			if rec.Played {
+				report.Played++
+			}
+
+			if rec.Position > 0 {
+				report.Positions++
+			}
+
+			if dryRun {
+				continue
+			}
+
+			if rec.Played {
+				if _, err := episodes.UpdatePlayed(ep.ID, true, now); err != nil {
+					return errorx.InternalError.Wrap(err, "the episode %d can't be updated", ep.ID)
+				}
+			}
+
+			if rec.Position > 0 {
+				if _, err := episodes.UpdateProgress(ep.ID, rec.Position, now); err != nil {
+					return errorx.InternalError.Wrap(err, "the episode %d can't be updated", ep.ID)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Matcher finds the episodes referenced by the exports of other apps (or instances), caching the podcasts by feed
+// URL.
+type Matcher struct {
+	db       *gorm.DB
+	podcasts map[string]uint // Identifier of the podcast of each feed URL, 0 if there is not any
+}
+
+// NewMatcher returns a new Matcher that looks for the episodes on `db`.
+func NewMatcher(db *gorm.DB) *Matcher {
+	return &Matcher{db: db, podcasts: make(map[string]uint)}
+}
+
+// Episode returns the episode that belongs to the podcast with the given feed URL and has the given GUID or, if
+// there is not any, the episode with the given enclosure URL. Returns nil if no episode matches.
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database.
+func (m *Matcher) Episode(feedURL, guid, enclosureURL string) (*models.Episode, error) {
+	var ep models.Episode
+
+	if feedURL != "" && guid != "" {
+		podcastID, ok := m.podcasts[feedURL]
+		if !ok {
+			var p models.Podcast
+
+			res := m.db.Select("id").Where("feed_link = ?", feedURL).Limit(1).Find(&p)
+			if res.Error != nil {
+				return nil, errorx.InternalError.Wrap(res.Error, "the podcast can't be obtained")
+			}
+
+			podcastID = p.ID
+			m.podcasts[feedURL] = podcastID
+		}
+
+		if podcastID != 0 {
+			err := m.db.Where("podcast_id = ? AND guid = ?", podcastID, guid).First(&ep).Error
+			if err == nil {
+				return &ep, nil
+			}
+
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errorx.InternalError.Wrap(err, "the episode can't be obtained")
+			}
+		}
+	}
+
+	if enclosureURL == "" {
+		return nil, nil
+	}
+
+	err := m.db.Where("enclosure_url = ?", enclosureURL).First(&ep).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, errorx.InternalError.Wrap(err, "the episode can't be obtained")
+	}
+
+	return &ep, nil
+}
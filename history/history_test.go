@@ -0,0 +1,130 @@
+package history
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lincast/models"
+	"lincast/opml"
+
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParseCSV(t *testing.T) {
+	assert := assert2.New(t)
+
+	doc := `Podcast,Episode Title,GUID,Episode URL,Played,Played Up To
+Go Time,Episode 1,gt-1,https://example.com/gt-1.mp3,true,0
+https://example.com/other.xml,Other,,https://example.com/other.mp3,false,90.5
+`
+	feeds := []opml.Feed{{Title: "Go Time", URL: "https://changelog.com/gotime/feed"}}
+
+	records, err := ParseCSV(strings.NewReader(doc), feeds)
+	if !assert.NoError(err, "a valid document should be parsed without errors") {
+		return
+	}
+
+	expected := []Record{
+		{FeedURL: "https://changelog.com/gotime/feed", GUID: "gt-1", EnclosureURL: "https://example.com/gt-1.mp3", Title: "Episode 1", Played: true},
+		{FeedURL: "https://example.com/other.xml", EnclosureURL: "https://example.com/other.mp3", Title: "Other", Position: 90500 * time.Millisecond},
+	}
+
+	assert.Equal(expected, records, "the podcast titles should be resolved with the OPML feeds")
+
+	_, err = ParseCSV(strings.NewReader("a,b\n1,2\n"), nil)
+	assert.Error(err, "a document without GUID or enclosure URL columns should be rejected")
+}
+
+func TestParseAntennaPod(t *testing.T) {
+	assert := assert2.New(t)
+
+	// The name contains characters that have a meaning on the URIs used by SQLite.
+	path := filepath.Join(t.TempDir(), "antennapod #1.db")
+
+	db, err := sql.Open("sqlite3", path)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = db.Exec(`
+CREATE TABLE Feeds (id INTEGER PRIMARY KEY, title TEXT, download_url TEXT);
+CREATE TABLE FeedItems (id INTEGER PRIMARY KEY, title TEXT, item_identifier TEXT, read INTEGER, feed INTEGER);
+CREATE TABLE FeedMedia (id INTEGER PRIMARY KEY, download_url TEXT, position INTEGER, feeditem INTEGER);
+INSERT INTO Feeds VALUES (1, 'Go Time', 'https://changelog.com/gotime/feed');
+INSERT INTO FeedItems VALUES (1, 'Played', 'gt-1', 1, 1), (2, 'Started', 'gt-2', 0, 1), (3, 'New', 'gt-3', -1, 1);
+INSERT INTO FeedMedia VALUES (1, 'https://example.com/gt-1.mp3', 0, 1), (2, 'https://example.com/gt-2.mp3', 60000, 2),
+    (3, 'https://example.com/gt-3.mp3', 0, 3);`)
+	db.Close()
+
+	if !assert.NoError(err) {
+		return
+	}
+
+	records, err := ParseAntennaPod(path)
+	if !assert.NoError(err, "a valid database should be read without errors") {
+		return
+	}
+
+	assert.ElementsMatch([]Record{
+		{FeedURL: "https://changelog.com/gotime/feed", GUID: "gt-1", EnclosureURL: "https://example.com/gt-1.mp3", Title: "Played", Played: true},
+		{FeedURL: "https://changelog.com/gotime/feed", GUID: "gt-2", EnclosureURL: "https://example.com/gt-2.mp3", Title: "Started", Position: time.Minute},
+	}, records, "only the played and started episodes should be returned")
+}
+
+func TestImport(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if !assert.NoError(err) {
+		return
+	}
+
+	if !assert.NoError(db.AutoMigrate(&models.Podcast{}, &models.Episode{})) {
+		return
+	}
+
+	p := models.Podcast{FeedLink: "https://changelog.com/gotime/feed"}
+	assert.NoError(db.Create(&p).Error)
+
+	episodes := []models.Episode{
+		{PodcastID: p.ID, GUID: "gt-1", EnclosureURL: "https://example.com/gt-1.mp3"},
+		{PodcastID: p.ID, GUID: "gt-2", EnclosureURL: "https://cdn.example.com/gt-2.mp3"},
+	}
+	assert.NoError(db.Create(&episodes).Error)
+
+	records := []Record{
+		{FeedURL: p.FeedLink, GUID: "gt-1", Played: true},
+		{FeedURL: "https://elsewhere.com/feed", EnclosureURL: "https://cdn.example.com/gt-2.mp3", Position: time.Minute},
+		{FeedURL: p.FeedLink, GUID: "unknown", Played: true},
+	}
+
+	report, err := Import(db, records, true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(2, report.Matched, "the episodes should be matched by GUID or by enclosure URL")
+	assert.Equal([]Record{records[2]}, report.Unmatched, "the unmatched records should be reported")
+
+	var played int64
+	db.Model(&models.Episode{}).Where("played = ?", true).Count(&played)
+	assert.Zero(played, "a dry run shouldn't modify the episodes")
+
+	_, err = Import(db, records, false)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var stored []models.Episode
+	db.Order("id").Find(&stored)
+
+	assert.True(stored[0].Played, "the played state should be imported")
+	assert.Equal(time.Minute, stored[1].CurrentProgress, "the position should be imported")
+	assert.False(stored[1].ProgressChangedAt.IsZero(), "the import should be a change of the progress for the sync")
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"runtime"
 	"syscall"
 	"time"
 
 	"lincast/api"
+	"lincast/config"
 	"lincast/database"
-	"lincast/models"
+	"lincast/health"
+	"lincast/maintenance"
+	"lincast/tracing"
 	"lincast/update"
-	"lincast/utils/parsing"
 
 	"github.com/joho/godotenv"
 	"github.com/joomcode/errorx"
@@ -22,72 +27,122 @@ import (
 	"gorm.io/gorm"
 )
 
-// All this constants should be also read from the configs file too (see #94)
-var (
-	devMode = flag.Bool("dev-mode", false, "Enable API's dev mode")
-
-	// Default filenames
-	logToFile    = flag.Bool("log-to-file", false, "Log to a file")
-	logsFilename = flag.String("logs-filename", "lincast.log", "Logs filename")
+var shutdownSignal = make(chan os.Signal, 1)
 
-	// Default settings of the server
-	serverPort  = flag.Uint("port", 8080, "Server's listening port")
-	serverLocal = flag.Bool("local", true, "If the server should only listen to local requests (localhost)")
-	serverLogs  = flag.Bool("log", true, "Whether server should log information or not")
+// shutdownTimeout is how long the workers of the update queue are waited to finish their jobs when LinCast stops.
+const shutdownTimeout = time.Second * 30
 
-	// Default settings related with feeds' refresh
-	updateFreq = flag.Duration("update-freq", time.Minute*30, "Server feed update frequency")
-)
-
-var shutdownSignal = make(chan os.Signal, 1)
+var checkConfigFlag = flag.Bool("check-config", false, "Validate the configuration and the connection to the database, and exit")
 
 func main() {
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	// The .env file is optional, since the configuration can be given on the real environment (e.g. on containers).
 	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.WithError(err).Fatalln("Error loading .env file")
 	}
 
 	handleCmdArgs()
 
-	if *logToFile {
-		setupLoggingToFile(*logsFilename, *devMode)
+	cfg := loadConfig()
+
+	if *checkConfigFlag {
+		checkConfig(cfg)
 	}
 
+	handleSubcommands(cfg)
+
+	setupLogging(cfg.Logging, cfg.Server.DevMode)
+
 	log.Debugln("Starting LinCast")
 
-	run(*devMode)
+	run(cfg)
+}
+
+// loadConfig returns the configuration given by the configuration file, the environment and the flags. If it's not
+// valid, all the problems found are printed and the program exits.
+func loadConfig() *config.Config {
+	cfg, err := config.Load(flag.CommandLine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+
+		for _, problem := range config.Problems(err) {
+			fmt.Fprintln(os.Stderr, "  -", problem)
+		}
+
+		os.Exit(1)
+	}
+
+	return cfg
 }
 
-func run(devMode bool) {
+func run(cfg *config.Config) {
 	// Subscribe to signals related with the stop of the program
-	signal.Notify(shutdownSignal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	signal.Notify(shutdownSignal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-	dbPort, dbHost, dbUser, dbPassword, dbName := parsing.ParseEnv()
+	// SIGHUP reloads the configuration instead.
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
 
-	db, err := database.New(dbPort, dbHost, dbUser, dbPassword, dbName)
+	// The tracing is set up first, so the queries made when initializing the database are traced too.
+	stopTracing, err := tracing.Setup(tracingOptions(cfg.Tracing))
+	if err != nil {
+		log.WithError(errorx.EnsureStackTrace(err)).Fatalln("Error when trying to set up the tracing")
+	}
+
+	db, err := database.New(cfg.Database)
 	if err != nil {
 		log.WithError(errorx.EnsureStackTrace(err)).Fatalln("Error when trying to initialize the database")
 	}
 
-	manualFeedUpd := make(chan *models.Podcast)
+	manualFeedUpd := make(chan *update.Job, update.ManualQueueSize)
+
+	schedule, quietHours, err := parseUpdateSchedule(cfg.Updater.Frequency, cfg.Updater.Schedule, cfg.Updater.QuietHours)
+	if err != nil {
+		log.WithError(errorx.EnsureStackTrace(err)).Fatalln("Error when trying to parse the update schedule")
+	}
+
+	updateQueue, err := update.NewUpdateQueue(db, cfg.Updater.Workers)
+	if err != nil {
+		log.WithError(errorx.EnsureStackTrace(err)).Fatalln("Error when trying to create the update queue")
+	}
+
+	if err := updateQueue.SetJobTimeout(cfg.Updater.JobTimeout); err != nil {
+		log.WithError(errorx.EnsureStackTrace(err)).Fatalln("Error when trying to set the timeout of the update jobs")
+	}
+
+	scheduler, err := update.NewScheduler(db, updateQueue, schedule, quietHours)
+	if err != nil {
+		log.WithError(errorx.EnsureStackTrace(err)).Fatalln("Error when trying to create the scheduler of the updates")
+	}
 
 	// Run the loop that updates the subscribed podcasts.
-	go runUpdateQueue(db, *updateFreq, manualFeedUpd)
+	go scheduler.Run(manualFeedUpd)
 
-	go func() {
-		// Make a new instance of the server.
-		sv := api.New(*serverPort, *serverLocal, devMode, *serverLogs, db, manualFeedUpd)
+	// Run the loop that purges the rows that are not needed anymore.
+	go runPurger(db, cfg.Maintenance)
+
+	// Make a new instance of the server.
+	// Checks of the dependencies answered on /readyz.
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Add("database", func(ctx context.Context) error { return database.Ping(ctx, db) })
+	checker.Add("migrations", func(ctx context.Context) error { return database.CheckMigrations(ctx, db) })
+	checker.Add("workers", updateQueue.Check)
+	checker.Add("scheduler", scheduler.Check)
 
+	sv := api.New(cfg.Server, cfg.Auth, cfg.Limits, db, manualFeedUpd, updateQueue, checker)
+
+	go func() {
 		log.WithFields(log.Fields{
-			"port":        *serverPort,
-			"localServer": *serverLocal,
-			"devMode":     devMode,
-			"logRequests": *serverLogs,
+			"port":        cfg.Server.Port,
+			"localServer": cfg.Server.Local,
+			"devMode":     cfg.Server.DevMode,
+			"logRequests": cfg.Server.LogRequests,
 		}).Info("Starting server")
 
-		err = sv.ListenAndServe()
+		err := sv.ListenAndServe()
 		if err != nil {
 			log.WithError(
 				errorx.EnsureStackTrace(err),
@@ -95,76 +150,103 @@ func run(devMode bool) {
 		}
 	}()
 
-	<-shutdownSignal
+	r := reloader{cfg: cfg, queue: updateQueue, scheduler: scheduler, server: sv}
+
+	for {
+		select {
+		case <-shutdownSignal:
+			stopUpdateQueue(updateQueue)
+			flushTraces(stopTracing)
+
+			return
+
+		case <-reloadSignal:
+			r.reload()
+		}
+	}
 }
 
-func runUpdateQueue(db *gorm.DB, updateInterval time.Duration, manualFeedUpd chan *models.Podcast) {
-	log.WithField("updateInterval", updateInterval.String()).Debug("Starting feeds' update loop")
+// stopUpdateQueue stops the workers of the update queue, waiting for the jobs that they are processing.
+func stopUpdateQueue(q *update.UpdateQueue) {
+	log.WithField("busyWorkers", q.Busy()).Info("Stopping the update queue")
 
-	ticker := time.NewTicker(updateInterval)
-	defer ticker.Stop()
-	qLength := runtime.NumCPU()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	updateQueue, err := update.NewUpdateQueue(db, qLength)
-	if err != nil {
-		log.WithField("error", errorx.Decorate(errorx.EnsureStackTrace(err), "error when creating update queue")).
-			Panic("Cannot initialize the update queue")
+	if !q.Stop(ctx) {
+		log.WithField("timeout", shutdownTimeout.String()).Warning("Some workers didn't finish their jobs in time")
+	}
+}
+
+// flushTraces exports the spans that are still pending and disables the tracing.
+func flushTraces(stop func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := stop(ctx); err != nil {
+		log.WithError(err).Warning("Some spans couldn't be exported")
+	}
+}
+
+// tracingOptions returns the options of the tracing given by the configuration.
+func tracingOptions(cfg config.Tracing) tracing.Options {
+	return tracing.Options{
+		Exporter:    cfg.Exporter,
+		Endpoint:    cfg.Endpoint,
+		ServiceName: cfg.ServiceName,
 	}
+}
 
-	log.Info("Updating feeds for first time since LinCast is running")
-	err = updateAllPodcasts(db, updateQueue)
+func runPurger(db *gorm.DB, cfg config.Maintenance) {
+	purger, err := maintenance.NewPurger(db, purgePolicy(cfg))
 	if err != nil {
-		log.WithField("error", errorx.Decorate(err, "Error when trying to update podcasts"))
+		log.WithField("error", errorx.Decorate(errorx.EnsureStackTrace(err), "error when creating the purger")).
+			Panic("Cannot initialize the purge of the database")
 	}
 
-	for {
-		select {
-		case <-ticker.C:
-			{
-				log.Info("Updating podcasts' feeds")
-				err := updateAllPodcasts(db, updateQueue)
-				if err != nil {
-					log.WithField("error", errorx.EnsureStackTrace(err)).Error("Error when trying to update podcasts' feeds")
-				} else {
-					log.Info("Podcasts' feeds updated correctly")
-				}
-			}
-		case p := <-manualFeedUpd:
-			{
-				j := update.NewJob(p)
-
-				log.WithFields(log.Fields{
-					"podcastFeed": p.FeedLink,
-					"podcastID":   p.ID,
-					// "podcastSubscribed": p.Subscribed,
-				}).Info("Sending podcast to the update queue (manual update)")
-
-				updateQueue.Send(j)
-			}
-		}
+	purger.Run(cfg.PurgeInterval)
+}
+
+// purgePolicy returns the policy of the purges given by the configuration.
+func purgePolicy(cfg config.Maintenance) maintenance.Policy {
+	return maintenance.Policy{
+		Retention:    cfg.Retention,
+		KeepEpisodes: cfg.KeepEpisodes,
 	}
 }
 
-func updateAllPodcasts(db *gorm.DB, updateQueue *update.UpdateQueue) error {
-	var subscribedPodcasts []models.Podcast
-	if res := db.Where("subscribed", true).Find(&subscribedPodcasts); res.Error != nil {
-		return errorx.InternalError.Wrap(res.Error, "error trying to get subscribed podcasts")
+// parseUpdateSchedule returns the global schedule of the updates. If `scheduleExpr` is empty, the feeds are updated
+// each `updateFreq`.
+func parseUpdateSchedule(updateFreq time.Duration, scheduleExpr, quietHoursExpr string) (update.Schedule, update.QuietHours, error) {
+	schedule := update.EverySchedule(updateFreq)
+
+	if scheduleExpr != "" {
+		s, err := update.ParseSchedule(scheduleExpr)
+		if err != nil {
+			return update.Schedule{}, update.QuietHours{}, err
+		}
+
+		schedule = s
 	}
 
-	log.Debug("Starting loop to send podcasts to the update queue")
-	for _, p := range subscribedPodcasts {
-		j := update.NewJob(&p)
+	quietHours, err := update.ParseQuietHours(quietHoursExpr)
+	if err != nil {
+		return update.Schedule{}, update.QuietHours{}, err
+	}
 
-		log.WithFields(log.Fields{
-			"podcastFeed": p.FeedLink,
-			"podcastID":   p.ID,
-			// "podcastSubscribed": p.Subscribed,
-		}).Info("Sending podcast to the update queue")
+	return schedule, quietHours, nil
+}
 
-		updateQueue.Send(j)
+// setupLogging sets the level of the logs and, if enabled, their output to a file.
+func setupLogging(cfg config.Logging, devMode bool) {
+	// The level has already been validated along with the rest of the configuration.
+	if level, err := log.ParseLevel(cfg.Level); err == nil {
+		log.SetLevel(level)
 	}
 
-	return nil
+	if cfg.ToFile {
+		setupLoggingToFile(cfg.Filename, devMode)
+	}
 }
 
 func setupLoggingToFile(filename string, devMode bool) {
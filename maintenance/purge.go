@@ -0,0 +1,201 @@
+package maintenance
+
+import (
+	"reflect"
+	"time"
+
+	"lincast/database"
+	"lincast/models"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Policy determines which rows are removed by the Purger.
+type Policy struct {
+	// Retention is how long the soft-deleted rows are kept before being hard-deleted. If it's 0, they are kept forever.
+	Retention time.Duration
+	// KeepEpisodes is the number of episodes (the most recent ones) kept of each podcast that is not subscribed, along
+	// with the ones with listening history. If it's 0, all of them are kept.
+	KeepEpisodes int
+}
+
+// Report is the summary of a purge.
+type Report struct {
+	Purged         map[string]int64 `json:"purged"` // Number of soft-deleted rows hard-deleted, by table
+	PrunedEpisodes int64            `json:"prunedEpisodes"`
+}
+
+// Total returns the number of rows removed by the purge.
+func (r *Report) Total() int64 {
+	total := r.PrunedEpisodes
+	for _, n := range r.Purged {
+		total += n
+	}
+
+	return total
+}
+
+// Purger removes from the database the rows that are not needed anymore: the soft-deleted ones, once their retention
+// is over, and the old episodes of the podcasts that are not subscribed.
+type Purger struct {
+	db     *gorm.DB
+	policy Policy
+}
+
+// NewPurger returns a new Purger that will remove the rows of `db` following the given policy.
+func NewPurger(db *gorm.DB, policy Policy) (*Purger, error) {
+	if db == nil {
+		return nil, errorx.IllegalState.New("the instance of the database is nil")
+	}
+
+	if policy.Retention < 0 {
+		return nil, errorx.IllegalArgument.New("the retention can't be negative")
+	}
+
+	if policy.KeepEpisodes < 0 {
+		return nil, errorx.IllegalArgument.New("the number of episodes to keep can't be negative")
+	}
+
+	return &Purger{db: db, policy: policy}, nil
+}
+
+// Run purges the database each `interval`, blocking the caller. The first purge is done right away.
+func (p *Purger) Run(interval time.Duration) {
+	log.WithFields(log.Fields{
+		"interval":     interval.String(),
+		"retention":    p.policy.Retention.String(),
+		"keepEpisodes": p.policy.KeepEpisodes,
+	}).Debug("Starting the purge loop")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := time.Now(); ; now = <-ticker.C {
+		report, err := p.Purge(now)
+		if err != nil {
+			log.WithField("error", errorx.EnsureStackTrace(err)).Error("Error when trying to purge the database")
+
+			continue
+		}
+
+		fields := log.Fields{"prunedEpisodes": report.PrunedEpisodes}
+		for table, n := range report.Purged {
+			fields[table] = n
+		}
+
+		log.WithFields(fields).Infof("Database purged (%d rows removed)", report.Total())
+	}
+}
+
+// Purge hard-deletes the rows soft-deleted before `now` minus the retention, and the episodes of the podcasts that
+// are not subscribed beyond the number of episodes to keep. The episodes that are on the queue or being played are
+// never pruned.
+// Possible errors:
+//   - errorx.InternalError: if there is an error with the database. The rows removed before it are not restored.
+func (p *Purger) Purge(now time.Time) (*Report, error) {
+	report := &Report{Purged: make(map[string]int64)}
+
+	if p.policy.Retention > 0 {
+		if err := p.purgeSoftDeleted(now.Add(-p.policy.Retention), report); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.policy.KeepEpisodes > 0 {
+		if err := p.pruneEpisodes(report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// purgeSoftDeleted hard-deletes the rows of the models with soft-delete that have been deleted before `before`. The
+// models are purged from the ones that reference others to the referenced ones.
+func (p *Purger) purgeSoftDeleted(before time.Time, report *Report) error {
+	deletedAtType := reflect.TypeOf(gorm.DeletedAt{})
+
+	for i := len(database.Models) - 1; i >= 0; i-- {
+		m := database.Models[i]
+
+		stmt := &gorm.Statement{DB: p.db}
+		if err := stmt.Parse(m); err != nil {
+			return errorx.InternalError.Wrap(err, "the schema of the model can't be parsed")
+		}
+
+		// Some models have a DeletedAt field that is not a gorm.DeletedAt, so their deletes are not soft.
+		field := stmt.Schema.LookUpField("DeletedAt")
+		if field == nil || field.FieldType != deletedAtType {
+			continue
+		}
+
+		res := p.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(m)
+		if res.Error != nil {
+			return errorx.InternalError.Wrap(res.Error, "the soft-deleted rows of the table '%s' can't be purged", stmt.Schema.Table)
+		}
+
+		if res.RowsAffected > 0 {
+			report.Purged[stmt.Schema.Table] = res.RowsAffected
+		}
+	}
+
+	return nil
+}
+
+// pruneEpisodes hard-deletes the episodes of the podcasts that are not subscribed, except the most recent ones and
+// the ones with listening history (played or with progress), which is kept as when the podcasts are unsubscribed.
+func (p *Purger) pruneEpisodes(report *Report) error {
+	var podcastIDs []uint
+
+	if err := p.db.Model(&models.Podcast{}).Where("subscribed = ?", false).Pluck("id", &podcastIDs).Error; err != nil {
+		return errorx.InternalError.Wrap(err, "the podcasts that are not subscribed can't be obtained")
+	}
+
+	queued := p.db.Model(&models.QueueEpisode{}).Select("episode_id")
+	playing := p.db.Model(&models.PlaybackInfo{}).Select("episode_id")
+	withProgress := p.db.Model(&models.EpisodeProgress{}).Select("episode_id")
+
+	for _, id := range podcastIDs {
+		var keep []uint
+
+		err := p.db.Unscoped().Model(&models.Episode{}).
+			Where("podcast_id = ?", id).
+			Order("published desc, id desc").
+			Limit(p.policy.KeepEpisodes).
+			Pluck("id", &keep).Error
+		if err != nil {
+			return errorx.InternalError.Wrap(err, "the episodes to keep of the podcast %d can't be obtained", id)
+		}
+
+		if len(keep) < p.policy.KeepEpisodes {
+			continue
+		}
+
+		// The IDs are obtained first, since MySQL can't delete from a table selected on a subquery.
+		var pruned []uint
+
+		err = p.db.Unscoped().Model(&models.Episode{}).
+			Where("podcast_id = ? AND id NOT IN ?", id, keep).
+			Where("id NOT IN (?) AND id NOT IN (?)", queued, playing).
+			Where("played = ? AND current_progress = 0 AND id NOT IN (?)", false, withProgress).
+			Pluck("id", &pruned).Error
+		if err != nil {
+			return errorx.InternalError.Wrap(err, "the episodes to prune of the podcast %d can't be obtained", id)
+		}
+
+		if len(pruned) == 0 {
+			continue
+		}
+
+		res := p.db.Unscoped().Delete(&models.Episode{}, pruned)
+		if res.Error != nil {
+			return errorx.InternalError.Wrap(res.Error, "the episodes of the podcast %d can't be pruned", id)
+		}
+
+		report.PrunedEpisodes += res.RowsAffected
+	}
+
+	return nil
+}
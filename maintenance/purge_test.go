@@ -0,0 +1,135 @@
+package maintenance
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"lincast/database"
+	"lincast/models"
+
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(database.Models...); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPurgeSoftDeleted(t *testing.T) {
+	assert := assert2.New(t)
+
+	db := newTestDB(t)
+	now := time.Now()
+
+	old := models.Podcast{FeedLink: "https://example.com/old"}
+	recent := models.Podcast{FeedLink: "https://example.com/recent"}
+	kept := models.Podcast{FeedLink: "https://example.com/kept"}
+	db.Create(&old)
+	db.Create(&recent)
+	db.Create(&kept)
+
+	db.Unscoped().Model(&old).Update("deleted_at", now.Add(-time.Hour*48))
+	db.Unscoped().Model(&recent).Update("deleted_at", now.Add(-time.Hour))
+
+	purger, err := NewPurger(db, Policy{Retention: time.Hour * 24})
+	if !assert.NoError(err) {
+		return
+	}
+
+	report, err := purger.Purge(now)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(map[string]int64{"podcasts": 1}, report.Purged, "only the podcast deleted before the retention should be purged")
+
+	var ids []uint
+	db.Unscoped().Model(&models.Podcast{}).Order("id").Pluck("id", &ids)
+
+	assert.Equal([]uint{recent.ID, kept.ID}, ids)
+}
+
+func TestPruneEpisodes(t *testing.T) {
+	assert := assert2.New(t)
+
+	db := newTestDB(t)
+	now := time.Now()
+
+	unsubscribed := models.Podcast{FeedLink: "https://example.com/unsubscribed"}
+	subscribed := models.Podcast{FeedLink: "https://example.com/subscribed", Subscribed: true}
+	db.Create(&unsubscribed)
+	db.Create(&subscribed)
+
+	var queued models.Episode
+
+	for i := 0; i < 6; i++ {
+		for _, p := range []models.Podcast{unsubscribed, subscribed} {
+			ep := models.Episode{
+				PodcastID: p.ID,
+				GUID:      fmt.Sprintf("%d-%d", p.ID, i),
+				Published: now.Add(time.Duration(i) * time.Hour),
+			}
+
+			// The listening history of the episodes of the podcasts that are not subscribed is kept.
+			if p.ID == unsubscribed.ID {
+				ep.Played = i == 1
+				if i == 2 {
+					ep.CurrentProgress = time.Minute
+				}
+			}
+
+			db.Create(&ep)
+
+			if i == 0 && p.ID == unsubscribed.ID {
+				queued = ep
+			}
+		}
+	}
+
+	db.Create(&models.QueueEpisode{EpisodeID: queued.ID, Position: 1})
+
+	purger, err := NewPurger(db, Policy{KeepEpisodes: 2})
+	if !assert.NoError(err) {
+		return
+	}
+
+	report, err := purger.Purge(now)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.EqualValues(1, report.PrunedEpisodes, "the oldest episodes not on the queue and without history should be pruned")
+
+	var guids []string
+	db.Unscoped().Model(&models.Episode{}).Where("podcast_id = ?", unsubscribed.ID).Order("published").Pluck("guid", &guids)
+
+	assert.Equal([]string{
+		queued.GUID,
+		fmt.Sprintf("%d-1", unsubscribed.ID),
+		fmt.Sprintf("%d-2", unsubscribed.ID),
+		fmt.Sprintf("%d-4", unsubscribed.ID),
+		fmt.Sprintf("%d-5", unsubscribed.ID),
+	}, guids)
+
+	var count int64
+	db.Model(&models.Episode{}).Where("podcast_id = ?", subscribed.ID).Count(&count)
+
+	assert.EqualValues(6, count, "the episodes of the subscribed podcasts should not be pruned")
+}
+
+func TestNewPurgerInvalidPolicy(t *testing.T) {
+	_, err := NewPurger(newTestDB(t), Policy{Retention: -time.Hour})
+
+	assert2.Error(t, err, "a negative retention should be rejected")
+}
@@ -0,0 +1,19 @@
+// Package metrics exposes the metrics of LinCast in the text format of Prometheus
+// (https://prometheus.io/docs/instrumenting/exposition_formats/). The metrics are registered with promauto on the
+// default registry of the Prometheus client when the package that records them is initialized.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the buckets of the histograms that measure durations.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Handler returns an http.Handler that answers with the metrics of the default registry, along with the ones of the
+// Go runtime and the process.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
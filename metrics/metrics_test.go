@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert2.New(t)
+
+	requests := promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_requests_total",
+		Help: "Number of requests.",
+	}, []string{"method", "route"})
+
+	requests.WithLabelValues("GET", "/podcasts/{id}").Add(3)
+
+	res := httptest.NewRecorder()
+	Handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := io.ReadAll(res.Body)
+
+	assert.Equal(200, res.Code)
+	assert.Contains(string(body), "# TYPE test_requests_total counter\n"+
+		`test_requests_total{method="GET",route="/podcasts/{id}"} 3`)
+	assert.Contains(string(body), "go_goroutines", "the metrics of the runtime should be exposed")
+}
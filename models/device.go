@@ -0,0 +1,17 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Device is a client (e.g. a mobile app) registered by a user through the gpodder.net compatible API.
+type Device struct {
+	UserID   uuid.UUID `json:"-" gorm:"uniqueIndex:idx_device_user"`
+	User     User      `json:"-" gorm:"foreignKey:UserID"`
+	DeviceID string    `json:"id" gorm:"size:191;uniqueIndex:idx_device_user"` // Identifier chosen by the client
+	Caption  string    `json:"caption"`
+	Type     string    `json:"type"` // One of "desktop", "laptop", "mobile", "server" or "other"
+
+	gorm.Model
+}
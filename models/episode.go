@@ -25,10 +25,14 @@ type Episode struct {
 	Updated         time.Time     `json:"updated"`   // Mirror of gofeed.Item.UpdatedParsed
 	Played          bool          `json:"played"`
 	CurrentProgress time.Duration `json:"currentProgress"`
+	// When the client did the last applied change of the played state and the progress, used to discard the older
+	// changes that are synced later.
+	PlayedChangedAt   time.Time `json:"playedChangedAt"`
+	ProgressChangedAt time.Time `json:"progressChangedAt"`
 
-	QueuesAddedTo   []QueueEpisode    `json:"queuesAddedTo"`
-	BeingPlayedOn   []PlaybackInfo    `json:"beingPlayedOn"`
-	EpisodeProgress []EpisodeProgress `json:"episodeProgress"`
+	QueuesAddedTo   []QueueEpisode    `json:"queuesAddedTo" gorm:"constraint:OnDelete:CASCADE"`
+	BeingPlayedOn   []PlaybackInfo    `json:"beingPlayedOn" gorm:"constraint:OnDelete:CASCADE"`
+	EpisodeProgress []EpisodeProgress `json:"episodeProgress" gorm:"constraint:OnDelete:CASCADE"`
 
 	gorm.Model
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EpisodeAction is an action done by a user over an episode (e.g. play it until a position), as reported by the
+// clients of the gpodder.net compatible API.
+type EpisodeAction struct {
+	UserID     uuid.UUID `json:"-" gorm:"index"`
+	User       User      `json:"-" gorm:"foreignKey:UserID"`
+	DeviceID   string    `json:"device"`
+	PodcastURL string    `json:"podcast"`
+	EpisodeURL string    `json:"episode"`
+	GUID       string    `json:"guid"`
+	Action     string    `json:"action"`         // One of "download", "play", "delete" or "new"
+	Timestamp  time.Time `json:"timestamp"`      // When the action was done, according to the client
+	Started    int       `json:"started"`        // In seconds, only for "play" actions
+	Position   int       `json:"position"`       // In seconds, only for "play" actions
+	Total      int       `json:"total"`          // In seconds, only for "play" actions
+	Received   int64     `json:"-" gorm:"index"` // Unix time at which the action was received
+
+	gorm.Model
+}
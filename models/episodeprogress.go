@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -10,7 +12,12 @@ type EpisodeProgress struct {
 	Episode   Episode   `json:"episode" gorm:"foreignKey:EpisodeID"`
 	UserID    uuid.UUID `json:"userID"`
 	User      User      `json:"-" gorm:"foreignKey:UserID"`
-	Progress  uint      `json:"progress"`
+	Progress  uint      `json:"progress"` // In seconds
+	Played    bool      `json:"played"`
+	// When the client did the last applied change of the played state and the progress, used to discard the older
+	// changes that are synced later.
+	PlayedChangedAt   time.Time `json:"playedChangedAt"`
+	ProgressChangedAt time.Time `json:"progressChangedAt"`
 
 	gorm.Model
 }
@@ -9,25 +9,29 @@ import (
 
 // Podcast is the structure that represents a podcast.
 type Podcast struct {
-	AuthorName    string    `json:"authorName"`
-	AuthorEmail   string    `json:"authorEmail"`
-	Title         string    `json:"title"`
-	Description   string    `json:"description"`
-	Categories    string    `json:"categories"`
-	ImageURL      string    `json:"imageURL"`
-	ImageTitle    string    `json:"imageTitle"`
-	Link          string    `json:"link"`
-	FeedLink      string    `json:"feedLink" gorm:"unique"`
-	FeedType      string    `json:"feedType"`
-	FeedVersion   string    `json:"feedVersion"`
-	Language      string    `json:"language"`
-	Updated       time.Time `json:"updated"` // Mirror of gofeed.Feed.UpdatedParsed
-	LastCheck     time.Time `json:"lastCheck"`
-	Added         time.Time `json:"added"`
-	Episodes      []Episode `json:"episodes"`
-	AddedBy       User      `json:"-" gorm:"foreignKey:AddedByID"`
-	AddedByID     uuid.UUID `json:"addedByID" `
-	Subscriptions []*User   `json:"-" gorm:"many2many:subscriptions;"`
+	AuthorName     string    `json:"authorName"`
+	AuthorEmail    string    `json:"authorEmail"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description"`
+	Categories     string    `json:"categories"`
+	ImageURL       string    `json:"imageURL"`
+	ImageTitle     string    `json:"imageTitle"`
+	Link           string    `json:"link"`
+	FeedLink       string    `json:"feedLink" gorm:"unique"`
+	FeedType       string    `json:"feedType"`
+	FeedVersion    string    `json:"feedVersion"`
+	Language       string    `json:"language"`
+	Updated        time.Time `json:"updated"` // Mirror of gofeed.Feed.UpdatedParsed
+	LastCheck      time.Time `json:"lastCheck"`
+	Added          time.Time `json:"added"`
+	Subscribed     bool      `json:"subscribed"`
+	UpdateSchedule string    `json:"updateSchedule"`                 // Overrides the global schedule if not empty (see update.ParseSchedule)
+	QuietHours     string    `json:"quietHours"`                     // Overrides the global quiet hours if not empty (see update.ParseQuietHours)
+	Group          string    `json:"group" gorm:"column:group_name"` // Folder in which the podcast is organized, nested ones separated by "/"
+	Episodes       []Episode `json:"episodes" gorm:"constraint:OnDelete:CASCADE"`
+	AddedBy        User      `json:"-" gorm:"foreignKey:AddedByID"`
+	AddedByID      uuid.UUID `json:"addedByID" `
+	Subscriptions  []*User   `json:"-" gorm:"many2many:subscriptions;"`
 
 	gorm.Model
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -12,6 +14,17 @@ type QueueEpisode struct {
 	Position  uint      `json:"position"`
 	User      User      `json:"-" gorm:"foreignKey:UserID"`
 	UserID    uuid.UUID `json:"userID"`
+	// When the episode was added to the queue or, once removed, when it was removed, according to the client that did
+	// the change. Used to discard the older changes that are synced later.
+	ChangedAt time.Time `json:"changedAt"`
 
 	gorm.Model
 }
+
+func (qe *QueueEpisode) BeforeCreate(tx *gorm.DB) (err error) {
+	if qe.ChangedAt.IsZero() {
+		qe.ChangedAt = time.Now()
+	}
+
+	return
+}
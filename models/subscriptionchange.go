@@ -0,0 +1,23 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionAdded   = "add"
+	SubscriptionRemoved = "remove"
+)
+
+// SubscriptionChange is a change on the subscriptions of a user, used to let the clients sync them incrementally.
+type SubscriptionChange struct {
+	UserID    uuid.UUID `json:"-" gorm:"index"`
+	User      User      `json:"-" gorm:"foreignKey:UserID"`
+	DeviceID  string    `json:"device"`
+	FeedLink  string    `json:"feedLink"`
+	Action    string    `json:"action"`                 // SubscriptionAdded or SubscriptionRemoved
+	Timestamp int64     `json:"timestamp" gorm:"index"` // Unix time at which the change was received
+
+	gorm.Model
+}
@@ -1,12 +1,25 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
 	"gorm.io/gorm"
 )
 
+// Parameters used to derive the hash of the passwords (argon2id).
+const (
+	passwordTime    = 1
+	passwordMemory  = 64 * 1024
+	passwordThreads = 4
+	passwordKeyLen  = 32
+	passwordSaltLen = 16
+)
+
 type User struct {
 	ID              uuid.UUID         `json:"id" gorm:"type:char(36);primary_key"`
 	Username        string            `json:"username" gorm:"unique"`
@@ -29,3 +42,35 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	u.ID = uuid.New()
 	return
 }
+
+// SetPassword generates a new salt and stores the hash of the given password.
+func (u *User) SetPassword(password string) error {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	u.PasswordSalt = base64.StdEncoding.EncodeToString(salt)
+	u.PasswordHash = base64.StdEncoding.EncodeToString(hashPassword(password, salt))
+
+	return nil
+}
+
+// CheckPassword reports whether the given password matches the one stored for the user.
+func (u *User) CheckPassword(password string) bool {
+	salt, err := base64.StdEncoding.DecodeString(u.PasswordSalt)
+	if err != nil || len(salt) == 0 {
+		return false
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(u.PasswordHash)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(hash, hashPassword(password, salt)) == 1
+}
+
+func hashPassword(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, passwordTime, passwordMemory, passwordThreads, passwordKeyLen)
+}
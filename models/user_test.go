@@ -0,0 +1,24 @@
+package models
+
+import (
+	"testing"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestUserPassword(t *testing.T) {
+	assert := assert2.New(t)
+
+	var u User
+
+	if !assert.NoError(u.SetPassword("correct horse battery staple"), "the password should be hashed without errors") {
+		return
+	}
+
+	assert.NotEqual("correct horse battery staple", u.PasswordHash, "the password shouldn't be stored in plain text")
+	assert.True(u.CheckPassword("correct horse battery staple"), "the stored password should be accepted")
+	assert.False(u.CheckPassword("wrong password"), "a different password should be rejected")
+
+	var empty User
+	assert.False(empty.CheckPassword(""), "a user without password should reject any password")
+}
@@ -0,0 +1,166 @@
+package opml
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/joomcode/errorx"
+)
+
+// GroupSeparator is used to join the names of nested folders into the name of a group.
+const GroupSeparator = "/"
+
+// Document is the representation of an OPML document.
+type Document struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+// Head is the head of an OPML document.
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+// Body is the body of an OPML document.
+type Body struct {
+	Outlines []Outline `xml:"outline"`
+}
+
+// Outline is an element of an OPML document. It can be a feed (if XMLURL is set) or a folder containing other
+// outlines.
+type Outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	Outlines []Outline `xml:"outline"`
+}
+
+// Feed is a feed referenced by an OPML document.
+type Feed struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	SiteURL string `json:"siteURL"`
+	// Group is the path of folders that contain the feed, separated by GroupSeparator. Empty if the feed is not
+	// inside a folder.
+	Group string `json:"group"`
+}
+
+// Parse reads an OPML document from `r` and returns all the feeds that it references, including the ones inside
+// nested outlines.
+// Possible errors:
+//   - errorx.IllegalFormat: if the document can't be parsed.
+func Parse(r io.Reader) ([]Feed, error) {
+	var doc Document
+
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, errorx.IllegalFormat.Wrap(err, "the OPML document can't be parsed")
+	}
+
+	var feeds []Feed
+	for _, o := range doc.Body.Outlines {
+		feeds = collect(feeds, o, nil)
+	}
+
+	return feeds, nil
+}
+
+// collect appends to `feeds` the feed referenced by the outline `o` and the ones inside it. `folders` is the path of
+// folders that contain `o`.
+func collect(feeds []Feed, o Outline, folders []string) []Feed {
+	title := strings.TrimSpace(o.Title)
+	if title == "" {
+		title = strings.TrimSpace(o.Text)
+	}
+
+	if u := strings.TrimSpace(o.XMLURL); u != "" {
+		feeds = append(feeds, Feed{
+			Title:   title,
+			URL:     u,
+			SiteURL: strings.TrimSpace(o.HTMLURL),
+			Group:   strings.Join(folders, GroupSeparator),
+		})
+	}
+
+	if len(o.Outlines) == 0 {
+		return feeds
+	}
+
+	// Only outlines that are not feeds are considered folders.
+	path := folders
+	if o.XMLURL == "" && title != "" {
+		path = append(append([]string{}, folders...), title)
+	}
+
+	for _, child := range o.Outlines {
+		feeds = collect(feeds, child, path)
+	}
+
+	return feeds
+}
+
+// Write writes to `w` an OPML 2.0 document with the given title, referencing all the given feeds. The feeds that
+// belong to a group are placed inside folders (nested if the name of the group contains GroupSeparator).
+func Write(w io.Writer, title string, feeds []Feed) error {
+	doc := Document{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+	}
+
+	root := &Outline{}
+
+	for _, f := range feeds {
+		parent := root
+
+		for _, name := range strings.Split(f.Group, GroupSeparator) {
+			if name = strings.TrimSpace(name); name != "" {
+				parent = folder(parent, name)
+			}
+		}
+
+		parent.Outlines = append(parent.Outlines, Outline{
+			Text:    f.Title,
+			Title:   f.Title,
+			Type:    "rss",
+			XMLURL:  f.URL,
+			HTMLURL: f.SiteURL,
+		})
+	}
+
+	doc.Body.Outlines = root.Outlines
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return errorx.ExternalError.Wrap(err, "the OPML document can't be written")
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(&doc); err != nil {
+		return errorx.ExternalError.Wrap(err, "the OPML document can't be written")
+	}
+
+	return nil
+}
+
+// folder returns the folder with the given name inside `parent`, creating it if it doesn't exist.
+func folder(parent *Outline, name string) *Outline {
+	for i := range parent.Outlines {
+		if o := &parent.Outlines[i]; o.XMLURL == "" && o.Text == name {
+			return o
+		}
+	}
+
+	parent.Outlines = append(parent.Outlines, Outline{Text: name, Title: name})
+
+	return &parent.Outlines[len(parent.Outlines)-1]
+}
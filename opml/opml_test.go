@@ -0,0 +1,80 @@
+package opml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+const sampleDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Go Time" type="rss" xmlUrl="https://changelog.com/gotime/feed" htmlUrl="https://changelog.com/gotime"/>
+    <outline text="Tech">
+      <outline text="Rustacean Station" title="Rustacean Station" type="rss" xmlUrl="https://rustacean-station.org/podcast.rss"/>
+      <outline text="Python">
+        <outline text="Real Python" type="rss" xmlUrl=" https://realpython.com/podcasts/rpp/feed "/>
+      </outline>
+    </outline>
+    <outline text="Empty folder"/>
+  </body>
+</opml>`
+
+func TestParse(t *testing.T) {
+	assert := assert2.New(t)
+
+	feeds, err := Parse(strings.NewReader(sampleDocument))
+	if !assert.NoError(err, "a valid document should be parsed without errors") {
+		return
+	}
+
+	expected := []Feed{
+		{Title: "Go Time", URL: "https://changelog.com/gotime/feed", SiteURL: "https://changelog.com/gotime"},
+		{Title: "Rustacean Station", URL: "https://rustacean-station.org/podcast.rss", Group: "Tech"},
+		{Title: "Real Python", URL: "https://realpython.com/podcasts/rpp/feed", Group: "Tech/Python"},
+	}
+
+	assert.Equal(expected, feeds, "all the feeds should be returned, with the folders that contain them as group")
+}
+
+func TestParseInvalid(t *testing.T) {
+	assert := assert2.New(t)
+
+	_, err := Parse(strings.NewReader("this is not an OPML document"))
+
+	if assert.Error(err, "an invalid document should return an error") {
+		assert.True(errorx.IsOfType(err, errorx.IllegalFormat), "the error should be of type IllegalFormat")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	assert := assert2.New(t)
+
+	feeds := []Feed{
+		{Title: "Go Time", URL: "https://changelog.com/gotime/feed", SiteURL: "https://changelog.com/gotime"},
+		{Title: "Rustacean Station", URL: "https://rustacean-station.org/podcast.rss", Group: "Tech"},
+		{Title: "Real Python", URL: "https://realpython.com/podcasts/rpp/feed", Group: "Tech/Python"},
+		{Title: "Despeja la X", URL: "https://www.ivoox.com/despeja-x.xml", Group: "Science"},
+	}
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, "LinCast subscriptions", feeds)
+	if !assert.NoError(err, "the document should be written without errors") {
+		return
+	}
+
+	assert.True(strings.HasPrefix(buf.String(), "<?xml"), "the document should start with the XML header")
+	assert.Contains(buf.String(), `<opml version="2.0">`, "the document should be an OPML 2.0 document")
+
+	parsed, err := Parse(&buf)
+	if !assert.NoError(err, "the written document should be parsed without errors") {
+		return
+	}
+
+	assert.ElementsMatch(feeds, parsed, "the written document should contain the same feeds and groups")
+}
@@ -1,6 +1,10 @@
 package podcasts
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -10,16 +14,42 @@ import (
 	"github.com/mmcdole/gofeed"
 	ext "github.com/mmcdole/gofeed/extensions"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+var (
+	// UnreachableError is the type of the errors returned when a feed can't be fetched (e.g. the host can't be reached
+	// or the response is not successful). It's a subtype of errorx.ExternalError.
+	UnreachableError = errorx.ExternalError.NewSubtype("unreachable")
+	// NotAFeedError is the type of the errors returned when the content obtained from an URL can't be parsed as a
+	// feed. It's a subtype of errorx.ExternalError.
+	NotAFeedError = errorx.ExternalError.NewSubtype("not_a_feed")
+)
+
+// feedTimeout is the maximum time that the request of a feed can take, including the download of its body.
+const feedTimeout = time.Minute * 2
+
+// feedClient is the client that fetches the feeds. Its requests are traced when tracing is set up.
+var feedClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: feedTimeout}
+
 // GetPodcastData returns the data from the feed's URL, doing the parsing of the feed itself (into a struct of type *gofeed.Feed) and the podcast.
+// The request is cancelled when `ctx` is done.
 // Possible errors:
-// 	- errorx.ExternalError: if the request to `feedURL` or the parsing of the response fails.
-func GetPodcastData(feedURL string) (parsedPodcast *models.Podcast, originalFeed *gofeed.Feed, err error) {
+// 	- errorx.ExternalError: if the request to `feedURL` or the parsing of the response fails. More specifically,
+// 	UnreachableError or NotAFeedError.
+func GetPodcastData(ctx context.Context, feedURL string) (parsedPodcast *models.Podcast, originalFeed *gofeed.Feed, err error) {
 	parser := gofeed.NewParser()
-	feed, err := parser.ParseURL(feedURL)
+	parser.Client = feedClient
+	feed, err := parser.ParseURLWithContext(feedURL, ctx)
 	if err != nil {
-		return nil, nil, errorx.ExternalError.New("the feed can't be obtained/parsed")
+		var httpErr gofeed.HTTPError
+		var urlErr *url.Error
+
+		if errors.As(err, &httpErr) || errors.As(err, &urlErr) {
+			return nil, nil, UnreachableError.Wrap(err, "the feed can't be obtained")
+		}
+
+		return nil, nil, NotAFeedError.Wrap(err, "the feed can't be parsed")
 	}
 
 	now := time.Now()
@@ -28,6 +58,14 @@ func GetPodcastData(feedURL string) (parsedPodcast *models.Podcast, originalFeed
 		feed.UpdatedParsed = new(time.Time)
 	}
 
+	if feed.Author == nil {
+		feed.Author = new(gofeed.Person)
+	}
+
+	if feed.Image == nil {
+		feed.Image = new(gofeed.Image)
+	}
+
 	p := &models.Podcast{
 		// Subscribed:  false,
 		AuthorName:  feed.Author.Name,
@@ -50,21 +88,44 @@ func GetPodcastData(feedURL string) (parsedPodcast *models.Podcast, originalFeed
 	return p, feed, nil
 }
 
+// ParseWarning describes an item of a feed that has been skipped during the parsing of the episodes.
+type ParseWarning struct {
+	GUID    string `json:"guid"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
 // GetEpisodes returns the episodes (struct Episodes) of the given Podcast.
 // Possible errors:
 // 	- errorx.ExternalError: if the request to `p.FeedLink` or the parsing of the response fails.
 func GetEpisodes(feed *gofeed.Feed) (*[]models.Episode, error) {
+	episodes, _, err := GetEpisodesWithWarnings(feed)
+
+	return episodes, err
+}
+
+// GetEpisodesWithWarnings does the same as GetEpisodes, but also returns a warning for each item of the feed that has
+// been skipped (e.g. because it doesn't have enclosures).
+func GetEpisodesWithWarnings(feed *gofeed.Feed) (*[]models.Episode, []ParseWarning, error) {
 	var episodes []models.Episode
+	var warnings []ParseWarning
 
 	for _, item := range feed.Items {
 		if len(item.Enclosures) == 0 {
+			err := errorx.DataUnavailable.New("the episode (GUID '%s') doesn't have enclosures", item.GUID)
+
 			log.WithFields(log.Fields{
 				"podcastFeed": feed.FeedLink,
 				"episodeGUID": item.GUID,
-				"error": errorx.DataUnavailable.New("the episode (GUID '%s') doesn't have"+
-					" enclosures", item.GUID),
+				"error":       err,
 			}).Error("Episode with no enclosures")
 
+			warnings = append(warnings, ParseWarning{
+				GUID:    item.GUID,
+				Title:   item.Title,
+				Message: "the episode doesn't have enclosures",
+			})
+
 			continue
 		}
 
@@ -115,20 +176,20 @@ func GetEpisodes(feed *gofeed.Feed) (*[]models.Episode, error) {
 	//	return episodes[i].Published.Before(*episodes[j].Published)
 	//})
 
-	return &episodes, nil
+	return &episodes, warnings, nil
 }
 
-/*
-func isValidURL(url1 string) (bool, url.URL) {
-	if _, err := url.ParseRequestURI(url1); err != nil {
-		return false, url.URL{}
+// IsValidURL reports whether the given string is an absolute URL that can be used to fetch a feed (scheme http or
+// https, and a host).
+func IsValidURL(rawURL string) bool {
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return false
 	}
 
-	u, err := url.Parse(url1)
-	if err != nil || u.Scheme == "" || u.Host == "" {
-		return false, url.URL{}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
 	}
 
-	return true, *u
+	return u.Scheme == "http" || u.Scheme == "https"
 }
-*/
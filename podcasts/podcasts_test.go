@@ -1,10 +1,14 @@
 package podcasts
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
 	"github.com/joomcode/errorx"
+	"github.com/mmcdole/gofeed"
 	assert2 "github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -30,7 +34,10 @@ func (s *PodcastsTestSuite) TestGetPodcast() {
 	assert := assert2.New(s.T())
 
 	for _, feed := range s.sampleFeeds {
-		p, originalFeed, err := GetPodcastData(feed)
+		p, originalFeed, err := GetPodcastData(context.Background(), feed)
+		if errorx.IsOfType(err, UnreachableError) {
+			s.T().Skipf("the feed '%s' can't be reached: %v", feed, err)
+		}
 
 		assert.NoErrorf(err, "the podcast should be created without errors (feed %s)", feed)
 		assert.NotNil(p, "the struct returned should contain the info of the podcast and"+
@@ -40,7 +47,7 @@ func (s *PodcastsTestSuite) TestGetPodcast() {
 	}
 
 	wrongURL := "something-wrong.com"
-	p, _, err := GetPodcastData(wrongURL)
+	p, _, err := GetPodcastData(context.Background(), wrongURL)
 
 	if assert.Error(err, "if the passed url is incorrect an error should be returned") {
 		assert.True(errorx.IsOfType(err, errorx.ExternalError), "the error should be of type "+
@@ -49,7 +56,7 @@ func (s *PodcastsTestSuite) TestGetPodcast() {
 	assert.Nil(p, "the returned struct should be nil")
 
 	wrongURL = "http://localhost:8080"
-	p, _, err = GetPodcastData(wrongURL)
+	p, _, err = GetPodcastData(context.Background(), wrongURL)
 
 	if assert.Error(err, "if there is a problem with the request an error should be returned") {
 		assert.True(errorx.IsOfType(err, errorx.ExternalError), "the returned error should be of type "+
@@ -62,7 +69,10 @@ func (s *PodcastsTestSuite) TestGetEpisodes() {
 	assert := assert2.New(s.T())
 
 	for _, feed := range s.sampleFeeds {
-		_, originalFeed, err := GetPodcastData(feed)
+		_, originalFeed, err := GetPodcastData(context.Background(), feed)
+		if errorx.IsOfType(err, UnreachableError) {
+			s.T().Skipf("the feed '%s' can't be reached: %v", feed, err)
+		}
 		if err != nil {
 			panic(errorx.Decorate(err, "the feed '%s' can't be obtained", feed))
 		}
@@ -78,6 +88,61 @@ func (s *PodcastsTestSuite) TestGetEpisodes() {
 	}
 }
 
+func (s *PodcastsTestSuite) TestGetEpisodesWithWarnings() {
+	assert := assert2.New(s.T())
+
+	feed := &gofeed.Feed{
+		Items: []*gofeed.Item{
+			{GUID: "1", Title: "With enclosure", Enclosures: []*gofeed.Enclosure{{URL: "https://example.com/1.mp3"}}},
+			{GUID: "2", Title: "Without enclosure"},
+		},
+	}
+
+	eps, warnings, err := GetEpisodesWithWarnings(feed)
+
+	assert.NoError(err, "episodes should be obtained without errors")
+	assert.Len(*eps, 1, "items without enclosures should be skipped")
+	if assert.Len(warnings, 1, "a warning should be returned for each skipped item") {
+		assert.Equal("2", warnings[0].GUID)
+		assert.Equal("Without enclosure", warnings[0].Title)
+	}
+}
+
+func (s *PodcastsTestSuite) TestGetPodcastDataErrors() {
+	assert := assert2.New(s.T())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><body>Not a feed</body></html>"))
+	}))
+	defer server.Close()
+
+	_, _, err := GetPodcastData(context.Background(), server.URL+"/missing")
+	assert.True(errorx.IsOfType(err, UnreachableError), "a feed that can't be fetched should return an "+
+		"UnreachableError")
+	assert.True(errorx.IsOfType(err, errorx.ExternalError), "the error should be an ExternalError too")
+
+	_, _, err = GetPodcastData(context.Background(), server.URL+"/page.html")
+	assert.True(errorx.IsOfType(err, NotAFeedError), "a page that is not a feed should return a NotAFeedError")
+}
+
+func (s *PodcastsTestSuite) TestIsValidURL() {
+	assert := assert2.New(s.T())
+
+	assert.True(IsValidURL("https://changelog.com/gotime/feed"), "an absolute https URL should be valid")
+	assert.True(IsValidURL("http://localhost:8080/feed.xml"), "an absolute http URL should be valid")
+
+	for _, u := range []string{"", "abc123", "something-wrong.com", "/feed.xml", "ftp://example.com/feed", "https://"} {
+		assert.Falsef(IsValidURL(u), "'%s' should not be a valid URL", u)
+	}
+}
+
 func (s *PodcastsTestSuite) AfterTest(_, _ string) {}
 
 func (s *PodcastsTestSuite) TearDownTest() {}
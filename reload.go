@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+
+	"lincast/api"
+	"lincast/config"
+	"lincast/update"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloader applies the changes of the configuration to the running components of LinCast when it's reloaded.
+type reloader struct {
+	cfg       *config.Config // Configuration in use, with the changes applied by the reloads
+	queue     *update.UpdateQueue
+	scheduler *update.Scheduler
+	server    *api.Server
+}
+
+// reload loads the configuration again and applies the changes of the settings that can be changed without restarting
+// (the ones tagged with `reload`). The changes of the rest of settings are logged, but not applied, so they are
+// reported again on the next reloads until LinCast is restarted. If the new configuration is not valid, nothing is
+// applied. The .env file is not read again, since its variables are already set on the environment.
+func (r *reloader) reload() {
+	log.Info("Reloading the configuration")
+
+	cfg, err := config.Load(flag.CommandLine)
+	if err != nil {
+		log.WithField("problems", config.Problems(err)).Error("The new configuration is not valid, keeping the current one")
+
+		return
+	}
+
+	changes := cfg.Diff(r.cfg)
+	if len(changes) == 0 {
+		log.Info("Configuration reloaded, there are no changes")
+
+		return
+	}
+
+	applied := *r.cfg
+	var pending int
+
+	for _, c := range changes {
+		fields := log.Fields{
+			"setting": c.Key,
+			"old":     c.Old,
+			"new":     c.New,
+		}
+
+		if !c.Reload {
+			pending++
+
+			log.WithFields(fields).Warning("The setting has changed, but LinCast should be restarted to apply it")
+
+			continue
+		}
+
+		log.WithFields(fields).Info("Applying the new value of the setting")
+	}
+
+	if cfg.Logging.Level != applied.Logging.Level {
+		// The level has already been validated along with the rest of the configuration.
+		if level, err := log.ParseLevel(cfg.Logging.Level); err == nil {
+			log.SetLevel(level)
+			applied.Logging.Level = cfg.Logging.Level
+		}
+	}
+
+	if cfg.Updater.Frequency != applied.Updater.Frequency || cfg.Updater.Schedule != applied.Updater.Schedule ||
+		cfg.Updater.QuietHours != applied.Updater.QuietHours {
+		if err := r.setSchedule(cfg.Updater); err != nil {
+			log.WithField("error", errorx.EnsureStackTrace(err)).Error("The new update schedule can't be applied")
+		} else {
+			applied.Updater.Frequency = cfg.Updater.Frequency
+			applied.Updater.Schedule = cfg.Updater.Schedule
+			applied.Updater.QuietHours = cfg.Updater.QuietHours
+		}
+	}
+
+	if cfg.Updater.Workers != applied.Updater.Workers {
+		if err := r.queue.SetWorkers(cfg.Updater.Workers); err != nil {
+			log.WithField("error", errorx.EnsureStackTrace(err)).Error("The new number of workers can't be applied")
+		} else {
+			applied.Updater.Workers = cfg.Updater.Workers
+		}
+	}
+
+	if cfg.Updater.JobTimeout != applied.Updater.JobTimeout {
+		if err := r.queue.SetJobTimeout(cfg.Updater.JobTimeout); err != nil {
+			log.WithField("error", errorx.EnsureStackTrace(err)).Error("The new timeout of the update jobs can't be applied")
+		} else {
+			applied.Updater.JobTimeout = cfg.Updater.JobTimeout
+		}
+	}
+
+	if cfg.Limits != applied.Limits {
+		r.server.SetLimits(cfg.Limits)
+		applied.Limits = cfg.Limits
+	}
+
+	if cfg.Server.CORSOrigins != applied.Server.CORSOrigins {
+		r.server.SetCORSOrigins(cfg.Server.CORSOrigins)
+		applied.Server.CORSOrigins = cfg.Server.CORSOrigins
+	}
+
+	r.cfg = &applied
+
+	log.WithFields(log.Fields{
+		"changes":        len(changes),
+		"pendingRestart": pending,
+	}).Info("Configuration reloaded")
+}
+
+// setSchedule applies the global schedule and quiet hours of the updates given by `cfg`.
+func (r *reloader) setSchedule(cfg config.Updater) error {
+	schedule, quietHours, err := parseUpdateSchedule(cfg.Frequency, cfg.Schedule, cfg.QuietHours)
+	if err != nil {
+		return err
+	}
+
+	return r.scheduler.SetSchedule(schedule, quietHours)
+}
@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"time"
+
+	"lincast/models"
+
+	"gorm.io/gorm"
+)
+
+type EpisodeRepository interface {
+	UpdateProgress(id uint, progress time.Duration, changedAt time.Time) (bool, error)
+	UpdatePlayed(id uint, played bool, changedAt time.Time) (bool, error)
+}
+
+type episodeRepository struct {
+	db *gorm.DB
+}
+
+func NewEpisodeRepository(db *gorm.DB) EpisodeRepository {
+	return &episodeRepository{
+		db,
+	}
+}
+
+// UpdateProgress sets the progress of the episode with the given ID to the one recorded by a client at `changedAt`,
+// unless the stored progress comes from a more recent change. Returns false if the progress hasn't been updated,
+// because of that or because the episode doesn't exist.
+// Possible errors:
+//   - Any error returned by the database.
+func (er *episodeRepository) UpdateProgress(id uint, progress time.Duration, changedAt time.Time) (bool, error) {
+	return er.updateIfNewer(id, "progress_changed_at", changedAt, map[string]interface{}{"current_progress": progress})
+}
+
+// UpdatePlayed sets the played state of the episode with the given ID to the one recorded by a client at
+// `changedAt`, unless the stored state comes from a more recent change. Returns false if the state hasn't been
+// updated, because of that or because the episode doesn't exist.
+// Possible errors:
+//   - Any error returned by the database.
+func (er *episodeRepository) UpdatePlayed(id uint, played bool, changedAt time.Time) (bool, error) {
+	return er.updateIfNewer(id, "played_changed_at", changedAt, map[string]interface{}{"played": played})
+}
+
+// updateIfNewer applies the updates to the episode and sets the column `changedColumn` to `changedAt`, if the
+// column is not more recent. The condition is part of the UPDATE, so concurrent changes can't overwrite newer ones.
+func (er *episodeRepository) updateIfNewer(id uint, changedColumn string, changedAt time.Time, updates map[string]interface{}) (bool, error) {
+	// SQLite stores the times as text, so all of them must be on the same time zone to be compared.
+	changedAt = changedAt.UTC()
+	updates[changedColumn] = changedAt
+
+	res := er.db.Model(&models.Episode{}).
+		Where("id = ?", id).
+		Where(changedColumn+" IS NULL OR "+changedColumn+" <= ?", changedAt).
+		Updates(updates)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected != 0, nil
+}
@@ -0,0 +1,88 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"lincast/models"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestUpdateProgress(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, _, ep := newPodcastFixture(t)
+	repo := NewEpisodeRepository(db)
+
+	changedAt := time.Now()
+
+	updated, err := repo.UpdateProgress(ep.ID, time.Minute, changedAt.In(time.FixedZone("UTC+2", 2*60*60)))
+	if assert.NoError(err) {
+		assert.True(updated, "the first change should be applied")
+	}
+
+	updated, err = repo.UpdateProgress(ep.ID, time.Second, changedAt.Add(-time.Hour).UTC())
+	if assert.NoError(err) {
+		assert.False(updated, "an older change should be discarded, whatever its time zone")
+	}
+
+	updated, err = repo.UpdateProgress(ep.ID, 2*time.Minute, changedAt.Add(time.Second))
+	if assert.NoError(err) {
+		assert.True(updated, "a newer change should be applied")
+	}
+
+	updated, err = repo.UpdatePlayed(ep.ID, false, changedAt.Add(-time.Hour))
+	if assert.NoError(err) {
+		assert.True(updated, "the played state should be compared with its own changes")
+	}
+
+	var stored models.Episode
+	if assert.NoError(db.First(&stored, ep.ID).Error) {
+		assert.Equal(2*time.Minute, stored.CurrentProgress)
+		assert.False(stored.Played)
+	}
+
+	updated, err = repo.UpdateProgress(ep.ID+1, time.Minute, changedAt)
+	if assert.NoError(err) {
+		assert.False(updated, "a missing episode can't be updated")
+	}
+}
+
+func TestUpdateEpisodeProgress(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, _, ep := newPodcastFixture(t)
+	repo := NewEpisodeProgressRepository(db)
+
+	other := models.User{Username: "other", Email: "other"}
+	db.Create(&other)
+
+	changedAt := time.Now()
+
+	updated, err := repo.UpdateProgress(other.ID, ep.ID, 60, changedAt)
+	if assert.NoError(err) {
+		assert.True(updated, "the progress of the user should be created")
+	}
+
+	updated, err = repo.UpdateProgress(other.ID, ep.ID, 30, changedAt.Add(-time.Hour))
+	if assert.NoError(err) {
+		assert.False(updated, "an older change should be discarded")
+	}
+
+	updated, err = repo.UpdatePlayed(other.ID, ep.ID, true, changedAt.Add(-time.Hour))
+	if assert.NoError(err) {
+		assert.True(updated, "the played state should be compared with its own changes")
+	}
+
+	var progress []models.EpisodeProgress
+	if assert.NoError(db.Where("user_id = ?", other.ID).Find(&progress).Error) && assert.Len(progress, 1) {
+		assert.EqualValues(60, progress[0].Progress)
+		assert.True(progress[0].Played)
+	}
+
+	var stored models.Episode
+	if assert.NoError(db.First(&stored, ep.ID).Error) {
+		assert.Zero(stored.CurrentProgress, "the shared episode should not be modified")
+	}
+}
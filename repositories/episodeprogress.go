@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"time"
+
+	"lincast/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type EpisodeProgressRepository interface {
+	UpdateProgress(userID uuid.UUID, episodeID uint, progress uint, changedAt time.Time) (bool, error)
+	UpdatePlayed(userID uuid.UUID, episodeID uint, played bool, changedAt time.Time) (bool, error)
+}
+
+type episodeProgressRepository struct {
+	db *gorm.DB
+}
+
+func NewEpisodeProgressRepository(db *gorm.DB) EpisodeProgressRepository {
+	return &episodeProgressRepository{
+		db,
+	}
+}
+
+// UpdateProgress sets the progress (in seconds) of the user on the episode with the given ID to the one recorded by
+// a client at `changedAt`, unless the stored progress comes from a more recent change. The progress of the user is
+// created if there is not any. Returns false if the progress hasn't been updated.
+// Possible errors:
+//   - Any error returned by the database.
+func (pr *episodeProgressRepository) UpdateProgress(userID uuid.UUID, episodeID uint, progress uint, changedAt time.Time) (bool, error) {
+	return pr.updateIfNewer(userID, episodeID, "progress_changed_at", changedAt, map[string]interface{}{"progress": progress})
+}
+
+// UpdatePlayed sets the played state of the episode with the given ID for the user to the one recorded by a client
+// at `changedAt`, unless the stored state comes from a more recent change. The progress of the user is created if
+// there is not any. Returns false if the state hasn't been updated.
+// Possible errors:
+//   - Any error returned by the database.
+func (pr *episodeProgressRepository) UpdatePlayed(userID uuid.UUID, episodeID uint, played bool, changedAt time.Time) (bool, error) {
+	return pr.updateIfNewer(userID, episodeID, "played_changed_at", changedAt, map[string]interface{}{"played": played})
+}
+
+// updateIfNewer applies the updates to the progress of the user on the episode and sets the column `changedColumn` to
+// `changedAt`, if the column is not more recent. If the user has no progress on the episode, it's created with the
+// updates.
+func (pr *episodeProgressRepository) updateIfNewer(userID uuid.UUID, episodeID uint, changedColumn string, changedAt time.Time, updates map[string]interface{}) (bool, error) {
+	// SQLite stores the times as text, so all of them must be on the same time zone to be compared.
+	changedAt = changedAt.UTC()
+	updates[changedColumn] = changedAt
+
+	updated := false
+
+	err := pr.db.Transaction(func(tx *gorm.DB) error {
+		var progress models.EpisodeProgress
+
+		res := tx.Select("id").Where("user_id = ? AND episode_id = ?", userID, episodeID).Limit(1).Find(&progress)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			progress = models.EpisodeProgress{UserID: userID, EpisodeID: episodeID}
+			if err := tx.Create(&progress).Error; err != nil {
+				return err
+			}
+		}
+
+		res = tx.Model(&models.EpisodeProgress{}).
+			Where("id = ?", progress.ID).
+			Where(changedColumn+" IS NULL OR "+changedColumn+" <= ?", changedAt).
+			Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		updated = res.RowsAffected != 0
+
+		return nil
+	})
+
+	return updated, err
+}
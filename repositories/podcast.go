@@ -12,7 +12,7 @@ type PodcastRepository interface {
 	GetByFeed(feedUrl string) (*models.Podcast, error)
 	Create(podcast models.Podcast) error
 	Update(podcast models.Podcast) error
-	Delete(id uint) error
+	Delete(id uint, keepHistory bool) error
 	UpdateSubscriptionStatus(userID uuid.UUID, podcastID uint, subscribed bool) error
 }
 
@@ -52,12 +52,60 @@ func (pr *podcastRepository) Update(podcast models.Podcast) error {
 	return nil
 }
 
-func (pr *podcastRepository) Delete(id uint) error {
-	if err := pr.db.Delete(&models.Podcast{}, id).Error; err != nil {
-		return err
-	}
-
-	return nil
+// Delete deletes the podcast with the given ID, along with the subscriptions of the users to it and the queue entries
+// and playback info of its episodes, in a single transaction. If keepHistory is true, the podcast and its episodes are
+// archived (soft-deleted) keeping their played state and progress, which are restored if the podcast is subscribed
+// again. Otherwise, they are removed for good, including the listening history.
+// Possible errors:
+//   - gorm.ErrRecordNotFound: if the podcast doesn't exist.
+//   - Any error returned by the database.
+func (pr *podcastRepository) Delete(id uint, keepHistory bool) error {
+	return pr.db.Transaction(func(tx *gorm.DB) error {
+		var p models.Podcast
+
+		if err := tx.First(&p, id).Error; err != nil {
+			return err
+		}
+
+		del := tx
+		if !keepHistory {
+			del = tx.Unscoped().Session(&gorm.Session{})
+		}
+
+		episodes := tx.Unscoped().Model(&models.Episode{}).Select("id").Where("podcast_id = ?", id).Session(&gorm.Session{})
+
+		if err := del.Where("episode_id IN (?)", episodes).Delete(&models.QueueEpisode{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("episode_id IN (?)", episodes).Delete(&models.PlaybackInfo{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM subscriptions WHERE podcast_id = ?", id).Error; err != nil {
+			return err
+		}
+
+		if keepHistory {
+			if err := tx.Model(&p).Update("subscribed", false).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := del.Where("episode_id IN (?)", episodes).Delete(&models.EpisodeProgress{}).Error; err != nil {
+				return err
+			}
+
+			if err := del.Where("podcast_url = ?", p.FeedLink).Delete(&models.EpisodeAction{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := del.Where("podcast_id = ?", id).Delete(&models.Episode{}).Error; err != nil {
+			return err
+		}
+
+		return del.Delete(&p).Error
+	})
 }
 
 func (pr *podcastRepository) GetByFeed(feedUrl string) (*models.Podcast, error) {
@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lincast/database"
+	"lincast/models"
+
+	"github.com/google/uuid"
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// newPodcastFixture stores a podcast with an episode that is on the queue, being played, with progress of a user and
+// with an episode action.
+func newPodcastFixture(t *testing.T) (*gorm.DB, models.Podcast, models.Episode) {
+	db, err := database.New(database.Config{Driver: database.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{ID: uuid.New(), Username: "user", Email: "user"}
+	p := models.Podcast{FeedLink: "https://example.com/feed", Subscribed: true}
+	db.Create(&user)
+	db.Create(&p)
+
+	ep := models.Episode{PodcastID: p.ID, GUID: "ep-1", Played: true}
+	db.Create(&ep)
+
+	db.Create(&models.QueueEpisode{EpisodeID: ep.ID, Position: 1})
+	db.Create(&models.PlaybackInfo{EpisodeID: ep.ID})
+	db.Create(&models.EpisodeProgress{EpisodeID: ep.ID, UserID: user.ID, Progress: 60})
+	db.Create(&models.EpisodeAction{UserID: user.ID, PodcastURL: p.FeedLink, Action: "play"})
+	db.Exec("INSERT INTO subscriptions (podcast_id, user_id) VALUES (?, ?)", p.ID, user.ID)
+
+	return db, p, ep
+}
+
+func count(db *gorm.DB, model interface{}) int64 {
+	var n int64
+	db.Model(model).Count(&n)
+
+	return n
+}
+
+func TestDeletePodcast(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, p, _ := newPodcastFixture(t)
+
+	if !assert.NoError(NewPodcastRepository(db).Delete(p.ID, false)) {
+		return
+	}
+
+	for _, m := range []interface{}{&models.Podcast{}, &models.Episode{}, &models.QueueEpisode{}, &models.PlaybackInfo{},
+		&models.EpisodeProgress{}, &models.EpisodeAction{}} {
+		assert.Zero(count(db.Unscoped(), m), "the dependents of the podcast should be removed")
+	}
+
+	assert.Zero(count(db, db.Table("subscriptions")), "the subscriptions to the podcast should be removed")
+}
+
+func TestDeletePodcastKeepHistory(t *testing.T) {
+	assert := assert2.New(t)
+
+	db, p, ep := newPodcastFixture(t)
+
+	if !assert.NoError(NewPodcastRepository(db).Delete(p.ID, true)) {
+		return
+	}
+
+	assert.Zero(count(db, &models.Podcast{}), "the podcast should be archived")
+	assert.Zero(count(db, &models.Episode{}), "the episodes should be archived")
+	assert.Zero(count(db, &models.QueueEpisode{}), "the episodes should be removed from the queue")
+	assert.Zero(count(db, &models.PlaybackInfo{}), "the episodes should not be played anymore")
+	assert.Zero(count(db, db.Table("subscriptions")), "the subscriptions to the podcast should be removed")
+
+	var archived models.Episode
+	if assert.NoError(db.Unscoped().First(&archived, ep.ID).Error, "the archived episode should be kept") {
+		assert.True(archived.Played, "the played state of the episode should be kept")
+	}
+
+	assert.EqualValues(1, count(db, &models.EpisodeProgress{}), "the progress of the episode should be kept")
+	assert.EqualValues(1, count(db, &models.EpisodeAction{}), "the episode actions should be kept")
+}
+
+func TestDeleteMissingPodcast(t *testing.T) {
+	db, p, _ := newPodcastFixture(t)
+
+	assert2.ErrorIs(t, NewPodcastRepository(db).Delete(p.ID+1, false), gorm.ErrRecordNotFound)
+}
@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"time"
+
 	"lincast/models"
 
 	"github.com/google/uuid"
@@ -12,6 +14,7 @@ type QueueRepository interface {
 	Add(queueEpisode models.QueueEpisode) error
 	RemoveEpisode(userID uuid.UUID, queueEpisodeID uint) error
 	RemoveAll(userID uuid.UUID) error
+	RemoveWhere(changedAt time.Time, query interface{}, args ...interface{}) (int64, error)
 }
 
 type queueRepository struct {
@@ -45,13 +48,7 @@ func (qr *queueRepository) Add(queueEpisode models.QueueEpisode) error {
 }
 
 func (qr *queueRepository) RemoveEpisode(userID uuid.UUID, queueEpisodeID uint) error {
-	err := qr.db.Delete(&models.QueueEpisode{
-		Model: gorm.Model{
-			ID: queueEpisodeID,
-		},
-		UserID: userID,
-	}).Error
-
+	_, err := qr.RemoveWhere(time.Now(), "id = ?", queueEpisodeID)
 	if err != nil {
 		return err
 	}
@@ -60,10 +57,27 @@ func (qr *queueRepository) RemoveEpisode(userID uuid.UUID, queueEpisodeID uint)
 }
 
 func (qr *queueRepository) RemoveAll(userID uuid.UUID) error {
-	err := qr.db.Delete(&models.QueueEpisode{}, "user_id = ?", userID).Error
+	_, err := qr.RemoveWhere(time.Now(), "user_id = ?", userID)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// RemoveWhere soft-deletes the entries of the queue that match the conditions, recording that they were removed at
+// `changedAt` (see models.QueueEpisode). Returns the number of entries removed.
+// Possible errors:
+//   - Any error returned by the database.
+func (qr *queueRepository) RemoveWhere(changedAt time.Time, query interface{}, args ...interface{}) (int64, error) {
+	// The entries already removed are excluded, since the model is soft-deleted.
+	res := qr.db.Model(&models.QueueEpisode{}).Where(query, args...).Updates(map[string]interface{}{
+		"deleted_at": time.Now(),
+		"changed_at": changedAt,
+	})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return res.RowsAffected, nil
+}
@@ -16,7 +16,7 @@ func (p *program) Start(s service.Service) error {
 }
 
 func (p *program) run() {
-	run(false)
+	run(loadConfig())
 }
 
 func (p *program) Stop(s service.Service) error {
@@ -0,0 +1,195 @@
+// Package tracing sets up OpenTelemetry to record the spans of the work done by LinCast (requests to the API, queries
+// to the database, feed fetches and update jobs), and to export them to an OTLP collector or to the standard output
+// (see Setup). When tracing is not set up, the spans started with Start do nothing.
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Names of the exporters of the spans.
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// instrumentationName is the name of the tracer of the spans started by LinCast.
+const instrumentationName = "lincast"
+
+// Options are the options of the tracing of LinCast.
+type Options struct {
+	Exporter    string // One of ExporterNone, ExporterOTLP and ExporterStdout
+	Endpoint    string // Base URL of the OTLP/HTTP collector (e.g. http://localhost:4318)
+	ServiceName string
+}
+
+// enabled reports whether the tracing is set up.
+var enabled atomic.Bool
+
+// Enabled reports whether tracing is set up, so the work needed only to record the spans can be skipped otherwise.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Setup sets up the global tracer provider of OpenTelemetry with the given options, along with the propagation of the
+// context of the spans with the headers of the W3C Trace Context recommendation. It returns the function that exports
+// the pending spans and disables the tracing, which should be called before exiting. With ExporterNone, the tracing
+// is disabled.
+// Possible errors:
+//   - errorx.IllegalArgument: if the exporter is not known, or the endpoint of the OTLP collector is not valid.
+//   - errorx.InternalError: if the exporter can't be created.
+func Setup(opts Options) (func(ctx context.Context) error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch opts.Exporter {
+	case ExporterNone:
+		enabled.Store(false)
+
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+
+	case ExporterOTLP:
+		exporter, err = newOTLPExporter(opts.Endpoint)
+
+	default:
+		return nil, errorx.IllegalArgument.New("unknown exporter of the traces '%s' (use '%s' or '%s')", opts.Exporter,
+			ExporterOTLP, ExporterStdout)
+	}
+
+	if err != nil {
+		if errorx.IsOfType(err, errorx.IllegalArgument) {
+			return nil, err
+		}
+
+		return nil, errorx.InternalError.Wrap(err, "the exporter of the traces can't be created")
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(opts.ServiceName))),
+		sdktrace.WithBatcher(redactingExporter{exporter}),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.WithField("error", err).Warning("Error when trying to export the spans")
+	}))
+	enabled.Store(true)
+
+	return func(ctx context.Context) error {
+		enabled.Store(false)
+
+		return tp.Shutdown(ctx)
+	}, nil
+}
+
+// newOTLPExporter returns an exporter that sends the spans to the collector on `endpoint` (e.g.
+// http://localhost:4318) with the OTLP/HTTP protocol, on the path /v1/traces.
+// Possible errors:
+//   - errorx.IllegalArgument: if the endpoint is not an HTTP(S) URL.
+func newOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errorx.IllegalArgument.New("the endpoint of the OTLP collector should be an HTTP(S) URL, got '%s'",
+			endpoint)
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimRight(u.Path, "/") + "/v1/traces"),
+	}
+
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// Start starts a new span with the tracer of LinCast, that is a child of the one carried by `ctx` (or the root of a
+// new trace if there is none), and returns a copy of `ctx` that carries it. The span must be ended with trace.Span.End.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// RecordError marks the operation of the span as failed with the given error. Nil errors are ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// urlKeys are the attributes that hold the URLs requested by the clients of otelhttp.
+var urlKeys = []attribute.Key{semconv.URLFullKey, "http.url"}
+
+// redactingExporter is a span exporter that removes the query from the URLs of the requests before exporting the
+// spans, since the feeds of private podcasts can have their tokens on it.
+type redactingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e redactingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		redacted[i] = redactedSpan{s}
+	}
+
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+// redactedSpan is a span whose URLs are returned without query.
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue {
+	attrs := append([]attribute.KeyValue(nil), s.ReadOnlySpan.Attributes()...)
+
+	for i, kv := range attrs {
+		for _, key := range urlKeys {
+			if kv.Key == key {
+				attrs[i] = key.String(redactURL(kv.Value.AsString()))
+			}
+		}
+	}
+
+	return attrs
+}
+
+// redactURL returns the URL without its query and credentials.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	u.RawQuery, u.User = "", nil
+
+	return u.String()
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	assert2 "github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupRecorder sets up the global tracer provider with an exporter that keeps the spans in memory.
+func setupRecorder(t *testing.T) *tracetest.InMemoryExporter {
+	r := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(redactingExporter{r}))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	return r
+}
+
+func TestSetup(t *testing.T) {
+	assert := assert2.New(t)
+
+	stop, err := Setup(Options{Exporter: ExporterNone})
+	if assert.NoError(err) {
+		assert.False(Enabled(), "the tracing should be disabled without exporter")
+		assert.NoError(stop(context.Background()))
+	}
+
+	_, err = Setup(Options{Exporter: "zipkin"})
+	assert.True(errorx.IsOfType(err, errorx.IllegalArgument), "an unknown exporter should be rejected")
+
+	_, err = Setup(Options{Exporter: ExporterOTLP, Endpoint: "localhost:4318"})
+	assert.True(errorx.IsOfType(err, errorx.IllegalArgument), "the endpoint should be an URL")
+}
+
+func TestStart(t *testing.T) {
+	assert := assert2.New(t)
+
+	r := setupRecorder(t)
+
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	RecordError(child, errors.New("the query failed"))
+	RecordError(parent, nil)
+	child.End()
+	parent.End()
+
+	spans := r.GetSpans()
+	if !assert.Len(spans, 2) {
+		return
+	}
+
+	c, p := spans[0], spans[1]
+	assert.Equal(p.SpanContext.TraceID(), c.SpanContext.TraceID(), "the child should be on the trace of its parent")
+	assert.Equal(p.SpanContext.SpanID(), c.Parent.SpanID())
+	assert.Equal(codes.Error, c.Status.Code)
+	assert.Equal("the query failed", c.Status.Description)
+	assert.Equal(codes.Unset, p.Status.Code, "a nil error should be ignored")
+}
+
+func TestFeedClient(t *testing.T) {
+	assert := assert2.New(t)
+
+	r := setupRecorder(t)
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("traceparent")
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, parent := Start(context.Background(), "update.job")
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/feed.xml?token=secret", nil)
+	res, err := (&http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}).Do(req)
+	if assert.NoError(err) {
+		res.Body.Close()
+	}
+
+	parent.End()
+
+	spans := r.GetSpans()
+	if !assert.Len(spans, 2) {
+		return
+	}
+
+	client := spans[0]
+	assert.Equal(parent.SpanContext().SpanID(), client.Parent.SpanID())
+	assert.Contains(received, client.SpanContext.SpanID().String(), "the context of the span should be sent to the server")
+
+	redacted := false
+	for _, kv := range client.Attributes {
+		if kv.Key == "http.url" || kv.Key == "url.full" {
+			assert.Equal(attribute.StringValue(server.URL+"/feed.xml"), kv.Value, "the query should be left out")
+			redacted = true
+		}
+	}
+
+	assert.True(redacted, "the URL of the request should be recorded")
+}
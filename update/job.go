@@ -0,0 +1,330 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"lincast/models"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// JobStatus represents the state in which a Job is.
+type JobStatus string
+
+const (
+	// JobPending is the status of a job that has not been taken by any worker yet.
+	JobPending JobStatus = "pending"
+	// JobRunning is the status of a job that is being processed by a worker.
+	JobRunning JobStatus = "running"
+	// JobDone is the status of a job that has been processed without errors.
+	JobDone JobStatus = "done"
+	// JobFailed is the status of a job that couldn't be processed.
+	JobFailed JobStatus = "failed"
+)
+
+// JobType represents the kind of work that a Job does.
+type JobType string
+
+const (
+	// JobUpdate is the type of the jobs that fetch the new episodes of a podcast that is already on the database.
+	JobUpdate JobType = "update"
+	// JobSubscribe is the type of the jobs that resolve a feed, store the podcast (if it's not already on the
+	// database), subscribe to it and fetch its episodes.
+	JobSubscribe JobType = "subscribe"
+)
+
+// SubscriptionOutcome describes the result of a job of type JobSubscribe.
+type SubscriptionOutcome string
+
+const (
+	// OutcomeCreated means that the podcast has been stored on the database.
+	OutcomeCreated SubscriptionOutcome = "created"
+	// OutcomeAlreadySubscribed means that the podcast was already on the database.
+	OutcomeAlreadySubscribed SubscriptionOutcome = "already_subscribed"
+	// OutcomeUnreachable means that the feed couldn't be fetched.
+	OutcomeUnreachable SubscriptionOutcome = "unreachable"
+	// OutcomeNotAFeed means that the content of the URL couldn't be parsed as a feed.
+	OutcomeNotAFeed SubscriptionOutcome = "not_a_feed"
+	// OutcomeInvalidURL means that the given URL is not valid, so it hasn't been processed at all.
+	OutcomeInvalidURL SubscriptionOutcome = "invalid_url"
+	// OutcomeError means that the subscription failed due to an unexpected error.
+	OutcomeError SubscriptionOutcome = "error"
+)
+
+// Job returns a new job to be processed by a worker of an active UpdateQueue. The channel Job.Done is closed when
+// that job has been processed, so it shouldn't be used to send something, just to receive.
+type Job struct {
+	ID      uuid.UUID
+	Type    JobType
+	Podcast *models.Podcast
+	Done    chan struct{}
+	// Group is the group in which the podcast should be organized on jobs of type JobSubscribe. If empty, the group
+	// is not modified.
+	Group string
+
+	// feedURL is the URL requested by the client on jobs of type JobSubscribe. It may differ from the one on
+	// Podcast.FeedLink once the feed has been resolved.
+	feedURL string
+	// parent is the context of the span that created the job (e.g. the one of a request), of which the span of the
+	// job is a child.
+	parent trace.SpanContext
+
+	mu          sync.RWMutex
+	status      JobStatus
+	newEpisodes []models.Episode
+	outcome     SubscriptionOutcome
+	err         error
+	createdAt   time.Time
+	finishedAt  time.Time
+}
+
+// NewJob returns a new Job that will update the given podcast.
+func NewJob(p *models.Podcast) *Job {
+	j := Job{
+		ID:        uuid.New(),
+		Type:      JobUpdate,
+		Podcast:   p,
+		Done:      make(chan struct{}),
+		status:    JobPending,
+		createdAt: time.Now(),
+	}
+
+	return &j
+}
+
+// NewSubscriptionJob returns a new Job that will subscribe to the podcast with the given feed.
+func NewSubscriptionJob(feedURL string) *Job {
+	j := NewJob(&models.Podcast{FeedLink: feedURL})
+	j.Type = JobSubscribe
+	j.feedURL = feedURL
+
+	return j
+}
+
+// SetParent makes the span that records the processing of the job a child of the span carried by `ctx`, so the job is
+// part of the trace of the operation that created it. It should be called before sending the job to the queue.
+func (j *Job) SetParent(ctx context.Context) {
+	j.parent = trace.SpanContextFromContext(ctx)
+}
+
+// RequestedURL returns the URL of the feed requested by the client on jobs of type JobSubscribe, or the feed of the
+// podcast to update on the rest.
+func (j *Job) RequestedURL() string {
+	if j.Type == JobSubscribe {
+		return j.feedURL
+	}
+
+	return j.Podcast.FeedLink
+}
+
+// StoredPodcast returns the podcast processed by the job, or nil if it has not been stored on the database yet (e.g. a
+// pending job of type JobSubscribe).
+func (j *Job) StoredPodcast() *models.Podcast {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if j.Podcast == nil || j.Podcast.ID == 0 {
+		return nil
+	}
+
+	return j.Podcast
+}
+
+// Status returns the current status of the job.
+func (j *Job) Status() JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.status
+}
+
+// NewEpisodes returns the episodes stored on the database as result of the job. The returned value is only complete
+// once the job has finished.
+func (j *Job) NewEpisodes() []models.Episode {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.newEpisodes
+}
+
+// Err returns the error that caused the failure of the job, if any.
+func (j *Job) Err() error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.err
+}
+
+// Finished reports whether the job has already been processed (successfully or not).
+func (j *Job) Finished() bool {
+	s := j.Status()
+
+	return s == JobDone || s == JobFailed
+}
+
+// Outcome returns the result of a job of type JobSubscribe. It's empty while the subscription has not been
+// resolved, and always for jobs of other types.
+func (j *Job) Outcome() SubscriptionOutcome {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.outcome
+}
+
+// Wait blocks until the job has been processed or the given context is done. Returns true if the job finished.
+func (j *Job) Wait(ctx context.Context) bool {
+	select {
+	case <-j.Done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// MarshalJSON implements json.Marshaler, exposing a snapshot of the job's state.
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	v := struct {
+		ID          uuid.UUID           `json:"id"`
+		Type        JobType             `json:"type"`
+		PodcastID   uint                `json:"podcastID"`
+		FeedLink    string              `json:"feedLink"`
+		Status      JobStatus           `json:"status"`
+		Outcome     SubscriptionOutcome `json:"outcome,omitempty"`
+		NewEpisodes []models.Episode    `json:"newEpisodes"`
+		Error       string              `json:"error,omitempty"`
+		CreatedAt   time.Time           `json:"createdAt"`
+		FinishedAt  *time.Time          `json:"finishedAt,omitempty"`
+	}{
+		ID:          j.ID,
+		Type:        j.Type,
+		Status:      j.status,
+		Outcome:     j.outcome,
+		NewEpisodes: j.newEpisodes,
+		CreatedAt:   j.createdAt,
+	}
+
+	if j.Podcast != nil {
+		v.PodcastID = j.Podcast.ID
+		v.FeedLink = j.Podcast.FeedLink
+	}
+
+	if v.NewEpisodes == nil {
+		v.NewEpisodes = []models.Episode{}
+	}
+
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		v.FinishedAt = &finishedAt
+	}
+
+	return json.Marshal(&v)
+}
+
+func (j *Job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status = JobRunning
+}
+
+// setPodcast replaces the podcast referenced by the job (e.g. once the podcast of a subscription has been stored).
+func (j *Job) setPodcast(p *models.Podcast) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Podcast = p
+}
+
+func (j *Job) setOutcome(o SubscriptionOutcome) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.outcome = o
+}
+
+func (j *Job) addEpisode(e models.Episode) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.newEpisodes = append(j.newEpisodes, e)
+}
+
+// Reject finishes the job without processing it, because of the given error (e.g. the update queue is full).
+func (j *Job) Reject(err error) {
+	if j.Type == JobSubscribe {
+		j.setOutcome(OutcomeError)
+	}
+
+	j.finish(err)
+}
+
+// finish marks the job as processed and notifies it by closing the channel Job.Done. A nil `err` means that the job
+// has been processed correctly.
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+
+	if err != nil {
+		j.status = JobFailed
+		j.err = err
+	} else {
+		j.status = JobDone
+	}
+
+	j.finishedAt = time.Now()
+
+	j.mu.Unlock()
+
+	close(j.Done)
+}
+
+// Batch groups several jobs that have been requested together (e.g. the update of the entire library).
+type Batch struct {
+	ID   uuid.UUID `json:"id"`
+	Jobs []*Job    `json:"jobs"`
+}
+
+// NewBatch returns a new Batch containing the given jobs.
+func NewBatch(jobs []*Job) *Batch {
+	if jobs == nil {
+		jobs = []*Job{}
+	}
+
+	return &Batch{
+		ID:   uuid.New(),
+		Jobs: jobs,
+	}
+}
+
+// Wait blocks until all the jobs of the batch have been processed or the given context is done. Returns true if all
+// the jobs finished.
+func (b *Batch) Wait(ctx context.Context) bool {
+	for _, j := range b.Jobs {
+		if !j.Wait(ctx) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Finished reports whether all the jobs of the batch have been processed.
+func (b *Batch) Finished() bool {
+	for _, j := range b.Jobs {
+		if !j.Finished() {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,106 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lincast/models"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestJobFinish(t *testing.T) {
+	assert := assert2.New(t)
+
+	j := NewJob(&models.Podcast{FeedLink: "https://example.com/feed"})
+
+	assert.Equal(JobPending, j.Status(), "a new job should be pending")
+	assert.False(j.Finished(), "a new job should not be finished")
+
+	j.start()
+	j.addEpisode(models.Episode{GUID: "abc"})
+	j.finish(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.True(j.Wait(ctx), "waiting for a finished job should return immediately")
+	assert.Equal(JobDone, j.Status(), "the job should be done")
+	assert.Len(j.NewEpisodes(), 1, "the stored episodes should be registered on the job")
+	assert.NoError(j.Err())
+
+	j = NewJob(&models.Podcast{})
+	j.finish(errors.New("unreachable"))
+
+	assert.Equal(JobFailed, j.Status(), "a job finished with an error should be failed")
+	assert.Error(j.Err())
+}
+
+func TestJobWaitTimeout(t *testing.T) {
+	assert := assert2.New(t)
+
+	j := NewJob(&models.Podcast{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	assert.False(j.Wait(ctx), "waiting for a job that never finishes should stop when the context is done")
+}
+
+func TestTracker(t *testing.T) {
+	assert := assert2.New(t)
+
+	tr := NewTracker(time.Hour)
+
+	j := NewJob(&models.Podcast{})
+	tr.AddJob(j)
+
+	got, ok := tr.Job(j.ID)
+	assert.True(ok, "the job should be tracked")
+	assert.Equal(j, got)
+
+	b := NewBatch([]*Job{NewJob(&models.Podcast{}), NewJob(&models.Podcast{})})
+	tr.AddBatch(b)
+
+	_, ok = tr.Batch(b.ID)
+	assert.True(ok, "the batch should be tracked")
+
+	_, ok = tr.Job(b.Jobs[1].ID)
+	assert.True(ok, "the jobs of a batch should be tracked individually too")
+}
+
+func TestTrackerPrune(t *testing.T) {
+	assert := assert2.New(t)
+
+	tr := NewTracker(0)
+
+	j := NewJob(&models.Podcast{})
+	j.finish(nil)
+	tr.AddJob(j)
+
+	// Adding a new job prunes the ones that have expired.
+	tr.AddJob(NewJob(&models.Podcast{}))
+
+	_, ok := tr.Job(j.ID)
+	assert.False(ok, "the finished job should be discarded after the retention time")
+}
+
+func TestTrackerAddSubscriptionJob(t *testing.T) {
+	assert := assert2.New(t)
+
+	tr := NewTracker(time.Hour)
+
+	j, added := tr.AddSubscriptionJob(NewSubscriptionJob("https://example.com/feed"))
+	assert.True(added, "the first job for a feed should be added")
+
+	again, added := tr.AddSubscriptionJob(NewSubscriptionJob("https://example.com/feed"))
+	assert.False(added, "a second job for the same feed should not be added while the first is active")
+	assert.Equal(j.ID, again.ID, "the active job should be returned instead")
+
+	j.finish(nil)
+
+	_, added = tr.AddSubscriptionJob(NewSubscriptionJob("https://example.com/feed"))
+	assert.True(added, "once the previous job has finished, a new one can be added")
+}
@@ -0,0 +1,95 @@
+package update
+
+import (
+	"strconv"
+	"time"
+
+	"lincast/metrics"
+	"lincast/podcasts"
+
+	"github.com/joomcode/errorx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queuePending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lincast_update_queue_pending",
+		Help: "Number of jobs waiting to be taken by a worker of the update queue.",
+	})
+	queueWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lincast_update_queue_workers",
+		Help: "Number of workers of the update queue.",
+	})
+	queueBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lincast_update_queue_busy_workers",
+		Help: "Number of workers of the update queue that are processing a job.",
+	})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lincast_update_job_duration_seconds",
+		Help:    "Time spent processing the update jobs, by type and status.",
+		Buckets: metrics.DefaultBuckets,
+	}, []string{"type", "status"})
+	podcastJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lincast_update_podcast_jobs_total",
+		Help: "Number of update jobs processed, by podcast, type and status.",
+	}, []string{"podcast_id", "type", "status"})
+	podcastLastDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lincast_update_podcast_last_job_duration_seconds",
+		Help: "Time spent processing the last update job of each podcast.",
+	}, []string{"podcast_id"})
+	subscriptionOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lincast_update_subscription_outcomes_total",
+		Help: "Number of subscription jobs processed, by outcome.",
+	}, []string{"outcome"})
+	episodesIngested = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lincast_episodes_ingested_total",
+		Help: "Number of new episodes stored from the feeds.",
+	})
+	feedErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lincast_feed_fetch_errors_total",
+		Help: "Number of errors when trying to obtain the feeds, by class (unreachable, not_a_feed, invalid_episodes " +
+			"or other).",
+	}, []string{"class"})
+)
+
+// Classes of the errors when trying to obtain the feeds, used on the metrics.
+const (
+	errorClassUnreachable     = "unreachable"
+	errorClassNotAFeed        = "not_a_feed"
+	errorClassInvalidEpisodes = "invalid_episodes"
+	errorClassOther           = "other"
+)
+
+// fetchErrorClass returns the class of an error returned by podcasts.GetPodcastData.
+func fetchErrorClass(err error) string {
+	switch {
+	case errorx.IsOfType(err, podcasts.UnreachableError):
+		return errorClassUnreachable
+	case errorx.IsOfType(err, podcasts.NotAFeedError):
+		return errorClassNotAFeed
+	default:
+		return errorClassOther
+	}
+}
+
+// recordJob records the metrics of a job that has been processed in `d`.
+func recordJob(job *Job, d time.Duration) {
+	status := string(job.Status())
+
+	jobDuration.WithLabelValues(string(job.Type), status).Observe(d.Seconds())
+
+	// The podcasts of the subscription jobs that failed have not been stored, so they don't have an ID.
+	if p := job.StoredPodcast(); p != nil {
+		id := strconv.FormatUint(uint64(p.ID), 10)
+
+		podcastJobs.WithLabelValues(id, string(job.Type), status).Inc()
+		podcastLastDuration.WithLabelValues(id).Set(d.Seconds())
+	}
+
+	if job.Type == JobSubscribe {
+		if outcome := job.Outcome(); outcome != "" {
+			subscriptionOutcomes.WithLabelValues(string(outcome)).Inc()
+		}
+	}
+}
@@ -1,182 +1,545 @@
 package update
 
 import (
-	"errors"
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"lincast/models"
 	"lincast/podcasts"
+	"lincast/tracing"
 
 	"github.com/joomcode/errorx"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
-// Job returns a new job to be processed by a worker of an active UpdateQueue. The channel Job.Done can be used to know
-// when that job has been processed and it shouldn't be used to send something, just to receive.
-type Job struct {
-	Podcast *models.Podcast
-	Done    chan struct{}
-}
+// MaxWorkers is the maximum number of workers of an UpdateQueue.
+const MaxWorkers = 64
+
+// episodesPerInsert is the maximum number of new episodes stored by each insert. The inserts of a job are throttled,
+// so the episodes of the large feeds are stored in batches.
+const episodesPerInsert = 200
+
+// DefaultJobTimeout is the maximum time that a job can take by default (see SetJobTimeout).
+const DefaultJobTimeout = 5 * time.Minute
 
 type UpdateQueue struct {
 	dbInstance *gorm.DB
-	q          chan Job
+	q          chan *Job
+
+	mu       sync.Mutex
+	workers  []chan struct{} // Closed to stop each worker, in order of creation
+	workerID int             // ID of the next worker
+	stopped  bool
+	done     chan struct{} // Closed when the queue is stopped
+
+	running sync.WaitGroup // Workers that have not returned yet, including the ones stopped that are finishing a job
+	alive   atomic.Int32   // Same as running, but readable
+	busy    atomic.Int32   // Workers that are processing a job
+
+	jobTimeout atomic.Int64 // Maximum duration of each job, 0 if there is no limit
 }
 
+// NewUpdateQueue returns a new UpdateQueue whose jobs are processed by `length` workers (see SetWorkers).
 func NewUpdateQueue(db *gorm.DB, length int) (*UpdateQueue, error) {
-	if length < 1 {
-		return nil, errorx.IllegalArgument.New("the length of the queue should be at least 1")
-	}
-
 	if db == nil {
 		return nil, errorx.IllegalState.New("the instance of the database is nil")
 	}
 
 	q := UpdateQueue{
-		q:          make(chan Job),
+		q:          make(chan *Job),
 		dbInstance: db,
+		done:       make(chan struct{}),
 	}
+	q.jobTimeout.Store(int64(DefaultJobTimeout))
 
-	for i := 0; i < length; i++ {
-		go q.worker(i)
+	if err := q.SetWorkers(length); err != nil {
+		return nil, err
 	}
 
 	return &q, nil
 }
 
-func (q *UpdateQueue) Send(job *Job) {
-	q.q <- *job
+// SetWorkers changes the number of workers that process the jobs, starting new ones or stopping the last ones started.
+// The workers that are stopped finish the job that they are processing.
+// Possible errors:
+//   - errorx.IllegalArgument: if `n` is lower than 1 or greater than MaxWorkers.
+//   - errorx.IllegalState: if the queue has been stopped.
+func (q *UpdateQueue) SetWorkers(n int) error {
+	if n < 1 || n > MaxWorkers {
+		return errorx.IllegalArgument.New("the number of workers should be between 1 and %d", MaxWorkers)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return errorx.IllegalState.New("the queue has been stopped")
+	}
+
+	for len(q.workers) < n {
+		stop := make(chan struct{})
+		q.workers = append(q.workers, stop)
+
+		q.running.Add(1)
+		q.alive.Add(1)
+		go q.worker(q.workerID, stop)
+		q.workerID++
+	}
+
+	for len(q.workers) > n {
+		last := len(q.workers) - 1
+
+		close(q.workers[last])
+		q.workers = q.workers[:last]
+	}
+
+	queueWorkers.Set(float64(len(q.workers)))
+
+	return nil
+}
+
+// SetJobTimeout changes the maximum time that each job can take, after which its context is canceled. A timeout of 0
+// removes the limit. The change applies to the jobs received afterwards.
+// Possible errors:
+//   - errorx.IllegalArgument: if `timeout` is negative.
+func (q *UpdateQueue) SetJobTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return errorx.IllegalArgument.New("the timeout of the jobs can't be negative")
+	}
+
+	q.jobTimeout.Store(int64(timeout))
+
+	return nil
+}
+
+// Workers returns the number of workers that process the jobs.
+func (q *UpdateQueue) Workers() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.workers)
 }
 
-func NewJob(p *models.Podcast) *Job {
-	j := Job{
-		Podcast: p,
-		Done:    make(chan struct{}),
+// Check returns an error if there are no workers running (e.g. the queue has been stopped), since the jobs sent to the
+// queue would never be processed. It's meant to be used as a health.CheckFunc.
+// Possible errors:
+//   - errorx.IllegalState: if there are no workers running.
+func (q *UpdateQueue) Check(_ context.Context) error {
+	if q.alive.Load() < 1 {
+		return errorx.IllegalState.New("there are no workers running on the update queue")
 	}
 
-	return &j
+	return nil
+}
+
+// Busy returns the number of workers that are processing a job.
+func (q *UpdateQueue) Busy() int {
+	return int(q.busy.Load())
+}
+
+// Stop stops all the workers and waits until they finish the jobs that they are processing, or until `ctx` is done.
+// It returns whether all the workers finished. The jobs sent after stopping the queue are never processed.
+func (q *UpdateQueue) Stop(ctx context.Context) bool {
+	q.mu.Lock()
+
+	if !q.stopped {
+		q.stopped = true
+		close(q.done)
+
+		for _, stop := range q.workers {
+			close(stop)
+		}
+
+		q.workers = nil
+		queueWorkers.Set(0)
+	}
+
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Send waits until a worker receives the job. If the queue has been stopped, the job is finished with an error,
+// which is also returned, since it would never be processed.
+// Possible errors:
+//   - errorx.IllegalState: if the queue has been stopped.
+func (q *UpdateQueue) Send(job *Job) error {
+	queuePending.Add(1)
+
+	select {
+	case q.q <- job:
+		return nil
+
+	case <-q.done:
+		queuePending.Add(-1)
+
+		err := errorx.IllegalState.New("the queue has been stopped")
+		job.Reject(err)
+
+		return err
+	}
 }
 
-func (q *UpdateQueue) worker(id int) {
+// worker processes the jobs sent to the queue until `stop` is closed.
+func (q *UpdateQueue) worker(id int, stop <-chan struct{}) {
+	defer q.running.Done()
+	defer q.alive.Add(-1)
+
 	log.WithField("worker", id).Debug("Worker started")
 
-	// Limit the frequency by which each episode is processed.
+	// Limit the frequency by which the new episodes are stored.
 	rateLimiter := time.NewTicker(time.Millisecond * 300)
 	defer rateLimiter.Stop()
 
 	for {
-		job := <-q.q
+		var job *Job
+
+		select {
+		case <-stop:
+			log.WithField("worker", id).Debug("Worker stopped")
+
+			return
+
+		case job = <-q.q:
+		}
+
 		receivedTime := time.Now()
+		q.busy.Add(1)
+		queuePending.Add(-1)
+		queueBusy.Add(1)
 
 		log.WithFields(log.Fields{
 			"worker":      id,
+			"jobID":       job.ID,
 			"podcastID":   job.Podcast.ID,
 			"podcastFeed": job.Podcast.FeedLink,
 		}).Info("New job received")
 
-		_, feed, err := podcasts.GetPodcastData(job.Podcast.FeedLink)
+		job.start()
+
+		ctx, span := tracing.Start(trace.ContextWithSpanContext(context.Background(), job.parent), "update.job",
+			trace.WithAttributes(
+				attribute.String("lincast.job_id", job.ID.String()),
+				attribute.String("lincast.job_type", string(job.Type)),
+				attribute.Int64("lincast.podcast_id", int64(job.Podcast.ID)),
+				attribute.Int("lincast.worker", id),
+			),
+		)
+
+		cancel := context.CancelFunc(func() {})
+		if timeout := time.Duration(q.jobTimeout.Load()); timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		err := q.processRecovering(ctx, id, job, rateLimiter)
+		cancel()
+
+		span.SetAttributes(attribute.Int("lincast.new_episodes", len(job.NewEpisodes())))
+		tracing.RecordError(span, err)
+		span.End()
+
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"jobID":       job.ID,
+			"podcastID":   job.Podcast.ID,
+			"podcastFeed": job.Podcast.FeedLink,
+		}).Debug("Job finished, closing the channel Job.Done")
+
+		// Notify that the job has been processed.
+		job.finish(err)
+		q.busy.Add(-1)
+		queueBusy.Add(-1)
+		recordJob(job, time.Since(receivedTime))
+
 		if err != nil {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"worker":         id,
+			"jobID":          job.ID,
+			"podcastID":      job.Podcast.ID,
+			"podcastFeed":    job.Podcast.FeedLink,
+			"newEpisodes":    len(job.NewEpisodes()),
+			"updateDuration": time.Since(receivedTime).String(),
+		}).Info("Podcast updated correctly")
+	}
+}
+
+// processRecovering calls process, turning a panic into an error, so a job that can't be processed (e.g. because of
+// an unexpected feed) is finished as failed instead of stopping LinCast.
+func (q *UpdateQueue) processRecovering(ctx context.Context, id int, job *Job, rateLimiter *time.Ticker) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		err = errorx.InternalError.New("the job panicked: %v", r)
+
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"jobID":       job.ID,
+			"podcastFeed": job.Podcast.FeedLink,
+			"error":       err,
+			"stack":       string(debug.Stack()),
+		}).Error("Panic when processing a job")
+
+		if job.Type == JobSubscribe {
+			job.setOutcome(OutcomeError)
+		}
+	}()
+
+	return q.process(ctx, id, job, rateLimiter)
+}
+
+// storeSubscription stores the given podcast (resolved from the feed requested by a job of type JobSubscribe) and
+// marks it as subscribed. If the podcast is already on the database, just the subscription is updated. The stored
+// podcast is set on the job.
+func (q *UpdateQueue) storeSubscription(ctx context.Context, id int, job *Job, p *models.Podcast) error {
+	// Some feeds don't reference themselves, so the URL used to reach them is the only one that we have.
+	if p.FeedLink == "" {
+		p.FeedLink = job.feedURL
+	}
+
+	db := q.dbInstance.WithContext(ctx)
+
+	var stored models.Podcast
+
+	// Archived podcasts are looked up too, so they are restored along with their listening history.
+	res := db.Unscoped().Where("feed_link = ?", p.FeedLink).Limit(1).Find(&stored)
+	if res.Error != nil {
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"podcastFeed": p.FeedLink,
+			"error":       errorx.EnsureStackTrace(res.Error),
+		}).Error("Error when checking if the URL of the podcast's feed is already in the database")
+
+		job.setOutcome(OutcomeError)
+
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		p.Group = job.Group
+		p.Subscribed = true
+
+		if res = db.Create(p); res.Error != nil {
 			log.WithFields(log.Fields{
 				"worker":      id,
-				"podcastID":   job.Podcast.ID,
-				"podcastFeed": job.Podcast.FeedLink,
-				"error":       errorx.EnsureStackTrace(err),
-			}).Error("Error when trying to obtain the feed")
+				"podcastFeed": p.FeedLink,
+				"error":       errorx.EnsureStackTrace(res.Error),
+			}).Error("Error when trying to store the new subscribed podcast")
 
-			continue
+			job.setOutcome(OutcomeError)
+
+			return res.Error
 		}
 
-		eps, err := podcasts.GetEpisodes(feed)
-		if err != nil {
+		job.setPodcast(p)
+		job.setOutcome(OutcomeCreated)
+
+		return nil
+	}
+
+	updates := map[string]interface{}{"subscribed": true}
+	if job.Group != "" {
+		updates["group_name"] = job.Group
+	}
+
+	if stored.DeletedAt.Valid {
+		updates["deleted_at"] = nil
+
+		res = db.Unscoped().Model(&models.Episode{}).Where("podcast_id = ?", stored.ID).Update("deleted_at", nil)
+		if res.Error != nil {
 			log.WithFields(log.Fields{
 				"worker":      id,
-				"podcastID":   job.Podcast.ID,
-				"podcastFeed": job.Podcast.FeedLink,
-				"error":       errorx.EnsureStackTrace(err),
-			}).Error("Error on episodes parsing")
+				"podcastID":   stored.ID,
+				"podcastFeed": stored.FeedLink,
+				"error":       errorx.EnsureStackTrace(res.Error),
+			}).Error("Error when trying to restore the episodes of an archived podcast")
 
-			continue
+			job.setOutcome(OutcomeError)
+
+			return res.Error
 		}
+	}
+
+	res = db.Unscoped().Model(&stored).Updates(updates)
+	if res.Error != nil {
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"podcastID":   stored.ID,
+			"podcastFeed": stored.FeedLink,
+			"error":       errorx.EnsureStackTrace(res.Error),
+		}).Error("Error when trying to update the subscription of a podcast")
+
+		job.setOutcome(OutcomeError)
+
+		return res.Error
+	}
+
+	job.setPodcast(&stored)
+	job.setOutcome(OutcomeAlreadySubscribed)
+
+	return nil
+}
 
-		for _, e := range *eps {
-			<-rateLimiter.C
-
-			// Check if the episode is already on the table.
-			result := q.dbInstance.Where("guid = ?", e.GUID).First(&models.Episode{})
-			if result.Error != nil {
-				// The only error that we expect to get here is one of type `gorm.ErrRecordNotFound` (which means
-				// basically that the episode is not stored on the database). So, if we get another type of error
-				// we should log it and skip the rest of the iteration.
-				if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					log.WithFields(log.Fields{
-						"worker":      id,
-						"podcastID":   job.Podcast.ID,
-						"podcastFeed": job.Podcast.FeedLink,
-						"episodeGUID": e.GUID,
-						"error":       errorx.EnsureStackTrace(err),
-					}).Error("Can't check if the episode already exists")
-
-					continue
-				}
-			} else {
-				// If there are no errors, then the episode with the given GUID exists so we should skip storage.
-				continue
+// process fetches the feed of the podcast referenced by the given job and stores the episodes that are not already on
+// the database. The stored episodes are registered on the job. If the job is of type JobSubscribe, the podcast is
+// stored too.
+func (q *UpdateQueue) process(ctx context.Context, id int, job *Job, rateLimiter *time.Ticker) error {
+	db := q.dbInstance.WithContext(ctx)
+
+	p, feed, err := podcasts.GetPodcastData(ctx, job.Podcast.FeedLink)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"podcastID":   job.Podcast.ID,
+			"podcastFeed": job.Podcast.FeedLink,
+			"error":       errorx.EnsureStackTrace(err),
+		}).Error("Error when trying to obtain the feed")
+
+		feedErrors.WithLabelValues(fetchErrorClass(err)).Inc()
+
+		if job.Type == JobSubscribe {
+			switch {
+			case errorx.IsOfType(err, podcasts.UnreachableError):
+				job.setOutcome(OutcomeUnreachable)
+			case errorx.IsOfType(err, podcasts.NotAFeedError):
+				job.setOutcome(OutcomeNotAFeed)
+			default:
+				job.setOutcome(OutcomeError)
 			}
+		}
+
+		return err
+	}
+
+	if job.Type == JobSubscribe {
+		if err := q.storeSubscription(ctx, id, job, p); err != nil {
+			return err
+		}
+	}
 
+	eps, err := podcasts.GetEpisodes(feed)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"podcastID":   job.Podcast.ID,
+			"podcastFeed": job.Podcast.FeedLink,
+			"error":       errorx.EnsureStackTrace(err),
+		}).Error("Error on episodes parsing")
+
+		feedErrors.WithLabelValues(errorClassInvalidEpisodes).Inc()
+
+		return err
+	}
+
+	// Load the GUIDs of the episodes already stored at once, so only the new episodes are throttled.
+	var storedGUIDs []string
+	res := db.Model(&models.Episode{}).Where("podcast_id = ?", job.Podcast.ID).Pluck("guid", &storedGUIDs)
+	if res.Error != nil {
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"podcastID":   job.Podcast.ID,
+			"podcastFeed": job.Podcast.FeedLink,
+			"error":       errorx.EnsureStackTrace(res.Error),
+		}).Error("Can't check which episodes already exist")
+
+		return errorx.InternalError.Wrap(res.Error, "the stored episodes can't be obtained")
+	}
+
+	stored := make(map[string]bool, len(storedGUIDs))
+	for _, guid := range storedGUIDs {
+		stored[guid] = true
+	}
+
+	newEpisodes := make([]models.Episode, 0, len(*eps))
+	for _, e := range *eps {
+		if stored[e.GUID] {
+			continue
+		}
+
+		// The feed could repeat a GUID, in which case only the first episode is stored.
+		stored[e.GUID] = true
+
+		// Set the ID of the parent podcast before store the episode.
+		e.PodcastID = job.Podcast.ID
+		newEpisodes = append(newEpisodes, e)
+	}
+
+	for len(newEpisodes) > 0 {
+		select {
+		case <-rateLimiter.C:
+		case <-ctx.Done():
 			log.WithFields(log.Fields{
 				"worker":      id,
 				"podcastID":   job.Podcast.ID,
 				"podcastFeed": job.Podcast.FeedLink,
-				"episodeGUID": e.GUID,
-			}).Debug("Episode is not in the database, storing")
-
-			// Set the ID of the parent podcast before store the episode (if is not already on the db).
-			e.PodcastID = job.Podcast.ID
-
-			result = q.dbInstance.Create(&e)
-			if result.Error != nil || result.RowsAffected == 0 {
-				log.WithFields(log.Fields{
-					"worker":      id,
-					"podcastID":   job.Podcast.ID,
-					"podcastFeed": job.Podcast.FeedLink,
-					"episodeGUID": e.GUID,
-					"error":       errorx.EnsureStackTrace(result.Error),
-				}).Error("The new episode can't be stored")
-
-				continue
-			}
+			}).Error("The job has timed out while storing the episodes")
+
+			return errorx.TimeoutElapsed.Wrap(ctx.Err(), "the job didn't finish in time")
 		}
 
-		result := q.dbInstance.Model(job.Podcast).Update("last_check", time.Now())
-		if result.Error != nil {
+		batch := newEpisodes[:min(len(newEpisodes), episodesPerInsert)]
+		newEpisodes = newEpisodes[len(batch):]
+
+		log.WithFields(log.Fields{
+			"worker":      id,
+			"podcastID":   job.Podcast.ID,
+			"podcastFeed": job.Podcast.FeedLink,
+			"episodes":    len(batch),
+		}).Debug("Episodes are not in the database, storing")
+
+		if res := db.Create(&batch); res.Error != nil {
 			log.WithFields(log.Fields{
 				"worker":      id,
 				"podcastID":   job.Podcast.ID,
 				"podcastFeed": job.Podcast.FeedLink,
-				"error":       errorx.EnsureStackTrace(result.Error),
-			}).Error("The last_check time of the podcast can't be updated")
+				"episodes":    len(batch),
+				"error":       errorx.EnsureStackTrace(res.Error),
+			}).Error("The new episodes can't be stored")
 
 			continue
 		}
 
+		for _, e := range batch {
+			job.addEpisode(e)
+		}
+
+		episodesIngested.Add(float64(len(batch)))
+	}
+
+	result := db.Model(job.Podcast).Update("last_check", time.Now())
+	if result.Error != nil {
 		log.WithFields(log.Fields{
 			"worker":      id,
 			"podcastID":   job.Podcast.ID,
 			"podcastFeed": job.Podcast.FeedLink,
-		}).Debug("Job finished, sending notification through the channel Job.Done")
-
-		// Notify that the job has been processed without blocking.
-		select {
-		case job.Done <- struct{}{}:
-		default:
-		}
+			"error":       errorx.EnsureStackTrace(result.Error),
+		}).Error("The last_check time of the podcast can't be updated")
 
-		log.WithFields(log.Fields{
-			"worker":         id,
-			"podcastID":      job.Podcast.ID,
-			"podcastFeed":    job.Podcast.FeedLink,
-			"updateDuration": time.Since(receivedTime).String(),
-		}).Info("Podcast updated correctly")
+		return result.Error
 	}
+
+	return nil
 }
@@ -0,0 +1,251 @@
+package update
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lincast/database"
+	"lincast/models"
+
+	"github.com/joomcode/errorx"
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestUpdateQueueSetWorkers(t *testing.T) {
+	assert := assert2.New(t)
+
+	q, err := NewUpdateQueue(&gorm.DB{}, 2)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(2, q.Workers())
+
+	assert.NoError(q.SetWorkers(5))
+	assert.Equal(5, q.Workers(), "the new workers should be started")
+
+	assert.NoError(q.SetWorkers(1))
+	assert.Equal(1, q.Workers(), "the last workers should be stopped")
+
+	assert.Error(q.SetWorkers(0), "the queue should have at least one worker")
+	assert.Error(q.SetWorkers(MaxWorkers+1), "the queue should not have more than MaxWorkers workers")
+	assert.Equal(1, q.Workers(), "the workers should not change on error")
+	assert.Equal(0, q.Busy(), "the workers should be idle")
+	assert.NoError(q.Check(context.Background()), "a queue with workers should pass the check")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.True(q.Stop(ctx), "the idle workers should stop right away")
+	assert.Equal(0, q.Workers())
+	assert.Error(q.SetWorkers(2), "the workers of a stopped queue can't be changed")
+	assert.Error(q.Check(context.Background()), "a stopped queue should fail the check")
+
+	_, err = NewUpdateQueue(&gorm.DB{}, 0)
+	assert.Error(err)
+}
+
+// minimalFeed is a valid feed without optional elements like <author> or <image>.
+const minimalFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Minimal</title>
+    <item>
+      <title>Episode 1</title>
+      <guid>minimal-1</guid>
+      <enclosure url="https://example.com/1.mp3" type="audio/mpeg" length="1"/>
+    </item>
+  </channel>
+</rss>`
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(database.Models...); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestUpdateQueueSubscribe(t *testing.T) {
+	assert := assert2.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(minimalFeed))
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+
+	q, err := NewUpdateQueue(db, 1)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	defer q.Stop(ctx)
+
+	job := NewSubscriptionJob(server.URL)
+	if !assert.NoError(q.Send(job)) {
+		return
+	}
+
+	if !assert.True(job.Wait(ctx), "the job should finish") {
+		return
+	}
+
+	assert.Equal(JobDone, job.Status(), "a feed without author nor image should be processed: %v", job.Err())
+	assert.Equal(OutcomeCreated, job.Outcome())
+
+	var stored models.Podcast
+	if assert.NoError(db.Where("feed_link = ?", server.URL).First(&stored).Error) {
+		assert.Equal("Minimal", stored.Title)
+		assert.True(stored.Subscribed, "the new podcast should be stored as subscribed")
+	}
+}
+
+func TestUpdateQueueJobTimeout(t *testing.T) {
+	assert := assert2.New(t)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	q, err := NewUpdateQueue(newTestDB(t), 1)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Error(q.SetJobTimeout(-time.Second), "a negative timeout should be rejected")
+	assert.NoError(q.SetJobTimeout(time.Millisecond * 100))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	defer q.Stop(ctx)
+
+	job := NewSubscriptionJob(server.URL)
+	if !assert.NoError(q.Send(job)) {
+		return
+	}
+
+	if !assert.True(job.Wait(ctx), "the job should be canceled when its timeout elapses") {
+		return
+	}
+
+	assert.Equal(JobFailed, job.Status())
+}
+
+func TestUpdateQueueSendAfterStop(t *testing.T) {
+	assert := assert2.New(t)
+
+	q, err := NewUpdateQueue(newTestDB(t), 1)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.True(q.Stop(context.Background()))
+
+	job := NewSubscriptionJob("https://example.com/feed.xml")
+
+	sent := make(chan error, 1)
+	go func() { sent <- q.Send(job) }()
+
+	select {
+	case err := <-sent:
+		assert.True(errorx.IsOfType(err, errorx.IllegalState), "sending to a stopped queue should fail")
+	case <-time.After(time.Second * 5):
+		assert.FailNow("sending to a stopped queue should not block")
+	}
+
+	assert.True(job.Finished(), "the job should be finished, so waiting for it doesn't block")
+	assert.Equal(JobFailed, job.Status())
+	assert.Equal(OutcomeError, job.Outcome())
+}
+
+func TestUpdateQueueLargeFeed(t *testing.T) {
+	assert := assert2.New(t)
+
+	// More episodes than the ones that could be throttled one by one within DefaultJobTimeout.
+	const items = 1500
+
+	var feed strings.Builder
+	feed.WriteString(`<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>Large</title>`)
+	for i := range items {
+		fmt.Fprintf(&feed, `<item><title>Episode %d</title><guid>large-%d</guid>`+
+			`<enclosure url="https://example.com/%d.mp3" type="audio/mpeg" length="1"/></item>`, i, i, i)
+	}
+	feed.WriteString(`</channel></rss>`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(feed.String()))
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+
+	// A mirror of the feed, whose episodes share the GUIDs.
+	mirror := models.Podcast{FeedLink: "https://example.com/mirror.xml"}
+	db.Create(&mirror)
+	db.Create(&models.Episode{PodcastID: mirror.ID, GUID: "large-0"})
+
+	q, err := NewUpdateQueue(db, 1)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	defer q.Stop(ctx)
+
+	job := NewSubscriptionJob(server.URL)
+	if !assert.NoError(q.Send(job)) || !assert.True(job.Wait(ctx), "the job should finish") {
+		return
+	}
+
+	assert.Equal(JobDone, job.Status(), "a large feed should be stored within the default timeout: %v", job.Err())
+	assert.Len(job.NewEpisodes(), items, "the episodes of another podcast with the same GUIDs should not be skipped")
+
+	var stored models.Podcast
+	if !assert.NoError(db.Where("feed_link = ?", server.URL).First(&stored).Error) {
+		return
+	}
+
+	// The episodes already stored are not throttled.
+	start := time.Now()
+
+	job = NewJob(&stored)
+	if !assert.NoError(q.Send(job)) || !assert.True(job.Wait(ctx), "the job should finish") {
+		return
+	}
+
+	assert.Equal(JobDone, job.Status(), "%v", job.Err())
+	assert.Empty(job.NewEpisodes())
+	assert.Less(time.Since(start), time.Second*5)
+
+	var count int64
+	db.Model(&models.Episode{}).Where("podcast_id = ?", stored.ID).Count(&count)
+	assert.EqualValues(items, count)
+}
@@ -0,0 +1,174 @@
+package update
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleParser parses standard cron expressions (minute, hour, day of month, month and day of week) and descriptors
+// like "@hourly" or "@every 30m".
+var scheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Schedule determines when the feeds should be updated. It's composed by one or more cron expressions, and a feed is
+// due when any of them is.
+type Schedule struct {
+	expr      string
+	schedules []cron.Schedule
+}
+
+// ParseSchedule parses the given expression, that may contain several cron expressions separated by ";". For example,
+// "0 * * * *; */10 6-10 * * MON" means every hour, and every 10 minutes on Monday mornings.
+// Possible errors:
+//   - errorx.IllegalFormat: if any of the expressions can't be parsed.
+func ParseSchedule(expr string) (Schedule, error) {
+	s := Schedule{expr: strings.TrimSpace(expr)}
+
+	for _, e := range strings.Split(expr, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		sched, err := scheduleParser.Parse(e)
+		if err != nil {
+			return Schedule{}, errorx.IllegalFormat.Wrap(err, "invalid cron expression '%s'", e)
+		}
+
+		s.schedules = append(s.schedules, sched)
+	}
+
+	if len(s.schedules) == 0 {
+		return Schedule{}, errorx.IllegalFormat.New("the schedule is empty")
+	}
+
+	return s, nil
+}
+
+// EverySchedule returns a Schedule that is due once each `d`.
+func EverySchedule(d time.Duration) Schedule {
+	return Schedule{
+		expr:      "@every " + d.String(),
+		schedules: []cron.Schedule{cron.Every(d)},
+	}
+}
+
+// Next returns the first time after `t` at which the schedule is due.
+func (s Schedule) Next(t time.Time) time.Time {
+	var next time.Time
+
+	for _, sched := range s.schedules {
+		n := sched.Next(t)
+		if next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+
+	return next
+}
+
+// IsZero reports whether the schedule is empty.
+func (s Schedule) IsZero() bool {
+	return len(s.schedules) == 0
+}
+
+func (s Schedule) String() string {
+	return s.expr
+}
+
+// window is a daily period of time, expressed in minutes since midnight. If end is lower than start, the window
+// crosses midnight.
+type window struct {
+	start int
+	end   int
+}
+
+// QuietHours is a set of daily windows during which the feeds should not be updated.
+type QuietHours struct {
+	expr    string
+	windows []window
+}
+
+// ParseQuietHours parses a comma separated list of windows in the format "HH:MM-HH:MM", like "01:00-06:00". Windows
+// that cross midnight (e.g. "23:00-02:00") are allowed. An empty string means no quiet hours at all.
+// Possible errors:
+//   - errorx.IllegalFormat: if any of the windows can't be parsed.
+func ParseQuietHours(expr string) (QuietHours, error) {
+	q := QuietHours{expr: strings.TrimSpace(expr)}
+
+	for _, w := range strings.Split(expr, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+
+		bounds := strings.Split(w, "-")
+		if len(bounds) != 2 {
+			return QuietHours{}, errorx.IllegalFormat.New("invalid quiet hours window '%s', expected 'HH:MM-HH:MM'", w)
+		}
+
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return QuietHours{}, errorx.Decorate(err, "invalid quiet hours window '%s'", w)
+		}
+
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return QuietHours{}, errorx.Decorate(err, "invalid quiet hours window '%s'", w)
+		}
+
+		if start == end {
+			return QuietHours{}, errorx.IllegalFormat.New("the quiet hours window '%s' is empty", w)
+		}
+
+		q.windows = append(q.windows, window{start: start, end: end})
+	}
+
+	return q, nil
+}
+
+// Contains reports whether `t` (in its own location) is inside any of the windows.
+func (q QuietHours) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+
+	for _, w := range q.windows {
+		if w.start < w.end {
+			if m >= w.start && m < w.end {
+				return true
+			}
+		} else if m >= w.start || m < w.end { // The window crosses midnight.
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsZero reports whether there are no quiet hours.
+func (q QuietHours) IsZero() bool {
+	return len(q.windows) == 0
+}
+
+func (q QuietHours) String() string {
+	return q.expr
+}
+
+// parseClock returns the minutes since midnight of the given time, formatted as "HH:MM".
+func parseClock(s string) (int, error) {
+	var h, m int
+
+	s = strings.TrimSpace(s)
+
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 {
+		return 0, errorx.IllegalFormat.New("invalid time '%s', expected 'HH:MM'", s)
+	}
+
+	if h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, errorx.IllegalFormat.New("time '%s' out of range", s)
+	}
+
+	return h*60 + m, nil
+}
@@ -0,0 +1,74 @@
+package update
+
+import (
+	"testing"
+	"time"
+
+	assert2 "github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	assert := assert2.New(t)
+
+	s, err := ParseSchedule("0 * * * *; */10 6-10 * * MON")
+	if !assert.NoError(err, "a valid schedule should be parsed without errors") {
+		return
+	}
+
+	// Sunday 2024-01-07 12:05.
+	sunday := time.Date(2024, 1, 7, 12, 5, 0, 0, time.Local)
+	assert.Equal(time.Date(2024, 1, 7, 13, 0, 0, 0, time.Local), s.Next(sunday), "outside of the "+
+		"Monday mornings the feeds should be updated every hour")
+
+	// Monday 2024-01-08 07:05.
+	monday := time.Date(2024, 1, 8, 7, 5, 0, 0, time.Local)
+	assert.Equal(time.Date(2024, 1, 8, 7, 10, 0, 0, time.Local), s.Next(monday), "on Monday mornings "+
+		"the feeds should be updated every 10 minutes")
+
+	_, err = ParseSchedule("@every 15m")
+	assert.NoError(err, "descriptors should be supported")
+
+	_, err = ParseSchedule("61 * * * *")
+	assert.Error(err, "invalid expressions should be rejected")
+
+	_, err = ParseSchedule(" ; ")
+	assert.Error(err, "empty schedules should be rejected")
+}
+
+func TestEverySchedule(t *testing.T) {
+	assert := assert2.New(t)
+
+	now := time.Date(2024, 1, 7, 12, 5, 0, 0, time.Local)
+
+	assert.Equal(now.Add(time.Minute*30), EverySchedule(time.Minute*30).Next(now))
+}
+
+func TestParseQuietHours(t *testing.T) {
+	assert := assert2.New(t)
+
+	q, err := ParseQuietHours("01:00-06:00, 23:30-00:15")
+	if !assert.NoError(err, "valid quiet hours should be parsed without errors") {
+		return
+	}
+
+	at := func(h, m int) time.Time {
+		return time.Date(2024, 1, 7, h, m, 0, 0, time.Local)
+	}
+
+	assert.True(q.Contains(at(1, 0)), "the start of a window should be included")
+	assert.True(q.Contains(at(5, 59)))
+	assert.False(q.Contains(at(6, 0)), "the end of a window should not be included")
+	assert.False(q.Contains(at(12, 0)))
+	assert.True(q.Contains(at(23, 45)), "windows that cross midnight should be supported")
+	assert.True(q.Contains(at(0, 10)), "windows that cross midnight should be supported")
+	assert.False(q.Contains(at(0, 15)))
+
+	q, err = ParseQuietHours("")
+	assert.NoError(err, "empty quiet hours should be allowed")
+	assert.True(q.IsZero())
+
+	for _, invalid := range []string{"01:00", "25:00-06:00", "1:00-06:00", "01:00-01:00", "aa:bb-06:00"} {
+		_, err = ParseQuietHours(invalid)
+		assert.Errorf(err, "'%s' should be rejected", invalid)
+	}
+}
@@ -0,0 +1,265 @@
+package update
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"lincast/models"
+	"lincast/tracing"
+
+	"github.com/joomcode/errorx"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tickInterval is the frequency with which the Scheduler checks if there are feeds that should be updated. Since
+// the resolution of the cron expressions is of one minute, there is no reason to use a lower value.
+const tickInterval = time.Minute
+
+// stalledAfter is how long the Scheduler can go without making progress (checking the feeds or sending one of them to
+// the queue) before being considered stalled. It's generous, since sending a podcast to the queue blocks while all the
+// workers are busy, and a job can take minutes on feeds with lots of episodes.
+const stalledAfter = time.Minute * 15
+
+// ManualQueueSize is the capacity of the channel through which the manual jobs are sent to Scheduler.Run. The jobs
+// that don't fit should be rejected instead of blocking the sender.
+const ManualQueueSize = 256
+
+// Scheduler sends the subscribed podcasts to an UpdateQueue when their schedule is due. Each podcast can define its
+// own schedule and quiet hours (see models.Podcast), otherwise the global ones are used.
+type Scheduler struct {
+	db    *gorm.DB
+	queue *UpdateQueue
+
+	mu         sync.RWMutex
+	schedule   Schedule
+	quietHours QuietHours
+
+	// lastProgress is when the scheduler made progress for the last time (as Unix nanoseconds), 0 if it's not running.
+	lastProgress atomic.Int64
+
+	// lastQueued stores when each podcast was sent to the queue for the last time, so a podcast whose update failed
+	// (and so, their last check has not changed) is not sent again until it's due.
+	lastQueued map[uint]time.Time
+}
+
+// NewScheduler returns a new Scheduler that will send the podcasts stored on `db` to `queue` using the given global
+// schedule and quiet hours.
+func NewScheduler(db *gorm.DB, queue *UpdateQueue, schedule Schedule, quietHours QuietHours) (*Scheduler, error) {
+	if db == nil {
+		return nil, errorx.IllegalState.New("the instance of the database is nil")
+	}
+
+	if queue == nil {
+		return nil, errorx.IllegalState.New("the update queue is nil")
+	}
+
+	if schedule.IsZero() {
+		return nil, errorx.IllegalArgument.New("the schedule can't be empty")
+	}
+
+	s := Scheduler{
+		db:         db,
+		queue:      queue,
+		schedule:   schedule,
+		quietHours: quietHours,
+		lastQueued: make(map[uint]time.Time),
+	}
+
+	return &s, nil
+}
+
+// SetSchedule changes the global schedule and quiet hours, which are used from the next check of the feeds.
+// Possible errors:
+//   - errorx.IllegalArgument: if the schedule is empty.
+func (s *Scheduler) SetSchedule(schedule Schedule, quietHours QuietHours) error {
+	if schedule.IsZero() {
+		return errorx.IllegalArgument.New("the schedule can't be empty")
+	}
+
+	s.mu.Lock()
+	s.schedule, s.quietHours = schedule, quietHours
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Check returns an error if the scheduler is not running or it has not made progress in a while, which means that the
+// feeds are not being updated. It's meant to be used as a health.CheckFunc.
+// Possible errors:
+//   - errorx.IllegalState: if the scheduler is not running or it's stalled.
+func (s *Scheduler) Check(_ context.Context) error {
+	last := s.lastProgress.Load()
+	if last == 0 {
+		return errorx.IllegalState.New("the scheduler of the updates is not running")
+	}
+
+	if since := time.Since(time.Unix(0, last)); since > stalledAfter {
+		return errorx.IllegalState.New("the scheduler of the updates has not made progress in %s", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// progress registers that the scheduler has made progress.
+func (s *Scheduler) progress() {
+	s.lastProgress.Store(time.Now().UnixNano())
+}
+
+// Run starts the loop of the scheduler, blocking the caller. The jobs received through `manual` are sent to the queue
+// as soon as possible, without taking into account the schedule nor the quiet hours.
+func (s *Scheduler) Run(manual <-chan *Job) {
+	s.mu.RLock()
+	log.WithFields(log.Fields{
+		"schedule":   s.schedule.String(),
+		"quietHours": s.quietHours.String(),
+	}).Debug("Starting feeds' update loop")
+	s.mu.RUnlock()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	// The manual jobs are sent on their own, so they don't wait until all the podcasts due on a tick are sent.
+	go s.sendManual(manual)
+
+	s.progress()
+
+	log.Info("Updating feeds for first time since LinCast is running")
+	s.tick(time.Now(), true)
+
+	for now := range ticker.C {
+		s.tick(now, false)
+	}
+}
+
+// sendManual sends to the queue the jobs received through `manual` until the channel is closed.
+func (s *Scheduler) sendManual(manual <-chan *Job) {
+	for j := range manual {
+		log.WithFields(log.Fields{
+			"jobID":       j.ID,
+			"podcastFeed": j.Podcast.FeedLink,
+			"podcastID":   j.Podcast.ID,
+		}).Info("Sending podcast to the update queue (manual update)")
+
+		if err := s.queue.Send(j); err != nil {
+			log.WithFields(log.Fields{
+				"jobID": j.ID,
+				"error": errorx.EnsureStackTrace(err),
+			}).Error("The podcast can't be sent to the update queue")
+		}
+
+		s.progress()
+	}
+}
+
+// tick sends to the queue the podcasts that are due at `now`. If `all` is true, every podcast that is not in its quiet
+// hours is sent, regardless of its schedule.
+func (s *Scheduler) tick(now time.Time, all bool) {
+	s.progress()
+
+	// The jobs sent on the tick are part of its trace.
+	ctx, span := tracing.Start(context.Background(), "update.schedule",
+		trace.WithAttributes(attribute.Bool("lincast.all", all)))
+	defer span.End()
+
+	var subscribedPodcasts []models.Podcast
+	if res := s.db.WithContext(ctx).Where("subscribed", true).Find(&subscribedPodcasts); res.Error != nil {
+		log.WithField("error", errorx.InternalError.Wrap(res.Error, "error trying to get subscribed podcasts")).
+			Error("Error when trying to update podcasts' feeds")
+		tracing.RecordError(span, res.Error)
+
+		return
+	}
+
+	for i := range subscribedPodcasts {
+		p := &subscribedPodcasts[i]
+
+		schedule, quietHours := s.podcastSchedule(p)
+
+		if quietHours.Contains(now) {
+			continue
+		}
+
+		if !all && schedule.Next(s.lastUpdate(p)).After(now) {
+			continue
+		}
+
+		j := NewJob(p)
+		j.SetParent(ctx)
+
+		log.WithFields(log.Fields{
+			"jobID":       j.ID,
+			"podcastFeed": p.FeedLink,
+			"podcastID":   p.ID,
+		}).Info("Sending podcast to the update queue")
+
+		s.mu.Lock()
+		s.lastQueued[p.ID] = now
+		s.mu.Unlock()
+
+		if err := s.queue.Send(j); err != nil {
+			log.WithFields(log.Fields{
+				"jobID": j.ID,
+				"error": errorx.EnsureStackTrace(err),
+			}).Error("The podcast can't be sent to the update queue")
+			tracing.RecordError(span, err)
+
+			return
+		}
+
+		s.progress()
+	}
+}
+
+// lastUpdate returns the last time that the given podcast was checked or sent to the queue.
+func (s *Scheduler) lastUpdate(p *models.Podcast) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	last := p.LastCheck
+	if q, ok := s.lastQueued[p.ID]; ok && q.After(last) {
+		last = q
+	}
+
+	return last
+}
+
+// podcastSchedule returns the schedule and quiet hours that should be used with the given podcast. If the podcast
+// doesn't define them (or they are invalid), the global ones are returned.
+func (s *Scheduler) podcastSchedule(p *models.Podcast) (Schedule, QuietHours) {
+	s.mu.RLock()
+	schedule, quietHours := s.schedule, s.quietHours
+	s.mu.RUnlock()
+
+	if p.UpdateSchedule != "" {
+		ps, err := ParseSchedule(p.UpdateSchedule)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"podcastID": p.ID,
+				"schedule":  p.UpdateSchedule,
+				"error":     err.Error(),
+			}).Warning("Invalid update schedule of the podcast, using the global one")
+		} else {
+			schedule = ps
+		}
+	}
+
+	if p.QuietHours != "" {
+		pq, err := ParseQuietHours(p.QuietHours)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"podcastID":  p.ID,
+				"quietHours": p.QuietHours,
+				"error":      err.Error(),
+			}).Warning("Invalid quiet hours of the podcast, using the global ones")
+		} else {
+			quietHours = pq
+		}
+	}
+
+	return schedule, quietHours
+}
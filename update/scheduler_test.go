@@ -0,0 +1,32 @@
+package update
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	assert2 "github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSchedulerCheck(t *testing.T) {
+	assert := assert2.New(t)
+
+	q, err := NewUpdateQueue(&gorm.DB{}, 1)
+	if !assert.NoError(err) {
+		return
+	}
+
+	s, err := NewScheduler(&gorm.DB{}, q, EverySchedule(time.Minute), QuietHours{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Error(s.Check(context.Background()), "a scheduler that is not running should fail the check")
+
+	s.progress()
+	assert.NoError(s.Check(context.Background()))
+
+	s.lastProgress.Store(time.Now().Add(-stalledAfter - time.Minute).UnixNano())
+	assert.Error(s.Check(context.Background()), "a stalled scheduler should fail the check")
+}
@@ -0,0 +1,123 @@
+package update
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRetention is the time during which a finished job is kept on a Tracker.
+const DefaultRetention = time.Hour
+
+// Tracker keeps a reference to the jobs (and batches of jobs) that have been requested, so their status can be
+// consulted later using their ID. Finished jobs are discarded once the retention time has passed.
+type Tracker struct {
+	mu        sync.RWMutex
+	jobs      map[uuid.UUID]*Job
+	batches   map[uuid.UUID]*Batch
+	retention time.Duration
+}
+
+// NewTracker returns a new Tracker that will keep the finished jobs during the given retention time.
+func NewTracker(retention time.Duration) *Tracker {
+	return &Tracker{
+		jobs:      make(map[uuid.UUID]*Job),
+		batches:   make(map[uuid.UUID]*Batch),
+		retention: retention,
+	}
+}
+
+// AddJob starts tracking the given job.
+func (t *Tracker) AddJob(j *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+	t.jobs[j.ID] = j
+}
+
+// AddBatch starts tracking the given batch and all the jobs that it contains.
+func (t *Tracker) AddBatch(b *Batch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+	t.batches[b.ID] = b
+
+	for _, j := range b.Jobs {
+		t.jobs[j.ID] = j
+	}
+}
+
+// Job returns the tracked job with the given ID, if any.
+func (t *Tracker) Job(id uuid.UUID) (*Job, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	j, ok := t.jobs[id]
+
+	return j, ok
+}
+
+// Batch returns the tracked batch with the given ID, if any.
+func (t *Tracker) Batch(id uuid.UUID) (*Batch, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	b, ok := t.batches[id]
+
+	return b, ok
+}
+
+// AddSubscriptionJob starts tracking the given job of type JobSubscribe, unless there is another one for the same
+// feed that has not finished yet. In that case, the existing job is returned and the given one is discarded. The
+// boolean returned reports whether the given job has been added.
+func (t *Tracker) AddSubscriptionJob(j *Job) (*Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, existing := range t.jobs {
+		if existing.Type == JobSubscribe && existing.feedURL == j.feedURL && !existing.Finished() {
+			return existing, false
+		}
+	}
+
+	t.prune()
+	t.jobs[j.ID] = j
+
+	return j, true
+}
+
+// prune removes the jobs and batches that finished before the retention time. The caller must hold the lock.
+func (t *Tracker) prune() {
+	limit := time.Now().Add(-t.retention)
+
+	for id, j := range t.jobs {
+		if expired(j, limit) {
+			delete(t.jobs, id)
+		}
+	}
+
+	for id, b := range t.batches {
+		allExpired := true
+		for _, j := range b.Jobs {
+			if !expired(j, limit) {
+				allExpired = false
+
+				break
+			}
+		}
+
+		if allExpired {
+			delete(t.batches, id)
+		}
+	}
+}
+
+func expired(j *Job, limit time.Time) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return !j.finishedAt.IsZero() && j.finishedAt.Before(limit)
+}